@@ -0,0 +1,331 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/rand"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	zest "github.com/me-box/goZestClient"
+)
+
+//benchBuckets are the upper bounds of the latency histogram buckets
+var benchBuckets = []time.Duration{
+	time.Millisecond,
+	2 * time.Millisecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	20 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	200 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+}
+
+type benchSample struct {
+	write   bool
+	latency time.Duration
+	errKey  string
+}
+
+type benchBucket struct {
+	LessThanMS float64 `json:"lt_ms,omitempty"`
+	Count      int     `json:"count"`
+}
+
+type benchLatency struct {
+	Count     int           `json:"count"`
+	MeanMS    float64       `json:"mean_ms"`
+	P50MS     float64       `json:"p50_ms"`
+	P95MS     float64       `json:"p95_ms"`
+	P99MS     float64       `json:"p99_ms"`
+	MaxMS     float64       `json:"max_ms"`
+	Histogram []benchBucket `json:"histogram"`
+}
+
+type benchReport struct {
+	Concurrency   int                      `json:"concurrency"`
+	Rate          float64                  `json:"rate"`
+	DurationS     float64                  `json:"duration_s"`
+	PayloadSize   int                      `json:"payload_size"`
+	ReadRatio     float64                  `json:"read_ratio"`
+	Paths         []string                 `json:"paths"`
+	Requests      int                      `json:"requests"`
+	Errors        int                      `json:"errors"`
+	ErrorsByCode  map[string]int           `json:"errors_by_code"`
+	ThroughputRPS float64                  `json:"throughput_rps"`
+	Latency       map[string]*benchLatency `json:"latency"`
+}
+
+func runBench(name string, args []string) error {
+	fs, conn := newFlagSet(name, "<path> [path...]", "Send a mix of reads and writes to one or more paths and report throughput, errors and latency.")
+	concurrency := fs.Int("concurrency", 1, "number of concurrent workers")
+	rate := fs.Float64("rate", 0, "total requests per second across all workers, 0 for as fast as possible")
+	duration := fs.Duration("duration", time.Second*10, "how long to run for")
+	payloadSize := fs.Int("payload-size", 64, "size in bytes of each written payload")
+	readRatio := fs.Float64("read-ratio", 0.5, "fraction of requests that are reads, from 0 to 1")
+	readSuffix := fs.String("read-suffix", "", "appended to the path for reads, e.g. /latest for a time series")
+	asJSON := fs.Bool("json", false, "print the report as JSON")
+
+	paths, err := parseArgs(fs, args, -1)
+	if err != nil {
+		return err
+	}
+	if len(paths) == 0 {
+		fs.Usage()
+		return usageError{"at least one path is needed"}
+	}
+	err = checkBenchFlags(*concurrency, *rate, *duration, *readRatio, *payloadSize)
+	if err != nil {
+		return err
+	}
+
+	zestC, err := conn.client()
+	if err != nil {
+		return err
+	}
+	defer zestC.Close()
+
+	ctx, cancel := interrupted()
+	defer cancel()
+
+	//requests are paced by a shared ticker when a rate is set
+	var tick <-chan time.Time
+	if *rate > 0 {
+		ticker := time.NewTicker(time.Duration(float64(time.Second) / *rate))
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	end := time.After(*duration)
+	stop := make(chan struct{})
+	go func() {
+		select {
+		case <-end:
+		case <-ctx.Done():
+		}
+		close(stop)
+	}()
+
+	results := make([][]benchSample, *concurrency)
+	var wg sync.WaitGroup
+	start := time.Now()
+	for w := 0; w < *concurrency; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			rnd := rand.New(rand.NewSource(time.Now().UnixNano() + int64(w)))
+			for seq := 0; ; seq++ {
+				if tick != nil {
+					select {
+					case <-tick:
+					case <-stop:
+						return
+					}
+				} else {
+					select {
+					case <-stop:
+						return
+					default:
+					}
+				}
+
+				path := paths[rnd.Intn(len(paths))]
+				sample := benchSample{write: rnd.Float64() >= *readRatio}
+				began := time.Now()
+				var err error
+				if sample.write {
+					_, err = zestC.Post(*conn.token, path, benchPayload(*conn.format, *payloadSize, w, seq), *conn.format)
+				} else {
+					_, err = zestC.Get(*conn.token, path+*readSuffix, *conn.format)
+				}
+				sample.latency = time.Since(began)
+				if err != nil {
+					sample.errKey = benchErrorKey(err)
+				}
+				results[w] = append(results[w], sample)
+			}
+		}(w)
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+
+	report := benchReport{
+		Concurrency:  *concurrency,
+		Rate:         *rate,
+		DurationS:    elapsed.Seconds(),
+		PayloadSize:  *payloadSize,
+		ReadRatio:    *readRatio,
+		Paths:        paths,
+		ErrorsByCode: map[string]int{},
+		Latency:      map[string]*benchLatency{},
+	}
+	byKind := map[string][]time.Duration{}
+	for _, samples := range results {
+		for _, s := range samples {
+			report.Requests++
+			if s.errKey != "" {
+				report.Errors++
+				report.ErrorsByCode[s.errKey]++
+			}
+			kind := "read"
+			if s.write {
+				kind = "write"
+			}
+			byKind[kind] = append(byKind[kind], s.latency)
+			byKind["all"] = append(byKind["all"], s.latency)
+		}
+	}
+	for kind, latencies := range byKind {
+		report.Latency[kind] = summarise(latencies)
+	}
+	report.ThroughputRPS = float64(report.Requests) / elapsed.Seconds()
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(report)
+	}
+	printBenchReport(report)
+	return nil
+}
+
+//maxBenchRate is the fastest rate a ticker can pace, one request a nanosecond
+const maxBenchRate = float64(time.Second)
+
+//checkBenchFlags refuses settings bench can't run with, such as a rate too
+//fast, or not a number, for the ticker that paces requests
+func checkBenchFlags(concurrency int, rate float64, duration time.Duration, readRatio float64, payloadSize int) error {
+	if concurrency < 1 {
+		return usageError{"concurrency must be at least 1"}
+	}
+	if math.IsNaN(rate) || rate < 0 || rate > maxBenchRate {
+		return usageError{"rate must be between 0 and " + strconv.FormatFloat(maxBenchRate, 'g', -1, 64) + " requests per second"}
+	}
+	if duration <= 0 {
+		return usageError{"duration must be positive"}
+	}
+	if math.IsNaN(readRatio) || readRatio < 0 || readRatio > 1 {
+		return usageError{"read-ratio must be between 0 and 1"}
+	}
+	if payloadSize < 0 {
+		return usageError{"payload-size can't be negative"}
+	}
+	return nil
+}
+
+//benchPayload makes a payload of about size bytes that is valid for format
+func benchPayload(format string, size int, worker int, seq int) []byte {
+	if f, ok := zest.LookupContentFormat(format); ok && f.ID == 50 {
+		head := `{"worker":` + strconv.Itoa(worker) + `,"seq":` + strconv.Itoa(seq) + `,"data":"`
+		pad := size - len(head) - 2
+		if pad < 0 {
+			pad = 0
+		}
+		return []byte(head + strings.Repeat("x", pad) + `"}`)
+	}
+	return []byte(strings.Repeat("x", size))
+}
+
+//benchErrorKey groups errors by Zest response code, e.g. 4.01, or by kind
+func benchErrorKey(err error) string {
+	if be, ok := err.(*zest.BlockError); ok {
+		err = be.Err
+	}
+	switch e := err.(type) {
+	case *zest.ResponseError:
+		return fmt.Sprintf("%d.%02d", e.Code>>5, e.Code&0x1f)
+	case *zest.TimeoutError:
+		return "timeout"
+	}
+	if strings.Contains(err.Error(), "timeout") {
+		return "timeout"
+	}
+	return "other"
+}
+
+func ms(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+func summarise(latencies []time.Duration) *benchLatency {
+	sort.Sort(durations(latencies))
+	l := &benchLatency{Count: len(latencies)}
+	if len(latencies) == 0 {
+		return l
+	}
+
+	var total time.Duration
+	for _, d := range latencies {
+		total += d
+	}
+	l.MeanMS = ms(total / time.Duration(len(latencies)))
+	l.P50MS = ms(percentile(latencies, 50))
+	l.P95MS = ms(percentile(latencies, 95))
+	l.P99MS = ms(percentile(latencies, 99))
+	l.MaxMS = ms(latencies[len(latencies)-1])
+
+	i := 0
+	for _, bound := range benchBuckets {
+		b := benchBucket{LessThanMS: ms(bound)}
+		for i < len(latencies) && latencies[i] < bound {
+			b.Count++
+			i++
+		}
+		l.Histogram = append(l.Histogram, b)
+	}
+	//everything slower than the last bound
+	l.Histogram = append(l.Histogram, benchBucket{Count: len(latencies) - i})
+	return l
+}
+
+//percentile uses the nearest rank method on sorted latencies
+func percentile(sorted []time.Duration, p int) time.Duration {
+	rank := (p*len(sorted) + 99) / 100
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
+
+type durations []time.Duration
+
+func (d durations) Len() int           { return len(d) }
+func (d durations) Less(i, j int) bool { return d[i] < d[j] }
+func (d durations) Swap(i, j int)      { d[i], d[j] = d[j], d[i] }
+
+func printBenchReport(r benchReport) {
+	fmt.Printf("requests   %d in %.2fs, %.1f req/s\n", r.Requests, r.DurationS, r.ThroughputRPS)
+	fmt.Printf("errors     %d\n", r.Errors)
+	codes := make([]string, 0, len(r.ErrorsByCode))
+	for code := range r.ErrorsByCode {
+		codes = append(codes, code)
+	}
+	sort.Strings(codes)
+	for _, code := range codes {
+		fmt.Printf("  %-8s %d\n", code, r.ErrorsByCode[code])
+	}
+
+	for _, kind := range []string{"read", "write", "all"} {
+		l, ok := r.Latency[kind]
+		if !ok || l.Count == 0 {
+			continue
+		}
+		fmt.Printf("\n%s latency (%d requests)\n", kind, l.Count)
+		fmt.Printf("  mean %.2fms  p50 %.2fms  p95 %.2fms  p99 %.2fms  max %.2fms\n", l.MeanMS, l.P50MS, l.P95MS, l.P99MS, l.MaxMS)
+		for _, b := range l.Histogram {
+			label := fmt.Sprintf("< %gms", b.LessThanMS)
+			if b.LessThanMS == 0 {
+				label = fmt.Sprintf(">= %gms", ms(benchBuckets[len(benchBuckets)-1]))
+			}
+			bar := strings.Repeat("#", b.Count*40/l.Count)
+			fmt.Printf("  %-10s %8d %s\n", label, b.Count, bar)
+		}
+	}
+}
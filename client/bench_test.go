@@ -0,0 +1,103 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestCheckBenchFlags(t *testing.T) {
+	cases := []struct {
+		name        string
+		concurrency int
+		rate        float64
+		duration    time.Duration
+		readRatio   float64
+		payloadSize int
+		ok          bool
+	}{
+		{"defaults", 1, 0, 10 * time.Second, 0.5, 64, true},
+		{"fastest rate", 4, 1e9, time.Second, 1, 0, true},
+		{"no workers", 0, 0, time.Second, 0.5, 64, false},
+		{"negative rate", 1, -1, time.Second, 0.5, 64, false},
+		{"rate too fast for the ticker", 1, 2e9, time.Second, 0.5, 64, false},
+		{"NaN rate", 1, math.NaN(), time.Second, 0.5, 64, false},
+		{"infinite rate", 1, math.Inf(1), time.Second, 0.5, 64, false},
+		{"no duration", 1, 0, 0, 0.5, 64, false},
+		{"read ratio over 1", 1, 0, time.Second, 1.5, 64, false},
+		{"NaN read ratio", 1, 0, time.Second, math.NaN(), 64, false},
+		{"negative payload", 1, 0, time.Second, 0.5, -1, false},
+	}
+	for _, tc := range cases {
+		err := checkBenchFlags(tc.concurrency, tc.rate, tc.duration, tc.readRatio, tc.payloadSize)
+		if tc.ok && err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+		}
+		if !tc.ok {
+			if _, ok := err.(usageError); !ok {
+				t.Errorf("%s: got %v, want a usageError", tc.name, err)
+			}
+		}
+	}
+}
+
+func TestPercentile(t *testing.T) {
+	sorted := make([]time.Duration, 100)
+	for i := range sorted {
+		sorted[i] = time.Duration(i+1) * time.Millisecond
+	}
+	cases := []struct {
+		sorted []time.Duration
+		p      int
+		want   time.Duration
+	}{
+		{sorted, 50, 50 * time.Millisecond},
+		{sorted, 95, 95 * time.Millisecond},
+		{sorted, 99, 99 * time.Millisecond},
+		{sorted, 100, 100 * time.Millisecond},
+		{sorted, 0, time.Millisecond},
+		//nearest rank rounds up
+		{sorted[:10], 95, 10 * time.Millisecond},
+		{sorted[:10], 50, 5 * time.Millisecond},
+		{sorted[:1], 99, time.Millisecond},
+	}
+	for _, tc := range cases {
+		if got := percentile(tc.sorted, tc.p); got != tc.want {
+			t.Errorf("p%d of %d: got %v, want %v", tc.p, len(tc.sorted), got, tc.want)
+		}
+	}
+}
+
+func TestSummarise(t *testing.T) {
+	//unsorted on purpose, summarise sorts them
+	latencies := []time.Duration{
+		3 * time.Second,
+		500 * time.Microsecond,
+		15 * time.Millisecond,
+		time.Millisecond,
+		1500 * time.Microsecond,
+	}
+	l := summarise(latencies)
+	if l.Count != 5 || l.MaxMS != 3000 || l.P50MS != 1.5 || l.P99MS != 3000 {
+		t.Fatalf("got %+v", l)
+	}
+	if l.MeanMS != 603.6 {
+		t.Fatalf("mean %v, want 603.6", l.MeanMS)
+	}
+
+	want := map[float64]int{1: 1, 2: 2, 20: 1, 0: 1}
+	total := 0
+	for _, b := range l.Histogram {
+		if b.Count != want[b.LessThanMS] {
+			t.Errorf("bucket < %gms has %d", b.LessThanMS, b.Count)
+		}
+		total += b.Count
+	}
+	if len(l.Histogram) != len(benchBuckets)+1 || total != 5 {
+		t.Fatalf("%d buckets holding %d latencies", len(l.Histogram), total)
+	}
+
+	if empty := summarise(nil); empty.Count != 0 || empty.Histogram != nil {
+		t.Fatalf("got %+v for no latencies", empty)
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	zest "github.com/me-box/goZestClient"
+)
+
+func runCatalogue(name string, args []string) error {
+	fs, conn := newFlagSet(name, "", "List the datasources in the store's Hypercat catalogue, optionally filtered by relation.")
+	dsType := fs.String("type", "", "only datasources of this type")
+	storeType := fs.String("store-type", "", "only datasources in this kind of store, e.g. kv or ts")
+	vendor := fs.String("vendor", "", "only datasources from this vendor")
+	var rels stringList
+	fs.Var(&rels, "rel", "rel=val, only items with this relation, any value if val is empty, can be repeated")
+	asJSON := fs.Bool("json", false, "print the filtered catalogue as JSON")
+	_, err := parseArgs(fs, args, 0)
+	if err != nil {
+		return err
+	}
+
+	filters := [][2]string{}
+	if *dsType != "" {
+		filters = append(filters, [2]string{zest.RelType, *dsType})
+	}
+	if *storeType != "" {
+		filters = append(filters, [2]string{zest.RelStoreType, *storeType})
+	}
+	if *vendor != "" {
+		filters = append(filters, [2]string{zest.RelVendor, *vendor})
+	}
+	for _, spec := range rels {
+		parts := strings.SplitN(spec, "=", 2)
+		if len(parts) != 2 {
+			return usageError{"--rel should be rel=val: " + spec}
+		}
+		filters = append(filters, [2]string{parts[0], parts[1]})
+	}
+
+	zestC, err := conn.client()
+	if err != nil {
+		return err
+	}
+	defer zestC.Close()
+
+	cat, err := zestC.Catalogue(*conn.token)
+	if err != nil {
+		return err
+	}
+	for _, f := range filters {
+		cat = cat.Filter(f[0], f[1])
+	}
+
+	if *asJSON {
+		out, err := json.MarshalIndent(cat, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(out))
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "HREF\tTYPE\tSTORE\tCONTENT TYPE\tDESCRIPTION")
+	for _, item := range cat.Items {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", item.Href, item.DatasourceType(), item.StoreType(), item.ContentType(), item.Description())
+	}
+	return w.Flush()
+}
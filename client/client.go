@@ -1,185 +1,675 @@
 package main
 
 import (
+	"context"
+	"encoding/hex"
 	"flag"
 	"fmt"
+	"io/ioutil"
 	"os"
+	"os/signal"
+	"regexp"
 	"strconv"
 	"strings"
 	"time"
 
 	zest "github.com/me-box/goZestClient"
+	"github.com/me-box/goZestClient/macaroon"
 )
 
+//Exit statuses, Zest error responses exit with their code class
+const (
+	exitOK          = 0
+	exitError       = 1
+	exitUsage       = 2
+	exitTimeout     = 3
+	exitClientError = 4
+	exitServerError = 5
+)
+
+type command struct {
+	name  string
+	help  string
+	run   func(name string, args []string) error
+	extra bool
+}
+
+var commands []command
+
+func init() {
+	commands = []command{
+		{name: "get", help: "read the value at path", run: runGet},
+		{name: "post", help: "write a value to path", run: runPost},
+		{name: "delete", help: "delete the value at path", run: runDelete},
+		{name: "observe", help: "print every event on path until interrupted", run: runObserve},
+		{name: "notify", help: "wait for the next message posted to path", run: runNotify},
+		{name: "ping", help: "check the store answers and report round trip time", run: runPing},
+		{name: "shell", help: "explore a store interactively over one connection", run: runShell},
+		{name: "bench", help: "generate load and report throughput and latency", run: runBench},
+		{name: "export", help: "dump a key value datasource or time series range to NDJSON or CSV", run: runExport},
+		{name: "import", help: "load records from an export into a datasource", run: runImport},
+		{name: "gateway", help: "serve the store's paths over HTTP", run: runGateway},
+		{name: "mqtt", help: "bridge MQTT topics and store paths", run: runMQTT},
+		{name: "replicate", help: "copy a datasource to another store and keep it in sync", run: runReplicate},
+		{name: "proxy", help: "serve one endpoint that routes paths to several stores", run: runProxy},
+		{name: "catalogue", help: "list the datasources in the store's Hypercat catalogue", run: runCatalogue},
+		{name: "token", help: "request a token for a store path from the arbiter", run: runToken},
+		{name: "macaroon", help: "show a token's caveats and check them against a request", run: runMacaroon},
+		{name: "profile", help: "list connection profiles or show one", run: runProfile},
+		{name: "test", help: "post ten values to a time series and read the latest", run: runTest, extra: true},
+		{name: "notifytest", help: "answer notification requests with notify replies", run: runNotifyTest, extra: true},
+	}
+}
+
+//usageError is reported with exit status 2
+type usageError struct {
+	msg string
+}
+
+func (e usageError) Error() string {
+	return e.msg
+}
+
 func main() {
-	ServerKey := flag.String("server-key", "vl6wu0A@XP?}Or/&BR#LSxn>A+}L)p44/W[wXL3<", "Set the curve server key")
-	Path := flag.String("path", "/kv/foo", "Set the uri path for POST and GET")
-	Token := flag.String("token", "", "Set set access token")
-	Payload := flag.String("payload", "{\"name\":\"dave\", \"age\":30}", "Set the uri path for POST and GET")
-	ReqEndpoint := flag.String("request-endpoint", "tcp://127.0.0.1:5555", "set the request/reply endpoint")
-	DealerEndpoint := flag.String("router-endpoint", "tcp://127.0.0.1:5556", "set the router/dealer endpoint")
-	Mode := flag.String("mode", "OBSERVE", "set the mode of operation")
-	Format := flag.String("format", "JSON", "text, json, binary to set the message content type")
-	ObserveMode := flag.String("observe-mode", "data", `"data", "audit", "notification"`)
-	Logging := flag.Bool("enable-logging", false, "output debug information")
-	flag.Parse()
-
-	zestC, clientErr := zest.New(*ReqEndpoint, *DealerEndpoint, *ServerKey, *Logging)
-	zestC2, clientErr := zest.New(*ReqEndpoint, *DealerEndpoint, *ServerKey, *Logging)
-	if clientErr != nil {
-		fmt.Println("Error creating client: ", clientErr.Error())
-		os.Exit(2)
-	}
-	switch strings.ToUpper(*Mode) {
-	case "POST":
-		_, err := zestC.Post(*Token, *Path, []byte(*Payload), *Format)
+	os.Exit(run(os.Args[1:]))
+}
+
+func run(args []string) int {
+
+	if len(args) == 0 || args[0] == "help" || args[0] == "-h" || args[0] == "--help" {
+		usage()
+		if len(args) == 0 {
+			return exitUsage
+		}
+		return exitOK
+	}
+
+	for _, c := range commands {
+		if c.name == strings.ToLower(args[0]) {
+			err := c.run(c.name, args[1:])
+			if err == flag.ErrHelp {
+				return exitOK
+			}
+			if err != nil {
+				fmt.Fprintln(os.Stderr, "zest "+c.name+": "+err.Error())
+			}
+			return exitCode(err)
+		}
+	}
+
+	fmt.Fprintln(os.Stderr, "zest: unknown command "+args[0])
+	usage()
+	return exitUsage
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "Usage: zest <command> [flags] [args]")
+	fmt.Fprintln(os.Stderr, "\nCommands:")
+	for _, c := range commands {
+		if !c.extra {
+			fmt.Fprintf(os.Stderr, "  %-10s %s\n", c.name, c.help)
+		}
+	}
+	fmt.Fprintln(os.Stderr, "\nTesting commands:")
+	for _, c := range commands {
+		if c.extra {
+			fmt.Fprintf(os.Stderr, "  %-10s %s\n", c.name, c.help)
+		}
+	}
+	fmt.Fprintln(os.Stderr, "\nRun 'zest <command> --help' for the flags of a command.")
+	fmt.Fprintln(os.Stderr, "\nExit status is 0 on success, 1 on other errors, 2 on bad usage, 3 on timeout,")
+	fmt.Fprintln(os.Stderr, "4 for a 4.xx response from the server and 5 for a 5.xx response.")
+}
+
+//exitCode maps an error to the exit status of the command
+func exitCode(err error) int {
+	if be, ok := err.(*zest.BlockError); ok {
+		err = be.Err
+	}
+	switch e := err.(type) {
+	case nil:
+		return exitOK
+	case usageError:
+		return exitUsage
+	case *zest.TimeoutError:
+		return exitTimeout
+	case *macaroon.CaveatError:
+		//the store would have refused it with 4.01
+		return exitClientError
+	case *zest.ResponseError:
+		switch e.Code >> 5 {
+		case 4:
+			return exitClientError
+		case 5:
+			return exitServerError
+		}
+	}
+	return exitError
+}
+
+//connFlags are the connection flags shared by every command. Flags that
+//aren't given on the command line are filled in from the selected profile.
+type connFlags struct {
+	fs             *flag.FlagSet
+	profile        *string
+	serverKey      *string
+	serverKeyFile  *string
+	clientKeyFile  *string
+	token          *string
+	reqEndpoint    *string
+	dealerEndpoint *string
+	format         *string
+	logging        *bool
+	checkToken     *bool
+	record         *string
+}
+
+func newFlagSet(name string, args string, help string) (*flag.FlagSet, *connFlags) {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(os.Stderr)
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: zest "+name+" [flags] "+args)
+		fmt.Fprintln(os.Stderr, "\n"+help+"\n\nFlags:")
+		fs.PrintDefaults()
+	}
+
+	c := &connFlags{fs: fs}
+	c.profile = fs.String("profile", "", "connection profile from the config file, defaults to $ZEST_PROFILE")
+	c.serverKey = fs.String("server-key", "", "Set the curve server key")
+	c.serverKeyFile = fs.String("server-key-file", "", "read the curve server key from a file")
+	c.clientKeyFile = fs.String("client-key-file", "", "read a fixed curve client secret key from a file")
+	c.token = fs.String("token", "", "Set set access token")
+	c.reqEndpoint = fs.String("request-endpoint", "tcp://127.0.0.1:5555", "set the request/reply endpoint")
+	c.dealerEndpoint = fs.String("router-endpoint", "tcp://127.0.0.1:5556", "set the router/dealer endpoint")
+	c.format = fs.String("format", "JSON", "text, json, binary, cbor, senml+json, senml+cbor, protobuf or a media type to set the message content type")
+	c.logging = fs.Bool("enable-logging", false, "output debug information, with tokens masked")
+	c.checkToken = fs.Bool("check-token", false, "check the token's caveats against each request before sending it")
+	c.record = fs.String("record", "", "write every request, response and event with timings to this file for replay")
+
+	return fs, c
+}
+
+//applyProfile fills in every connection flag that wasn't set on the command line from the profile
+func (c *connFlags) applyProfile() error {
+	p, err := selectProfile(*c.profile)
+	if err != nil {
+		return err
+	}
+
+	set := map[string]bool{}
+	c.fs.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+
+	if !set["request-endpoint"] && p.RequestEndpoint != "" {
+		*c.reqEndpoint = p.RequestEndpoint
+	}
+	if !set["router-endpoint"] && p.RouterEndpoint != "" {
+		*c.dealerEndpoint = p.RouterEndpoint
+	}
+	if !set["format"] && p.Format != "" {
+		*c.format = p.Format
+	}
+	if !set["server-key"] && !set["server-key-file"] && p.ServerKeyFile != "" {
+		*c.serverKeyFile = p.ServerKeyFile
+	}
+	if !set["client-key-file"] && p.ClientKeyFile != "" {
+		*c.clientKeyFile = p.ClientKeyFile
+	}
+	if !set["token"] {
+		if p.TokenCommand != "" {
+			*c.token, err = runTokenCommand(p.TokenCommand)
+			if err != nil {
+				return err
+			}
+		} else if p.Token != "" {
+			*c.token = p.Token
+		}
+	}
+
+	if *c.serverKey == "" && *c.serverKeyFile != "" {
+		*c.serverKey, err = readKeyFile(*c.serverKeyFile)
 		if err != nil {
-			fmt.Println(err.Error())
+			return err
+		}
+	}
+	if *c.serverKey == "" {
+		return usageError{"no server key, use --server-key, --server-key-file or a profile"}
+	}
+
+	return nil
+}
+
+//client applies the profile and connects, the connection flags hold their final values afterwards
+func (c *connFlags) client() (*zest.ZestClient, error) {
+	err := c.applyProfile()
+	if err != nil {
+		return nil, err
+	}
+
+	z, err := zest.New(*c.reqEndpoint, *c.dealerEndpoint, *c.serverKey, *c.logging)
+	if err != nil {
+		return nil, err
+	}
+
+	z.SetCheckTokens(*c.checkToken)
+
+	if *c.clientKeyFile != "" {
+		key, err := readKeyFile(*c.clientKeyFile)
+		if err == nil {
+			err = z.SetClientKey(key)
 		}
-		fmt.Println("created")
-	case "GET":
-		value, err := zestC.Get(*Token, *Path, *Format)
 		if err != nil {
-			fmt.Println(err.Error())
+			z.Close()
+			return nil, err
 		}
-		fmt.Println(string(value))
+	}
 
-	case "DELETE":
-		err := zestC.Delete(*Token, *Path, *Format)
+	if *c.record != "" {
+		f, err := os.Create(expandHome(*c.record))
 		if err != nil {
-			fmt.Println(err.Error())
+			z.Close()
+			return nil, err
 		}
-		fmt.Println("deleted")
+		//closing the client closes the file
+		z.SetTransport(zest.NewRecordingTransport(z.Transport(), f))
+	}
+
+	return z, nil
+}
 
-	case "OBSERVE":
-		obsTypes := map[string]zest.ObserveMode{
-			"data":         zest.ObserveModeData,
-			"audit":        zest.ObserveModeAudit,
-			"notification": zest.ObserveModeNotification,
+//parseArgs parses flags that may come before or after the positional
+//arguments, want is the number of positional arguments or -1 for any number
+func parseArgs(fs *flag.FlagSet, args []string, want int) ([]string, error) {
+	var positional []string
+	for {
+		err := fs.Parse(args)
+		if err == flag.ErrHelp {
+			return nil, err
+		}
+		if err != nil {
+			return nil, usageError{err.Error()}
 		}
+		args = fs.Args()
+		if len(args) == 0 {
+			break
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
 
-		if val, ok := obsTypes[*ObserveMode]; ok {
-			dataChan, doneChan, obsErr := zestC.Observe(*Token, *Path, *Format, val, 0)
-			if obsErr != nil {
-				fmt.Println(" Error: ", obsErr.Error())
-				break
-			}
+	if want >= 0 && len(positional) != want {
+		fs.Usage()
+		return nil, usageError{"expected " + strconv.Itoa(want) + " argument(s), got " + strconv.Itoa(len(positional))}
+	}
+	return positional, nil
+}
 
-			fmt.Println("Blocking waiting for data on chan ", dataChan)
-			for resp := range dataChan {
-				fmt.Println("Value returned from observer: ", string(resp))
-			}
-			close(doneChan)
+//interrupted returns a context that is cancelled on Ctrl-C
+func interrupted() (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt)
+	go func() {
+		select {
+		case <-sig:
+			cancel()
+		case <-ctx.Done():
+		}
+		signal.Stop(sig)
+	}()
+	return ctx, cancel
+}
+
+func runGet(name string, args []string) error {
+	fs, conn := newFlagSet(name, "<path>", "Read the value at path and write it to stdout or a file.")
+	output := addOutputFlags(fs)
+	showETag := fs.Bool("etag", false, "print the value's ETag in hex to stderr")
+	pos, err := parseArgs(fs, args, 1)
+	if err != nil {
+		return err
+	}
+
+	zestC, err := conn.client()
+	if err != nil {
+		return err
+	}
+	defer zestC.Close()
+
+	if *showETag {
+		value, etag, err := zestC.GetWithETag(*conn.token, pos[0], *conn.format)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(os.Stderr, "etag "+hex.EncodeToString(etag))
+		return output.write(value)
+	}
+
+	ctx, cancel := interrupted()
+	defer cancel()
+
+	w, finish, err := output.open()
+	if err != nil {
+		return err
+	}
+	_, err = zestC.GetTo(ctx, *conn.token, pos[0], w, *conn.format)
+	return finish(err)
+}
+
+func runPost(name string, args []string) error {
+	fs, conn := newFlagSet(name, "<path>", "Write a value to path.")
+	payload := fs.String("payload", "", "the value to post, @file to read it from a file or - to read stdin")
+	output := addOutputFlags(fs)
+	ifMatch := fs.String("if-match", "", "only write if the value's ETag, in hex, is this one")
+	ifNoneMatch := fs.Bool("if-none-match", false, "only write if there is no value yet")
+	pos, err := parseArgs(fs, args, 1)
+	if err != nil {
+		return err
+	}
+	if *ifMatch != "" && *ifNoneMatch {
+		return usageError{"--if-match and --if-none-match can't be used together"}
+	}
+	etag, err := hex.DecodeString(*ifMatch)
+	if err != nil {
+		return usageError{"--if-match must be hex: " + err.Error()}
+	}
+
+	zestC, err := conn.client()
+	if err != nil {
+		return err
+	}
+	defer zestC.Close()
+
+	r, err := openPayload(*payload)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	ctx, cancel := interrupted()
+	defer cancel()
+
+	var resp []byte
+	if *ifMatch != "" || *ifNoneMatch {
+		//conditional writes are sent from memory
+		value, err := ioutil.ReadAll(r)
+		if err != nil {
+			return err
+		}
+		if *ifNoneMatch {
+			resp, err = zestC.PostIfNoneMatch(*conn.token, pos[0], value, *conn.format)
 		} else {
-			fmt.Println("Unsupported observe mode ")
+			resp, err = zestC.PostIfMatch(*conn.token, pos[0], value, *conn.format, etag)
 		}
-	case "NOTIFY":
-		dataChan, doneChan, obsErr := zestC.Notify(*Token, *Path, *Format, 0)
-		if obsErr != nil {
-			fmt.Println(" Error: ", obsErr.Error())
-			break
+		if err != nil {
+			return err
 		}
-		fmt.Println("Blocking waiting for data on Notify chan ", dataChan, " Error: ", obsErr)
-		resp := <-dataChan
-		fmt.Println("Value returned from notifyer: ", string(resp))
-		close(doneChan)
-	case "NOTIFYTEST":
+	} else {
+		resp, err = zestC.PostReader(ctx, *conn.token, pos[0], r, *conn.format)
+		if err != nil {
+			return err
+		}
+	}
+	if len(resp) > 0 {
+		return output.write(resp)
+	}
+	return nil
+}
 
-		//listen for requests
-		go func() {
-			dataChan, doneChan, obsErr := zestC.Observe(*Token, "/notification/request/tosh/*", *Format, "notification", 0)
-			if obsErr != nil {
-				fmt.Println(" Error: ", obsErr.Error())
-				return
-			}
+func runDelete(name string, args []string) error {
+	fs, conn := newFlagSet(name, "<path>", "Delete the value at path.")
+	pos, err := parseArgs(fs, args, 1)
+	if err != nil {
+		return err
+	}
 
-			fmt.Println("Blocking waiting for data on chan ", dataChan)
-			for resp := range dataChan {
-				fmt.Println("GOT REQUEST: ", string(resp))
-				parts := strings.SplitAfterN(string(resp), " ", 4)
-				//REPLAY TO REQUEST
-				fmt.Println("REPLAYING on ", parts[2])
-				zestC.Post(*Token, parts[2], []byte(`{"result": true}`), *Format)
+	zestC, err := conn.client()
+	if err != nil {
+		return err
+	}
+	defer zestC.Close()
+
+	return zestC.Delete(*conn.token, pos[0], *conn.format)
+}
+
+func runObserve(name string, args []string) error {
+	fs, conn := newFlagSet(name, "<path>", "Print every event on path as a line of JSON until interrupted, a limit is reached or the timeout expires.")
+	mode := fs.String("observe-mode", "data", `"data", "audit", "notification"`)
+	timeout := fs.Uint("timeout", 0, "Max-Age of the observation in seconds, 0 for no limit")
+	count := fs.Int("count", 0, "stop after this many events, 0 for no limit")
+	duration := fs.Duration("duration", 0, "stop after this long, 0 for no limit")
+	untilMatch := fs.String("until-match", "", "stop after the first event whose raw message matches this regular expression")
+	raw := fs.Bool("raw", false, "print each event as received instead of as JSON")
+	pos, err := parseArgs(fs, args, 1)
+	if err != nil {
+		return err
+	}
+
+	obsTypes := map[string]zest.ObserveMode{
+		"data":         zest.ObserveModeData,
+		"audit":        zest.ObserveModeAudit,
+		"notification": zest.ObserveModeNotification,
+	}
+	observeMode, ok := obsTypes[*mode]
+	if !ok {
+		return usageError{"unsupported observe mode " + *mode}
+	}
+
+	var match *regexp.Regexp
+	if *untilMatch != "" {
+		match, err = regexp.Compile(*untilMatch)
+		if err != nil {
+			return usageError{"bad --until-match: " + err.Error()}
+		}
+	}
+
+	zestC, err := conn.client()
+	if err != nil {
+		return err
+	}
+	defer zestC.Close()
+
+	dataChan, doneChan, err := zestC.Observe(*conn.token, pos[0], *conn.format, observeMode, uint32(*timeout))
+	if err != nil {
+		return err
+	}
+	defer close(doneChan)
+
+	ctx, cancel := interrupted()
+	defer cancel()
+
+	var end <-chan time.Time
+	if *duration > 0 {
+		end = time.After(*duration)
+	}
+	//the store should end the observation at its Max-Age, but don't rely on it
+	var expired <-chan time.Time
+	if *timeout > 0 {
+		expired = time.After(time.Duration(*timeout) * time.Second)
+	}
+
+	for seen := 0; *count == 0 || seen < *count; seen++ {
+		select {
+		case resp, ok := <-dataChan:
+			if !ok {
+				return nil
 			}
-			close(doneChan)
-
-		}()
-
-		//listen for responses
-		time.Sleep(time.Second * 1)
-		go func() {
-			i := 0
-			for {
-				i++
-				dataChan, _, obsErr := zestC2.Notify(*Token, "/notification/response/tosh/"+strconv.Itoa(i), *Format, 0)
-				if obsErr != nil {
-					fmt.Println("Response Error: ", obsErr.Error())
-					continue
+			if *raw {
+				fmt.Println(string(resp))
+			} else {
+				err = writeEventLine(os.Stdout, newEventLine(*mode, pos[0], resp))
+				if err != nil {
+					return err
 				}
-
-				fmt.Println("Notify blocking on ", dataChan, " waiting for /notification/response/tosh/"+strconv.Itoa(i))
-				resp := <-dataChan
-				fmt.Println("Got Response ", string(resp))
 			}
-		}()
-
-		//MAKE REQUEST
-		go func() {
-			i := 0
-			for {
-				time.Sleep(time.Second * 2)
-				i++
-				zestC2.Post(*Token, "/notification/request/tosh/"+strconv.Itoa(i), []byte(`{"active": true}`), *Format)
+			if match != nil && match.Match(resp) {
+				return nil
 			}
-		}()
-
-		block := make(chan int)
-		<-block
-	case "TEST":
-
-		zestC.Post(*Token, *Path, []byte("{\"name\":\"dave\", \"age\":91}"), *Format)
-		zestC.Post(*Token, *Path, []byte("{\"name\":\"dave\", \"age\":92}"), *Format)
-		zestC.Post(*Token, *Path, []byte("{\"name\":\"dave\", \"age\":93}"), *Format)
-		zestC.Post(*Token, *Path, []byte("{\"name\":\"dave\", \"age\":94}"), *Format)
-		zestC.Post(*Token, *Path, []byte("{\"name\":\"dave\", \"age\":95}"), *Format)
-		zestC.Post(*Token, *Path, []byte("{\"name\":\"dave\", \"age\":96}"), *Format)
-		zestC.Post(*Token, *Path, []byte("{\"name\":\"dave\", \"age\":97}"), *Format)
-		zestC.Post(*Token, *Path, []byte("{\"name\":\"dave\", \"age\":98}"), *Format)
-		zestC.Post(*Token, *Path, []byte("{\"name\":\"dave\", \"age\":99}"), *Format)
-		zestC.Post(*Token, *Path, []byte("{\"name\":\"dave\", \"age\":100}"), *Format)
-
-		value, err := zestC.Get(*Token, *Path+"/latest", *Format)
+		case <-end:
+			return nil
+		case <-expired:
+			return nil
+		case <-ctx.Done():
+			return nil
+		}
+	}
+	return nil
+}
+
+func runNotify(name string, args []string) error {
+	fs, conn := newFlagSet(name, "<path>", "Wait for the next message posted to path and write it to stdout or a file.")
+	timeout := fs.Uint("timeout", 0, "Max-Age in seconds, 0 to wait until interrupted")
+	asJSON := fs.Bool("json", false, "print the message as a line of JSON like observe does")
+	output := addOutputFlags(fs)
+	pos, err := parseArgs(fs, args, 1)
+	if err != nil {
+		return err
+	}
+
+	zestC, err := conn.client()
+	if err != nil {
+		return err
+	}
+	defer zestC.Close()
+
+	future, err := zestC.Notify(*conn.token, pos[0], *conn.format, uint32(*timeout))
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := interrupted()
+	defer cancel()
+
+	resp, err := future.Wait(ctx)
+	if err != nil {
+		return err
+	}
+	if *asJSON {
+		return writeEventLine(os.Stdout, newEventLine("notify", pos[0], resp))
+	}
+	return output.write(resp)
+}
+
+func runPing(name string, args []string) error {
+	fs, conn := newFlagSet(name, "", "Read a path from the store and report how long each round trip took.")
+	path := fs.String("path", "/status", "the path to read")
+	count := fs.Int("count", 1, "number of pings to send")
+	_, err := parseArgs(fs, args, 0)
+	if err != nil {
+		return err
+	}
+
+	zestC, err := conn.client()
+	if err != nil {
+		return err
+	}
+	defer zestC.Close()
+
+	for i := 0; i < *count; i++ {
+		start := time.Now()
+		_, err := zestC.Get(*conn.token, *path, *conn.format)
+		if err != nil {
+			return err
+		}
+		fmt.Println("reply from " + *conn.reqEndpoint + " time=" + time.Since(start).String())
+	}
+	return nil
+}
+
+func runTest(name string, args []string) error {
+	fs, conn := newFlagSet(name, "<path>", "Post ten values to a time series and print the latest.")
+	pos, err := parseArgs(fs, args, 1)
+	if err != nil {
+		return err
+	}
+
+	zestC, err := conn.client()
+	if err != nil {
+		return err
+	}
+	defer zestC.Close()
+
+	for age := 91; age <= 100; age++ {
+		_, err := zestC.Post(*conn.token, pos[0], []byte("{\"name\":\"dave\", \"age\":"+strconv.Itoa(age)+"}"), *conn.format)
 		if err != nil {
-			fmt.Println(err.Error())
+			return err
 		}
-		fmt.Println(string(value))
+	}
+
+	value, err := zestC.Get(*conn.token, pos[0]+"/latest", *conn.format)
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(value))
+	return nil
+}
+
+func runNotifyTest(name string, args []string) error {
+	fs, conn := newFlagSet(name, "", "Post requests under /notification/request/tosh/, answer them from an observer and wait for each reply with notify.")
+	_, err := parseArgs(fs, args, 0)
+	if err != nil {
+		return err
+	}
+
+	//a single client is shared by every goroutine below
+	zestC, err := conn.client()
+	if err != nil {
+		return err
+	}
+	defer zestC.Close()
+
+	//listen for requests
+	go func() {
+		dataChan, doneChan, obsErr := zestC.Observe(*conn.token, "/notification/request/tosh/*", *conn.format, zest.ObserveModeNotification, 0)
+		if obsErr != nil {
+			fmt.Fprintln(os.Stderr, " Error: ", obsErr.Error())
+			return
+		}
+
+		for resp := range dataChan {
+			fmt.Println("GOT REQUEST: ", string(resp))
+			parts := strings.SplitAfterN(string(resp), " ", 4)
+			//REPLAY TO REQUEST
+			fmt.Println("REPLAYING on ", parts[2])
+			zestC.Post(*conn.token, parts[2], []byte(`{"result": true}`), *conn.format)
+		}
+		close(doneChan)
+
+	}()
 
-	case "TESTWRITE":
+	//listen for responses
+	time.Sleep(time.Second * 1)
+	go func() {
 		i := 0
 		for {
-			data, err := zestC.Post(*Token, *Path, []byte("{\"name\":\"dave\", \"age\":"+strconv.Itoa(i)+"}"), *Format)
-			if err != nil {
-				fmt.Println("[ERROR] " + err.Error())
-			} else {
-				fmt.Println("[DATA] " + string(data))
+			i++
+			future, obsErr := zestC.Notify(*conn.token, "/notification/response/tosh/"+strconv.Itoa(i), *conn.format, 0)
+			if obsErr != nil {
+				fmt.Fprintln(os.Stderr, "Response Error: ", obsErr.Error())
+				continue
 			}
-			time.Sleep(time.Millisecond * 20)
-			i += 1
-		}
 
-	case "TESTREAD":
-		for {
-			data, err := zestC.Get(*Token, *Path, *Format)
-			if err != nil {
-				fmt.Println("[ERROR] " + err.Error())
-			} else {
-				fmt.Println("[DATA] " + string(data))
+			fmt.Println("Notify blocking waiting for /notification/response/tosh/" + strconv.Itoa(i))
+			resp, waitErr := future.Wait(context.Background())
+			if waitErr != nil {
+				fmt.Fprintln(os.Stderr, "Response Error: ", waitErr.Error())
+				continue
 			}
-			time.Sleep(time.Millisecond * 10)
+			fmt.Println("Got Response ", string(resp))
 		}
+	}()
 
-	default:
-		fmt.Println("Unknown method try GET,POST,OBSERVE or NOTIFY")
+	//MAKE REQUEST
+	ctx, cancel := interrupted()
+	defer cancel()
+	i := 0
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(time.Second * 2):
+		}
+		i++
+		zestC.Post(*conn.token, "/notification/request/tosh/"+strconv.Itoa(i), []byte(`{"active": true}`), *conn.format)
 	}
-
 }
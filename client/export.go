@@ -0,0 +1,391 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	zest "github.com/me-box/goZestClient"
+)
+
+var csvHeader = []string{"key", "timestamp", "format", "data"}
+
+//importBatch is how many records import reads before writing them
+const importBatch = 256
+
+//recordWriter writes records as NDJSON or CSV
+type recordWriter struct {
+	json *json.Encoder
+	csv  *csv.Writer
+}
+
+func newRecordWriter(w io.Writer, as string, header bool) (*recordWriter, error) {
+	switch as {
+	case "ndjson":
+		return &recordWriter{json: json.NewEncoder(w)}, nil
+	case "csv":
+		rw := &recordWriter{csv: csv.NewWriter(w)}
+		if header {
+			rw.csv.Write(csvHeader)
+			rw.csv.Flush()
+		}
+		return rw, nil
+	}
+	return nil, usageError{"unsupported output " + as + ", use ndjson or csv"}
+}
+
+func (rw *recordWriter) write(r zest.Record) error {
+	if rw.json != nil {
+		return rw.json.Encode(r)
+	}
+	ts := ""
+	if r.Key == "" {
+		ts = strconv.FormatInt(r.Timestamp, 10)
+	}
+	rw.csv.Write([]string{r.Key, ts, r.Format, string(r.Data)})
+	//flush every record so a failed export keeps everything read so far
+	rw.csv.Flush()
+	return rw.csv.Error()
+}
+
+//recordReader reads records written by recordWriter
+type recordReader struct {
+	json *json.Decoder
+	csv  *csv.Reader
+}
+
+func newRecordReader(r io.Reader, as string) (*recordReader, error) {
+	switch as {
+	case "ndjson":
+		return &recordReader{json: json.NewDecoder(r)}, nil
+	case "csv":
+		rr := &recordReader{csv: csv.NewReader(r)}
+		rr.csv.FieldsPerRecord = len(csvHeader)
+		head, err := rr.csv.Read()
+		if err != nil {
+			return nil, err
+		}
+		if strings.Join(head, ",") != strings.Join(csvHeader, ",") {
+			return nil, fmt.Errorf("csv header should be %s", strings.Join(csvHeader, ","))
+		}
+		return rr, nil
+	}
+	return nil, usageError{"unsupported input " + as + ", use ndjson or csv"}
+}
+
+//read returns io.EOF after the last record
+func (rr *recordReader) read() (zest.Record, error) {
+	var r zest.Record
+	if rr.json != nil {
+		err := rr.json.Decode(&r)
+		return r, err
+	}
+
+	row, err := rr.csv.Read()
+	if err != nil {
+		return r, err
+	}
+	r.Key = row[0]
+	r.Format = row[2]
+	r.Data = json.RawMessage(row[3])
+	if row[1] != "" {
+		r.Timestamp, err = strconv.ParseInt(row[1], 10, 64)
+	}
+	return r, err
+}
+
+//recordFormat picks ndjson or csv from --as or the file extension
+func recordFormat(as string, file string) string {
+	if as != "" {
+		return strings.ToLower(as)
+	}
+	if strings.HasSuffix(strings.ToLower(file), ".csv") {
+		return "csv"
+	}
+	return "ndjson"
+}
+
+//datasourceKind is kv or ts from a path such as /kv/sensors or /ts/temperature
+func datasourceKind(path string) (string, error) {
+	switch {
+	case strings.HasPrefix(path, "/kv/"):
+		return "kv", nil
+	case strings.HasPrefix(path, "/ts/"):
+		return "ts", nil
+	}
+	return "", usageError{"path should be a /kv/<datasource> or /ts/<datasource>"}
+}
+
+//lastRecord reads the last record of an earlier export so it can be resumed,
+//along with the time series position it reached. A line cut short when the
+//export failed is removed from the file.
+func lastRecord(file string, as string) (zest.Record, zest.Position, bool, error) {
+	var pos zest.Position
+	data, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return zest.Record{}, pos, false, nil
+	}
+	if err != nil {
+		return zest.Record{}, pos, false, err
+	}
+	if i := bytes.LastIndexByte(data, '\n'); i+1 < len(data) {
+		data = data[:i+1]
+		err = ioutil.WriteFile(file, data, 0644)
+		if err != nil {
+			return zest.Record{}, pos, false, err
+		}
+	}
+
+	rr, err := newRecordReader(bytes.NewReader(data), as)
+	if err == io.EOF {
+		return zest.Record{}, pos, false, nil
+	}
+	if err != nil {
+		return zest.Record{}, pos, false, err
+	}
+	var last zest.Record
+	found := false
+	for {
+		r, err := rr.read()
+		if err == io.EOF {
+			return last, pos, found, nil
+		}
+		if err != nil {
+			return zest.Record{}, pos, false, err
+		}
+		last, found = r, true
+		pos = pos.Next(r)
+	}
+}
+
+//earliest is the timestamp of the oldest value of a time series, false if it has none
+func earliest(z *zest.ZestClient, token string, path string) (int64, bool, error) {
+	first, err := zest.NewTSClient(z, token, path, "JSON").FirstN(1)
+	if err != nil || len(first) == 0 {
+		return 0, false, err
+	}
+	return first[0].Timestamp, true, nil
+}
+
+func runExport(name string, args []string) error {
+	fs, conn := newFlagSet(name, "<path>", "Write every value of a key value datasource, or a time range of a time series, to NDJSON or CSV.")
+	out := fs.String("out", "", "file to write, stdout if not given")
+	as := fs.String("as", "", "ndjson or csv, from the --out extension if not given")
+	from := fs.Int64("from", 0, "time series only, first timestamp in milliseconds since the epoch, the oldest value if not given")
+	to := fs.Int64("to", 0, "time series only, last timestamp in milliseconds, now if not given")
+	window := fs.Duration("window", time.Hour, "time series only, how much of the range to read per request")
+	after := fs.String("after", "", "key value only, start after this key")
+	resume := fs.Bool("resume", false, "carry on from the last record in --out instead of starting again")
+	progress := fs.Int("progress", 1000, "report progress on stderr every this many records, 0 for never")
+	pos, err := parseArgs(fs, args, 1)
+	if err != nil {
+		return err
+	}
+	path := pos[0]
+	kind, err := datasourceKind(path)
+	if err != nil {
+		return err
+	}
+	format := recordFormat(*as, *out)
+	fromSet := false
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name == "from" {
+			fromSet = true
+		}
+	})
+	if *to == 0 {
+		*to = time.Now().UnixNano() / int64(time.Millisecond)
+	}
+	if *resume && *out == "" {
+		return usageError{"--resume needs --out"}
+	}
+
+	w := io.Writer(os.Stdout)
+	header := true
+	var resumeAt *zest.Position
+	if *out != "" {
+		flags := os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+		if *resume {
+			last, pos, found, err := lastRecord(expandHome(*out), format)
+			if err != nil {
+				return err
+			}
+			if found {
+				*after = last.Key
+				//carry on from the last timestamp itself, other values may share it
+				resumeAt = &pos
+				fmt.Fprintln(os.Stderr, "resuming after "+describeRecord(last))
+			}
+			if fi, err := os.Stat(expandHome(*out)); err == nil && fi.Size() > 0 {
+				header = false
+			}
+			flags = os.O_CREATE | os.O_WRONLY | os.O_APPEND
+		}
+		f, err := os.OpenFile(expandHome(*out), flags, 0644)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+
+	rw, err := newRecordWriter(w, format, header)
+	if err != nil {
+		return err
+	}
+
+	zestC, err := conn.client()
+	if err != nil {
+		return err
+	}
+	defer zestC.Close()
+
+	ctx, cancel := interrupted()
+	defer cancel()
+
+	count := 0
+	var last zest.Record
+	write := func(r zest.Record) error {
+		err := rw.write(r)
+		if err != nil {
+			return err
+		}
+		count++
+		last = r
+		if *progress > 0 && count%*progress == 0 {
+			fmt.Fprintf(os.Stderr, "exported %d records, last %s\n", count, describeRecord(r))
+		}
+		return nil
+	}
+
+	switch {
+	case kind == "kv":
+		err = zestC.ExportKV(ctx, *conn.token, path, *conn.format, *after, write)
+	case resumeAt != nil:
+		err = zestC.ExportTSAfter(ctx, *conn.token, path, *resumeAt, *to, int64(*window/time.Millisecond), write)
+	default:
+		found := true
+		if !fromSet {
+			*from, found, err = earliest(zestC, *conn.token, path)
+		}
+		if err == nil && found {
+			err = zestC.ExportTS(ctx, *conn.token, path, *from, *to, int64(*window/time.Millisecond), write)
+		}
+	}
+	if err != nil {
+		if count > 0 {
+			fmt.Fprintf(os.Stderr, "exported %d records before failing, last %s\n", count, describeRecord(last))
+		}
+		if *out != "" {
+			fmt.Fprintln(os.Stderr, "run again with --resume to carry on")
+		}
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "exported %d records\n", count)
+	return nil
+}
+
+func runImport(name string, args []string) error {
+	fs, conn := newFlagSet(name, "<path>", "Write records from an export to a key value or time series datasource, keeping keys, timestamps and tags.")
+	in := fs.String("in", "-", "file to read, - for stdin")
+	as := fs.String("as", "", "ndjson or csv, from the --in extension if not given")
+	skip := fs.Int("skip", 0, "skip this many records, to resume an import that failed")
+	concurrency := fs.Int("concurrency", 4, "how many records to write at once, use 1 for a time series that must not get duplicates on a resume")
+	progress := fs.Int("progress", 1000, "report progress on stderr every this many records, 0 for never")
+	pos, err := parseArgs(fs, args, 1)
+	if err != nil {
+		return err
+	}
+	path := pos[0]
+	kind, err := datasourceKind(path)
+	if err != nil {
+		return err
+	}
+
+	r := io.Reader(os.Stdin)
+	if *in != "-" {
+		f, err := os.Open(expandHome(*in))
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+	rr, err := newRecordReader(bufio.NewReader(r), recordFormat(*as, *in))
+	if err != nil {
+		return err
+	}
+
+	zestC, err := conn.client()
+	if err != nil {
+		return err
+	}
+	defer zestC.Close()
+
+	ctx, cancel := interrupted()
+	defer cancel()
+
+	done := 0
+	var batch []zest.Record
+	flush := func() error {
+		n, err := zestC.ImportRecords(ctx, *conn.token, path, batch, *concurrency)
+		before := done
+		done += n
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed on record %d (%s)\n", done+1, describeRecord(batch[n]))
+			fmt.Fprintf(os.Stderr, "imported %d records, run again with --skip %d to carry on\n", done, done)
+			return err
+		}
+		if *progress > 0 && done / *progress != before / *progress {
+			fmt.Fprintf(os.Stderr, "imported %d records\n", done)
+		}
+		batch = batch[:0]
+		return nil
+	}
+	for {
+		rec, err := rr.read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("record %d: %s", done+len(batch)+1, err.Error())
+		}
+		if done < *skip {
+			done++
+			continue
+		}
+		if (kind == "kv") != (rec.Key != "") {
+			return usageError{fmt.Sprintf("record %d doesn't belong in a %s datasource", done+len(batch)+1, kind)}
+		}
+
+		batch = append(batch, rec)
+		if len(batch) == importBatch {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+	if len(batch) > 0 {
+		if err := flush(); err != nil {
+			return err
+		}
+	}
+	fmt.Fprintf(os.Stderr, "imported %d records\n", done)
+	return nil
+}
+
+func describeRecord(r zest.Record) string {
+	if r.Key != "" {
+		return "key " + r.Key
+	}
+	return "timestamp " + strconv.FormatInt(r.Timestamp, 10)
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/me-box/goZestClient/gateway"
+)
+
+func runGateway(name string, args []string) error {
+	fs, conn := newFlagSet(name, "", "Serve GET, POST and DELETE on the store's paths over HTTP, and Observe and Notify as\nServer-Sent Events or WebSockets under /stream/. A bearer token in a request replaces --token.")
+	listen := fs.String("listen", "127.0.0.1:8080", "address to serve HTTP on")
+	prefixes := fs.String("prefixes", "/kv/,/ts/", "comma separated path prefixes to forward")
+	maxBody := fs.Int64("max-body", 0, "largest POST body in bytes, 0 for no limit")
+	_, err := parseArgs(fs, args, 0)
+	if err != nil {
+		return err
+	}
+
+	zestC, err := conn.client()
+	if err != nil {
+		return err
+	}
+	defer zestC.Close()
+
+	h := gateway.New(zestC)
+	h.Token = *conn.token
+	h.Format = *conn.format
+	h.Prefixes = strings.Split(*prefixes, ",")
+	h.MaxBodyBytes = *maxBody
+
+	stream := gateway.NewStream(zestC)
+	stream.Token = *conn.token
+	stream.Format = *conn.format
+	stream.Prefixes = h.Prefixes
+
+	mux := http.NewServeMux()
+	mux.Handle("/stream/", http.StripPrefix("/stream", stream))
+	mux.Handle("/", h)
+
+	fmt.Fprintln(os.Stderr, "forwarding http://"+*listen+" to "+*conn.reqEndpoint)
+	return http.ListenAndServe(*listen, mux)
+}
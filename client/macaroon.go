@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/me-box/goZestClient/macaroon"
+)
+
+func runMacaroon(name string, args []string) error {
+	fs, conn := newFlagSet(name, "[token]", "Decode a macaroon token, the argument or else --token or the profile's, and show its caveats.\nGiven --path, check the target, path, method and time caveats as the store would.")
+	path := fs.String("path", "", "path of the request to check the token against")
+	method := fs.String("method", "GET", "method of the request to check")
+	target := fs.String("target", "", "host name of the store, not checked if empty")
+	pos, err := parseArgs(fs, args, -1)
+	if err != nil {
+		return err
+	}
+	if len(pos) > 1 {
+		fs.Usage()
+		return usageError{"expected at most one token"}
+	}
+
+	token := ""
+	if len(pos) == 1 {
+		token = pos[0]
+	} else {
+		err = conn.applyProfile()
+		if err != nil {
+			return err
+		}
+		token = *conn.token
+	}
+	if token == "" {
+		return usageError{"no token, give one as an argument, with --token or in a profile"}
+	}
+
+	m, err := macaroon.Decode(token)
+	if err != nil {
+		return err
+	}
+	fmt.Print(m.String())
+
+	if *path == "" {
+		return nil
+	}
+	err = m.Check(macaroon.Request{Target: *target, Path: *path, Method: *method})
+	if err != nil {
+		return err
+	}
+	fmt.Println("caveats allow " + *method + " " + *path)
+	return nil
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/me-box/goZestClient/mqttbridge"
+)
+
+//stringList is a flag that can be given more than once
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ", ")
+}
+
+func (l *stringList) Set(v string) error {
+	*l = append(*l, v)
+	return nil
+}
+
+func runMQTT(name string, args []string) error {
+	fs, conn := newFlagSet(name, "", "Copy messages between MQTT topics and store paths until interrupted. Templates name\nsegments with {name}, or {name...} for the rest, e.g. --to-zest 'home/{room}/temp=/ts/temp-{room}'.")
+	broker := fs.String("broker", "tcp://127.0.0.1:1883", "MQTT broker URL")
+	clientID := fs.String("client-id", "zest-bridge", "MQTT client id")
+	username := fs.String("username", "", "MQTT user name")
+	passwordFile := fs.String("password-file", "", "read the MQTT password from a file")
+	qos := fs.Int("qos", 1, "MQTT quality of service, 0, 1 or 2")
+	retain := fs.Bool("retain", false, "publish to MQTT as retained messages")
+	var toZest, toMQTT stringList
+	fs.Var(&toZest, "to-zest", "topic=path, post messages on MQTT topics to store paths, can be repeated")
+	fs.Var(&toMQTT, "to-mqtt", "path=topic, publish data observed on store paths to MQTT topics, can be repeated")
+	_, err := parseArgs(fs, args, 0)
+	if err != nil {
+		return err
+	}
+	if len(toZest)+len(toMQTT) == 0 {
+		fs.Usage()
+		return usageError{"at least one --to-zest or --to-mqtt route is needed"}
+	}
+	if *qos < 0 || *qos > 2 {
+		return usageError{"qos must be 0, 1 or 2"}
+	}
+
+	var routes []mqttbridge.Route
+	for _, spec := range toZest {
+		parts := strings.SplitN(spec, "=", 2)
+		if len(parts) != 2 {
+			return usageError{"--to-zest should be topic=path: " + spec}
+		}
+		routes = append(routes, mqttbridge.Route{Topic: parts[0], Path: parts[1], Direction: mqttbridge.ToZest, QoS: byte(*qos), Format: *conn.format})
+	}
+	for _, spec := range toMQTT {
+		parts := strings.SplitN(spec, "=", 2)
+		if len(parts) != 2 {
+			return usageError{"--to-mqtt should be path=topic: " + spec}
+		}
+		routes = append(routes, mqttbridge.Route{Path: parts[0], Topic: parts[1], Direction: mqttbridge.ToMQTT, QoS: byte(*qos), Retain: *retain, Format: *conn.format})
+	}
+
+	opts := mqtt.NewClientOptions().AddBroker(*broker).SetClientID(*clientID)
+	opts.SetAutoAckDisabled(true)
+	opts.SetCleanSession(false)
+	opts.SetAutoReconnect(true)
+	if *username != "" {
+		opts.SetUsername(*username)
+	}
+	if *passwordFile != "" {
+		password, err := readKeyFile(*passwordFile)
+		if err != nil {
+			return err
+		}
+		opts.SetPassword(password)
+	}
+	m := mqtt.NewClient(opts)
+	token := m.Connect()
+	if !token.WaitTimeout(time.Second * 10) {
+		return fmt.Errorf("timeout connecting to %s", *broker)
+	}
+	if token.Error() != nil {
+		return token.Error()
+	}
+	defer m.Disconnect(250)
+
+	zestC, err := conn.client()
+	if err != nil {
+		return err
+	}
+	defer zestC.Close()
+
+	bridge, err := mqttbridge.New(zestC, m, *conn.token, routes...)
+	if err != nil {
+		return usageError{err.Error()}
+	}
+	err = bridge.Start()
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "bridging %s and %s with %d routes\n", *broker, *conn.reqEndpoint, len(routes))
+
+	ctx, cancel := interrupted()
+	defer cancel()
+	<-ctx.Done()
+
+	bridge.Stop()
+	return nil
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"time"
+	"unicode/utf8"
+
+	zest "github.com/me-box/goZestClient"
+)
+
+//eventLine is one line of NDJSON output from observe and notify. Payloads are
+//embedded as JSON when they parse as JSON, as text when they are valid UTF-8
+//and as base64 otherwise.
+type eventLine struct {
+	Received      string          `json:"received"`
+	Mode          string          `json:"mode"`
+	Path          string          `json:"path"`
+	Timestamp     int64           `json:"timestamp,omitempty"`
+	EventPath     string          `json:"event_path,omitempty"`
+	ContentFormat string          `json:"content_format,omitempty"`
+	Host          string          `json:"host,omitempty"`
+	Method        string          `json:"method,omitempty"`
+	Code          string          `json:"code,omitempty"`
+	Data          json.RawMessage `json:"data,omitempty"`
+	Text          *string         `json:"text,omitempty"`
+	Base64        string          `json:"base64,omitempty"`
+	Raw           *string         `json:"raw,omitempty"`
+}
+
+func (l *eventLine) setPayload(payload []byte) {
+	switch {
+	case len(payload) == 0:
+	case isJSON(payload):
+		l.Data = json.RawMessage(payload)
+	case utf8.Valid(payload):
+		s := string(payload)
+		l.Text = &s
+	default:
+		l.Base64 = base64.StdEncoding.EncodeToString(payload)
+	}
+}
+
+//newEventLine parses msg according to the observe mode. Messages that don't
+//parse are kept whole in the raw field.
+func newEventLine(mode string, path string, msg []byte) eventLine {
+	l := eventLine{
+		Received: time.Now().UTC().Format(time.RFC3339Nano),
+		Mode:     mode,
+		Path:     path,
+	}
+
+	switch mode {
+	case string(zest.ObserveModeAudit):
+		e, err := zest.ParseAuditEvent(msg)
+		if err != nil {
+			break
+		}
+		l.Timestamp = e.Timestamp
+		l.Host = e.Host
+		l.Method = e.Method
+		l.EventPath = e.Path
+		l.Code = e.Code
+		return l
+
+	case string(zest.ObserveModeData), string(zest.ObserveModeNotification):
+		e, err := zest.ParseDataEvent(msg)
+		if err != nil {
+			break
+		}
+		l.Timestamp = e.Timestamp
+		l.EventPath = e.Path
+		l.ContentFormat = e.ContentFormat
+		l.setPayload(e.Payload)
+		return l
+
+	default:
+		//notify delivers the posted payload on its own
+		l.setPayload(msg)
+		return l
+	}
+
+	raw := string(msg)
+	l.Raw = &raw
+	return l
+}
+
+func isJSON(payload []byte) bool {
+	var v json.RawMessage
+	return json.Unmarshal(payload, &v) == nil
+}
+
+func writeEventLine(w io.Writer, l eventLine) error {
+	return json.NewEncoder(w).Encode(l)
+}
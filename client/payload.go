@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"flag"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+//openPayload returns a reader for a --payload value: @file reads the file, -
+//reads stdin and anything else is the payload itself
+func openPayload(spec string) (io.ReadCloser, error) {
+	switch {
+	case spec == "-":
+		return ioutil.NopCloser(os.Stdin), nil
+	case strings.HasPrefix(spec, "@"):
+		return os.Open(expandHome(spec[1:]))
+	}
+	return ioutil.NopCloser(strings.NewReader(spec)), nil
+}
+
+//outputFlags choose where response payloads go and how they are shown
+type outputFlags struct {
+	out     *string
+	display *string
+}
+
+func addOutputFlags(fs *flag.FlagSet) *outputFlags {
+	return &outputFlags{
+		out:     fs.String("out", "", "write the raw response bytes to this file instead of stdout"),
+		display: fs.String("display", "raw", "how to show the response on stdout: raw, hex or base64"),
+	}
+}
+
+//open returns the writer for a response and a function that finishes it off,
+//given the error the response was written with. Raw output to a terminal gets
+//a trailing newline, anywhere else the bytes are written untouched. A file
+//given with --out is written to a temporary file beside it and only renamed
+//into place once the whole response is written, so a failed request leaves
+//any earlier file as it was.
+func (o *outputFlags) open() (io.Writer, func(error) error, error) {
+
+	if *o.out != "" {
+		out := expandHome(*o.out)
+		tmp, err := ioutil.TempFile(filepath.Dir(out), filepath.Base(out)+".tmp")
+		if err != nil {
+			return nil, nil, err
+		}
+		return tmp, func(err error) error {
+			if err == nil {
+				err = tmp.Sync()
+			}
+			if cerr := tmp.Close(); err == nil {
+				err = cerr
+			}
+			if err != nil {
+				os.Remove(tmp.Name())
+				return err
+			}
+			return os.Rename(tmp.Name(), out)
+		}, nil
+	}
+
+	switch strings.ToLower(*o.display) {
+	case "raw":
+		return os.Stdout, func(err error) error {
+			if err == nil && isTerminal(os.Stdout) {
+				_, err = os.Stdout.WriteString("\n")
+			}
+			return err
+		}, nil
+	case "hex":
+		d := hex.Dumper(os.Stdout)
+		return d, func(err error) error {
+			if cerr := d.Close(); err == nil {
+				err = cerr
+			}
+			return err
+		}, nil
+	case "base64":
+		e := base64.NewEncoder(base64.StdEncoding, os.Stdout)
+		return e, func(err error) error {
+			if err != nil {
+				return err
+			}
+			err = e.Close()
+			if err != nil {
+				return err
+			}
+			_, err = os.Stdout.WriteString("\n")
+			return err
+		}, nil
+	}
+	return nil, nil, usageError{"unsupported display " + *o.display + ", use raw, hex or base64"}
+}
+
+//write sends a whole payload through a fresh writer from open
+func (o *outputFlags) write(payload []byte) error {
+	w, finish, err := o.open()
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(payload)
+	return finish(err)
+}
+
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestOutputFileIsReplacedWhole(t *testing.T) {
+	dir := t.TempDir()
+	out := filepath.Join(dir, "value")
+	if err := ioutil.WriteFile(out, []byte("old"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	display := "raw"
+	o := &outputFlags{out: &out, display: &display}
+
+	check := func(want string) {
+		t.Helper()
+		got, err := ioutil.ReadFile(out)
+		if err != nil || string(got) != want {
+			t.Fatalf("file has %q %v, want %q", got, err, want)
+		}
+		files, _ := os.ReadDir(dir)
+		if len(files) != 1 {
+			t.Fatalf("%d files left in the directory", len(files))
+		}
+	}
+
+	//a response that fails part way leaves the earlier file
+	w, finish, err := o.open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write([]byte("partial"))
+	failed := errors.New("connection lost")
+	if err := finish(failed); err != failed {
+		t.Fatalf("got %v, want the request's error", err)
+	}
+	check("old")
+
+	if err := o.write([]byte("new")); err != nil {
+		t.Fatal(err)
+	}
+	check("new")
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+//profile holds the connection settings for one store, secrets are kept in
+//files or produced by a command so they never need to appear on the command line
+type profile struct {
+	RequestEndpoint string `json:"request_endpoint,omitempty"`
+	RouterEndpoint  string `json:"router_endpoint,omitempty"`
+	ServerKeyFile   string `json:"server_key_file,omitempty"`
+	ClientKeyFile   string `json:"client_key_file,omitempty"`
+	Token           string `json:"token,omitempty"`
+	TokenCommand    string `json:"token_command,omitempty"`
+	Format          string `json:"format,omitempty"`
+}
+
+type config struct {
+	DefaultProfile string             `json:"default_profile,omitempty"`
+	Profiles       map[string]profile `json:"profiles"`
+}
+
+//configPath is $ZEST_CONFIG or ~/.config/zest/config.json
+func configPath() string {
+	if p := os.Getenv("ZEST_CONFIG"); p != "" {
+		return p
+	}
+	return filepath.Join(os.Getenv("HOME"), ".config", "zest", "config.json")
+}
+
+//loadConfig reads the config file, a missing file is an empty config
+func loadConfig() (*config, error) {
+	c := &config{Profiles: map[string]profile{}}
+	data, err := ioutil.ReadFile(configPath())
+	if os.IsNotExist(err) {
+		return c, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(data, c)
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %s", configPath(), err.Error())
+	}
+	return c, nil
+}
+
+//selectProfile picks the profile named by --profile, then $ZEST_PROFILE, then
+//the config's default. No name at all means no profile.
+func selectProfile(name string) (profile, error) {
+	if name == "" {
+		name = os.Getenv("ZEST_PROFILE")
+	}
+	c, err := loadConfig()
+	if err != nil {
+		return profile{}, err
+	}
+	if name == "" {
+		name = c.DefaultProfile
+	}
+	if name == "" {
+		return profile{}, nil
+	}
+	p, ok := c.Profiles[name]
+	if !ok {
+		return profile{}, usageError{"no profile " + name + " in " + configPath()}
+	}
+	return p, nil
+}
+
+//expandHome resolves a leading ~ in paths from the config file
+func expandHome(p string) string {
+	if p == "~" || strings.HasPrefix(p, "~/") {
+		return filepath.Join(os.Getenv("HOME"), p[1:])
+	}
+	return p
+}
+
+//readKeyFile reads a z85 curve key from a file, ignoring surrounding whitespace
+func readKeyFile(p string) (string, error) {
+	data, err := ioutil.ReadFile(expandHome(p))
+	if err != nil {
+		return "", err
+	}
+	return string(bytes.TrimSpace(data)), nil
+}
+
+//runTokenCommand runs a profile's token command with sh and returns what it
+//printed. The output is never echoed, even when the command fails.
+func runTokenCommand(command string) (string, error) {
+	cmd := exec.Command("sh", "-c", command)
+	cmd.Stderr = os.Stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("token command failed: %s", err.Error())
+	}
+	return string(bytes.TrimSpace(out)), nil
+}
+
+func runProfile(name string, args []string) error {
+	fs, _ := newFlagSet(name, "[list | show <name>]", "List the profiles in "+configPath()+" or show one with its secrets masked.")
+	pos, err := parseArgs(fs, args, -1)
+	if err != nil {
+		return err
+	}
+
+	c, err := loadConfig()
+	if err != nil {
+		return err
+	}
+
+	if len(pos) == 0 || pos[0] == "list" {
+		names := make([]string, 0, len(c.Profiles))
+		for n := range c.Profiles {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		for _, n := range names {
+			mark := " "
+			if n == c.DefaultProfile {
+				mark = "*"
+			}
+			fmt.Println(mark + " " + n)
+		}
+		return nil
+	}
+
+	if pos[0] != "show" || len(pos) != 2 {
+		fs.Usage()
+		return usageError{"expected list or show <name>"}
+	}
+	p, ok := c.Profiles[pos[1]]
+	if !ok {
+		return usageError{"no profile " + pos[1] + " in " + configPath()}
+	}
+
+	masked := func(v string) string {
+		if v == "" {
+			return "(not set)"
+		}
+		return "(set)"
+	}
+	fmt.Println("request_endpoint  " + p.RequestEndpoint)
+	fmt.Println("router_endpoint   " + p.RouterEndpoint)
+	fmt.Println("server_key_file   " + p.ServerKeyFile)
+	fmt.Println("client_key_file   " + p.ClientKeyFile)
+	fmt.Println("token             " + masked(p.Token))
+	fmt.Println("token_command     " + masked(p.TokenCommand))
+	fmt.Println("format            " + p.Format)
+	return nil
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/me-box/goZestClient/proxy"
+	zmq "github.com/pebbe/zmq4"
+)
+
+func runProxy(name string, args []string) error {
+	fs, conn := newFlagSet(name, "", "Serve one Zest endpoint that forwards each request to a store by path prefix, e.g.\n--route /kv/app1=storeA --route /ts/sensors=storeB. The connection flags pick the store for\npaths no route matches unless --no-default is given. Each store is reached with its profile's client key.")
+	listen := fs.String("listen", "tcp://*:5555", "endpoint apps send requests to")
+	listenRouter := fs.String("listen-router", "tcp://*:5556", "endpoint apps receive Observe and Notify messages on")
+	secretKeyFile := fs.String("secret-key-file", "", "read the proxy's curve secret key from a file, a new key is made if not given")
+	clientKeys := fs.String("client-keys", "", "comma separated public keys of the apps allowed to connect, any app if empty")
+	noDefault := fs.Bool("no-default", false, "answer paths no route matches with 4.04 Not Found")
+	var routeSpecs stringList
+	fs.Var(&routeSpecs, "route", "prefix=profile, send paths under prefix to the store in the profile, can be repeated")
+	_, err := parseArgs(fs, args, 0)
+	if err != nil {
+		return err
+	}
+	if len(routeSpecs) == 0 && *noDefault {
+		fs.Usage()
+		return usageError{"--no-default needs at least one --route"}
+	}
+
+	var routes []proxy.Route
+	for _, spec := range routeSpecs {
+		parts := strings.SplitN(spec, "=", 2)
+		if len(parts) != 2 {
+			return usageError{"--route should be prefix=profile: " + spec}
+		}
+		//each store is connected with the same flags as any command, taken from its profile
+		_, store := newFlagSet(name, "", "")
+		err = store.fs.Parse([]string{"--profile", parts[1], "--enable-logging=" + fmt.Sprint(*conn.logging)})
+		if err != nil {
+			return usageError{err.Error()}
+		}
+		storeC, err := store.client()
+		if err != nil {
+			return err
+		}
+		defer storeC.Close()
+		routes = append(routes, proxy.Route{Prefix: parts[0], Client: storeC})
+	}
+	if !*noDefault {
+		defaultC, err := conn.client()
+		if err != nil {
+			return err
+		}
+		defer defaultC.Close()
+		routes = append(routes, proxy.Route{Prefix: "/", Client: defaultC})
+	}
+
+	var secretKey string
+	if *secretKeyFile != "" {
+		secretKey, err = readKeyFile(*secretKeyFile)
+	} else {
+		_, secretKey, err = zmq.NewCurveKeypair()
+	}
+	if err != nil {
+		return err
+	}
+
+	p, err := proxy.New(secretKey, routes...)
+	if err != nil {
+		return usageError{err.Error()}
+	}
+	if *clientKeys != "" {
+		p.ClientKeys = strings.Split(*clientKeys, ",")
+	}
+
+	fmt.Fprintf(os.Stderr, "proxying %s and %s with %d routes, server key %s\n", *listen, *listenRouter, len(routes), p.PublicKey())
+	ctx, cancel := interrupted()
+	defer cancel()
+	return p.ListenAndServe(ctx, *listen, *listenRouter)
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	zest "github.com/me-box/goZestClient"
+	"github.com/me-box/goZestClient/replication"
+)
+
+func runReplicate(name string, args []string) error {
+	fs, conn := newFlagSet(name, "<path>", "Copy a /kv/ or /ts/ datasource to another store and keep it up to date until interrupted.\nThe connection flags pick the source, --dest-profile the destination.")
+	destProfile := fs.String("dest-profile", "", "profile of the destination store")
+	destPath := fs.String("dest-path", "", "datasource to write to, the same path if not given")
+	checkpoint := fs.String("checkpoint", "", "file recording how far a time series has been copied, so a restart carries on from there")
+	window := fs.Duration("window", time.Hour, "how much of a time series to read per range query")
+	interval := fs.Duration("interval", time.Minute, "how often to check for new data besides observing it")
+	quiet := fs.Bool("quiet", false, "don't report each value copied")
+	pos, err := parseArgs(fs, args, 1)
+	if err != nil {
+		return err
+	}
+	if *destProfile == "" {
+		fs.Usage()
+		return usageError{"--dest-profile is needed"}
+	}
+
+	source, err := conn.client()
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+
+	//the destination is connected with the same flags as any command, taken from its profile
+	_, dest := newFlagSet(name, "", "")
+	err = dest.fs.Parse([]string{"--profile", *destProfile})
+	if err != nil {
+		return usageError{err.Error()}
+	}
+	destC, err := dest.client()
+	if err != nil {
+		return err
+	}
+	defer destC.Close()
+
+	r := &replication.Replicator{
+		Source:      source,
+		SourceToken: *conn.token,
+		Dest:        destC,
+		DestToken:   *dest.token,
+		Path:        pos[0],
+		DestPath:    *destPath,
+		Format:      *conn.format,
+		Window:      int64(*window / time.Millisecond),
+		Interval:    *interval,
+	}
+	if *checkpoint != "" {
+		r.Checkpoint = replication.FileCheckpoint(expandHome(*checkpoint))
+	}
+	if !*quiet {
+		r.OnCopy = func(rec zest.Record) {
+			fmt.Fprintln(os.Stderr, "copied "+describeRecord(rec))
+		}
+	}
+
+	ctx, cancel := interrupted()
+	defer cancel()
+	return r.Run(ctx)
+}
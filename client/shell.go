@@ -0,0 +1,460 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/chzyer/readline"
+	zest "github.com/me-box/goZestClient"
+)
+
+const shellHelp = `Commands:
+  get <path>                       read the value at path
+  post <path> <payload>            write the rest of the line to path
+  delete <path>                    delete the value at path
+  observe <path> [mode] [timeout]  watch path in the background, mode is data, audit or notification
+  notify <path> [timeout]          wait in the background for the next message posted to path
+  jobs                             list background observations and notifies
+  stop <id>|all                    stop background jobs
+  cd [path]                        change the directory relative paths are resolved against
+  pwd                              print the current directory
+  format [name]                    show or change the content format
+  token [token]                    show whether a token is set or change it
+  help                             show this help
+  exit                             leave the shell`
+
+//shellCommands are offered by tab completion for the first word of a line
+var shellCommands = []string{"get", "post", "delete", "observe", "notify", "jobs", "stop", "cd", "pwd", "format", "token", "help", "exit"}
+
+type shellJob struct {
+	desc string
+	stop func()
+}
+
+type shell struct {
+	client *zest.ZestClient
+	token  string
+	format string
+	cwd    string
+	rl     *readline.Instance
+
+	mu      sync.Mutex
+	paths   map[string]bool
+	jobs    map[int]*shellJob
+	nextJob int
+}
+
+func runShell(name string, args []string) error {
+	fs, conn := newFlagSet(name, "", "Keep one client open and run get, post, delete, observe and notify interactively.")
+	_, err := parseArgs(fs, args, 0)
+	if err != nil {
+		return err
+	}
+
+	zestC, err := conn.client()
+	if err != nil {
+		return err
+	}
+	defer zestC.Close()
+
+	sh := &shell{
+		client: zestC,
+		token:  *conn.token,
+		format: *conn.format,
+		cwd:    "/",
+		paths:  map[string]bool{"/kv/": true, "/ts/": true, "/ts/blob/": true, "/cat": true},
+		jobs:   map[int]*shellJob{},
+	}
+
+	sh.rl, err = readline.NewEx(&readline.Config{
+		Prompt:       sh.prompt(),
+		HistoryFile:  filepath.Join(os.Getenv("HOME"), ".zest_history"),
+		AutoComplete: sh,
+		//lines are saved by the loop below so tokens stay out of the file
+		DisableAutoSaveHistory: true,
+	})
+	if err != nil {
+		return err
+	}
+	defer sh.rl.Close()
+
+	fmt.Fprintln(sh.rl.Stdout(), "Connected to "+*conn.reqEndpoint+", type help for commands")
+	for {
+		line, err := sh.rl.Readline()
+		if err == readline.ErrInterrupt {
+			continue
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		if keepInHistory(line) {
+			sh.rl.SaveHistory(line)
+		}
+		if line == "exit" || line == "quit" {
+			break
+		}
+		err = sh.exec(line)
+		if err != nil {
+			fmt.Fprintln(sh.rl.Stderr(), "error: "+err.Error())
+		}
+	}
+
+	sh.stopJobs("all")
+	return nil
+}
+
+//keepInHistory reports whether line can be saved to the history file, token
+//commands are left out so tokens aren't written to disk in clear
+func keepInHistory(line string) bool {
+	fields := strings.Fields(line)
+	return len(fields) == 0 || fields[0] != "token"
+}
+
+func (sh *shell) prompt() string {
+	return "zest:" + sh.cwd + "> "
+}
+
+func (sh *shell) println(s string) {
+	fmt.Fprintln(sh.rl.Stdout(), s)
+}
+
+//resolve turns a path relative to the current directory into an absolute one
+func (sh *shell) resolve(p string) string {
+	if p == "" {
+		return sh.cwd
+	}
+	if !strings.HasPrefix(p, "/") {
+		p = sh.cwd + "/" + p
+	}
+	return path.Clean(p)
+}
+
+//remember records a path that worked so it can be tab completed
+func (sh *shell) remember(p string) {
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	sh.paths[p] = true
+	for dir := path.Dir(p); dir != "/"; dir = path.Dir(dir) {
+		sh.paths[dir+"/"] = true
+	}
+}
+
+//splitLine splits off the first n-1 words of line, the last element holds the rest of the line
+func splitLine(line string, n int) []string {
+	var parts []string
+	for len(parts) < n-1 {
+		line = strings.TrimLeft(line, " \t")
+		if line == "" {
+			return parts
+		}
+		i := strings.IndexAny(line, " \t")
+		if i < 0 {
+			return append(parts, line)
+		}
+		parts = append(parts, line[:i])
+		line = line[i:]
+	}
+	line = strings.TrimSpace(line)
+	if line != "" {
+		parts = append(parts, line)
+	}
+	return parts
+}
+
+func (sh *shell) exec(line string) error {
+
+	args := strings.Fields(line)
+	switch args[0] {
+	case "help":
+		sh.println(shellHelp)
+
+	case "pwd":
+		sh.println(sh.cwd)
+
+	case "cd":
+		p := "/"
+		if len(args) > 1 {
+			p = sh.resolve(args[1])
+		}
+		sh.cwd = p
+		sh.rl.SetPrompt(sh.prompt())
+
+	case "format":
+		if len(args) == 1 {
+			sh.println(sh.format)
+			return nil
+		}
+		_, ok := zest.LookupContentFormat(args[1])
+		if !ok {
+			return usageError{"unsupported content format " + args[1]}
+		}
+		sh.format = args[1]
+
+	case "token":
+		if len(args) == 1 {
+			if sh.token == "" {
+				sh.println("no token set")
+			} else {
+				sh.println("token set")
+			}
+			return nil
+		}
+		sh.token = args[1]
+
+	case "get":
+		p := ""
+		if len(args) > 1 {
+			p = args[1]
+		}
+		p = sh.resolve(p)
+		value, err := sh.client.Get(sh.token, p, sh.format)
+		if err != nil {
+			return err
+		}
+		sh.remember(p)
+		sh.println(string(value))
+
+	case "post":
+		parts := splitLine(line, 3)
+		if len(parts) < 2 {
+			return usageError{"usage: post <path> <payload>"}
+		}
+		payload := ""
+		if len(parts) == 3 {
+			payload = parts[2]
+		}
+		p := sh.resolve(parts[1])
+		resp, err := sh.client.Post(sh.token, p, []byte(payload), sh.format)
+		if err != nil {
+			return err
+		}
+		sh.remember(p)
+		if len(resp) > 0 {
+			sh.println(string(resp))
+		}
+
+	case "delete":
+		if len(args) < 2 {
+			return usageError{"usage: delete <path>"}
+		}
+		p := sh.resolve(args[1])
+		err := sh.client.Delete(sh.token, p, sh.format)
+		if err != nil {
+			return err
+		}
+		sh.remember(p)
+
+	case "observe":
+		return sh.observe(args[1:])
+
+	case "notify":
+		return sh.notify(args[1:])
+
+	case "jobs":
+		sh.mu.Lock()
+		ids := make([]int, 0, len(sh.jobs))
+		for id := range sh.jobs {
+			ids = append(ids, id)
+		}
+		sort.Ints(ids)
+		for _, id := range ids {
+			sh.println("[" + strconv.Itoa(id) + "] " + sh.jobs[id].desc)
+		}
+		sh.mu.Unlock()
+
+	case "stop":
+		if len(args) < 2 {
+			return usageError{"usage: stop <id>|all"}
+		}
+		return sh.stopJobs(args[1])
+
+	default:
+		return usageError{"unknown command " + args[0] + ", type help for commands"}
+	}
+
+	return nil
+}
+
+func (sh *shell) addJob(desc string, stop func()) int {
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	sh.nextJob++
+	sh.jobs[sh.nextJob] = &shellJob{desc: desc, stop: stop}
+	return sh.nextJob
+}
+
+//removeJob forgets a job, it reports false if the job was already gone
+func (sh *shell) removeJob(id int) (*shellJob, bool) {
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	j, ok := sh.jobs[id]
+	delete(sh.jobs, id)
+	return j, ok
+}
+
+func (sh *shell) stopJobs(which string) error {
+	var ids []int
+	if which == "all" {
+		sh.mu.Lock()
+		for id := range sh.jobs {
+			ids = append(ids, id)
+		}
+		sh.mu.Unlock()
+	} else {
+		id, err := strconv.Atoi(which)
+		if err != nil {
+			return usageError{"usage: stop <id>|all"}
+		}
+		ids = append(ids, id)
+	}
+
+	for _, id := range ids {
+		j, ok := sh.removeJob(id)
+		if !ok {
+			return usageError{"no job " + strconv.Itoa(id)}
+		}
+		j.stop()
+	}
+	return nil
+}
+
+func (sh *shell) observe(args []string) error {
+	if len(args) < 1 {
+		return usageError{"usage: observe <path> [data|audit|notification] [timeout]"}
+	}
+	p := sh.resolve(args[0])
+	mode := zest.ObserveModeData
+	if len(args) > 1 {
+		mode = zest.ObserveMode(args[1])
+		if mode != zest.ObserveModeData && mode != zest.ObserveModeAudit && mode != zest.ObserveModeNotification {
+			return usageError{"unsupported observe mode " + args[1]}
+		}
+	}
+	timeout, err := shellTimeout(args, 2)
+	if err != nil {
+		return err
+	}
+
+	dataChan, doneChan, err := sh.client.Observe(sh.token, p, sh.format, mode, timeout)
+	if err != nil {
+		return err
+	}
+	sh.remember(p)
+
+	var once sync.Once
+	stop := func() {
+		once.Do(func() {
+			close(doneChan)
+		})
+	}
+	id := sh.addJob("observe "+p+" "+string(mode), stop)
+	tag := "[" + strconv.Itoa(id) + " " + p + "] "
+	sh.println("observing " + p + " as job " + strconv.Itoa(id))
+
+	go func() {
+		for data := range dataChan {
+			sh.println(tag + string(data))
+		}
+		//the observation ended on its own, e.g. the Max-Age expired
+		if _, ok := sh.removeJob(id); ok {
+			stop()
+			sh.println(tag + "observation ended")
+		}
+	}()
+	return nil
+}
+
+func (sh *shell) notify(args []string) error {
+	if len(args) < 1 {
+		return usageError{"usage: notify <path> [timeout]"}
+	}
+	p := sh.resolve(args[0])
+	timeout, err := shellTimeout(args, 1)
+	if err != nil {
+		return err
+	}
+
+	future, err := sh.client.Notify(sh.token, p, sh.format, timeout)
+	if err != nil {
+		return err
+	}
+	sh.remember(p)
+
+	id := sh.addJob("notify "+p, future.Close)
+	tag := "[" + strconv.Itoa(id) + " " + p + "] "
+	sh.println("waiting for " + p + " as job " + strconv.Itoa(id))
+
+	go func() {
+		data, err := future.Wait(context.Background())
+		if _, ok := sh.removeJob(id); !ok {
+			//stopped by the user
+			return
+		}
+		if err != nil {
+			sh.println(tag + "error: " + err.Error())
+			return
+		}
+		sh.println(tag + string(data))
+	}()
+	return nil
+}
+
+func shellTimeout(args []string, i int) (uint32, error) {
+	if len(args) <= i {
+		return 0, nil
+	}
+	t, err := strconv.ParseUint(args[i], 10, 32)
+	if err != nil {
+		return 0, usageError{"timeout must be a number of seconds"}
+	}
+	return uint32(t), nil
+}
+
+//Do implements readline.AutoCompleter, completing commands for the first word
+//and remembered paths after that
+func (sh *shell) Do(line []rune, pos int) ([][]rune, int) {
+
+	text := string(line[:pos])
+	start := strings.LastIndexAny(text, " \t") + 1
+	word := text[start:]
+
+	var candidates []string
+	if strings.TrimSpace(text[:start]) == "" {
+		candidates = shellCommands
+	} else {
+		sh.mu.Lock()
+		for p := range sh.paths {
+			if strings.HasPrefix(word, "/") {
+				candidates = append(candidates, p)
+			} else if rel := strings.TrimPrefix(p, strings.TrimSuffix(sh.cwd, "/")+"/"); rel != p {
+				candidates = append(candidates, rel)
+			}
+		}
+		sh.mu.Unlock()
+		sort.Strings(candidates)
+	}
+
+	var matches [][]rune
+	for _, c := range candidates {
+		if strings.HasPrefix(c, word) && c != word {
+			matches = append(matches, []rune(c[len(word):]))
+		}
+	}
+	return matches, len([]rune(word))
+}
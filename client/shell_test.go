@@ -0,0 +1,19 @@
+package main
+
+import "testing"
+
+func TestKeepInHistory(t *testing.T) {
+	cases := map[string]bool{
+		"get /kv/foo":          true,
+		"token":                false,
+		"token secret-value":   false,
+		"token   secret-value": false,
+		"tokens":               true,
+		"post /kv/token value": true,
+	}
+	for line, want := range cases {
+		if got := keepInHistory(line); got != want {
+			t.Errorf("%q: got %v, want %v", line, got, want)
+		}
+	}
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	zest "github.com/me-box/goZestClient"
+)
+
+func runToken(name string, args []string) error {
+	fs, conn := newFlagSet(name, "<target> <path>", "Ask the arbiter for a token to use method on path of the store target, and print it.\nThe connection flags point at the arbiter, with --token the app's arbiter token. Useful as a profile's token_command.")
+	method := fs.String("method", "GET", "GET, POST or DELETE")
+	pos, err := parseArgs(fs, args, 2)
+	if err != nil {
+		return err
+	}
+	switch strings.ToUpper(*method) {
+	case "GET", "POST", "DELETE":
+	default:
+		return usageError{"method should be GET, POST or DELETE"}
+	}
+
+	arbiter, err := conn.client()
+	if err != nil {
+		return err
+	}
+	defer arbiter.Close()
+
+	source := zest.NewArbiterTokenSource(arbiter, *conn.token)
+	token, err := source.Token(context.Background(), pos[0], pos[1], *method)
+	if err != nil {
+		return err
+	}
+	fmt.Println(token)
+	return nil
+}
@@ -0,0 +1,235 @@
+//Package gateway exposes the paths of a Zest store over plain HTTP so tools
+//that can't speak zmq and CURVE, such as browsers and curl, can use it.
+package gateway
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	zest "github.com/me-box/goZestClient"
+)
+
+//Handler translates HTTP GET, POST and DELETE requests into requests on the
+//same path of a Zest store. The Content-Type of a POST, or the Accept header
+//of a GET, picks the Zest content format and a bearer token in the
+//Authorization header is used as the Zest token. A query string is passed on
+//as Uri-Query options.
+type Handler struct {
+	Client *zest.ZestClient
+	//Token is used for requests without a bearer token
+	Token string
+	//Format is the content format for requests that don't name one
+	Format string
+	//Prefixes are the paths the gateway forwards, anything else is not found
+	Prefixes []string
+	//MaxBodyBytes limits POST bodies, 0 for no limit
+	MaxBodyBytes int64
+}
+
+//New returns a Handler for the /kv/ and /ts/ paths of the store client talks to
+func New(client *zest.ZestClient) *Handler {
+	return &Handler{
+		Client:   client,
+		Format:   "JSON",
+		Prefixes: []string{"/kv/", "/ts/"},
+	}
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	//clean the path here rather than relying on a mux, so /kv/../cat can't
+	//slip past the prefix check
+	target := path.Clean("/" + r.URL.Path)
+	if !h.allowed(target) {
+		http.NotFound(w, r)
+		return
+	}
+
+	token := h.Token
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		token = strings.TrimSpace(auth[len("Bearer "):])
+	}
+	if r.URL.RawQuery != "" {
+		target += "?" + r.URL.RawQuery
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		format, ok := h.accept(r.Header.Get("Accept"))
+		if !ok {
+			http.Error(w, "no supported content format in Accept", http.StatusNotAcceptable)
+			return
+		}
+		var body bytes.Buffer
+		_, err := h.Client.GetTo(r.Context(), token, target, &body, format.Name)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", format.MediaType)
+		w.WriteHeader(http.StatusOK)
+		body.WriteTo(w)
+
+	case http.MethodPost:
+		format, ok := h.format(r.Header.Get("Content-Type"))
+		if !ok {
+			http.Error(w, "unsupported Content-Type", http.StatusUnsupportedMediaType)
+			return
+		}
+		var body io.Reader = r.Body
+		if h.MaxBodyBytes > 0 {
+			body = http.MaxBytesReader(w, r.Body, h.MaxBodyBytes)
+		}
+		resp, err := h.Client.PostReader(r.Context(), token, target, body, format.Name)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		if len(resp) > 0 {
+			w.Header().Set("Content-Type", format.MediaType)
+		}
+		w.WriteHeader(http.StatusCreated)
+		w.Write(resp)
+
+	case http.MethodDelete:
+		format, _ := h.format("")
+		err := h.Client.Delete(token, target, format.Name)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		w.Header().Set("Allow", "GET, POST, DELETE")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (h *Handler) allowed(target string) bool {
+	for _, p := range h.Prefixes {
+		if strings.HasPrefix(target, p) {
+			return true
+		}
+	}
+	return false
+}
+
+//format looks up a media type, an empty one means the handler's default
+func (h *Handler) format(mediaType string) (zest.ContentFormat, bool) {
+	if mediaType == "" || mediaType == "*/*" {
+		mediaType = h.Format
+	}
+	if t, _, err := mime.ParseMediaType(mediaType); err == nil {
+		mediaType = t
+	}
+	return zest.LookupContentFormat(mediaType)
+}
+
+//accept picks the content format for an Accept header. Media types are tried
+//from the highest q value, in the order given for equal ones. */* and type/*
+//stand for the handler's default format, types the gateway can't produce are
+//skipped and q=0 rules a type out.
+func (h *Handler) accept(header string) (zest.ContentFormat, bool) {
+	if strings.TrimSpace(header) == "" {
+		return h.format("")
+	}
+	type choice struct {
+		mediaType string
+		q         float64
+	}
+	var choices []choice
+	for _, part := range strings.Split(header, ",") {
+		t, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			q, err = strconv.ParseFloat(v, 64)
+			if err != nil {
+				continue
+			}
+		}
+		if q > 0 {
+			choices = append(choices, choice{t, q})
+		}
+	}
+	sort.SliceStable(choices, func(i, j int) bool {
+		return choices[i].q > choices[j].q
+	})
+
+	for _, c := range choices {
+		if strings.HasSuffix(c.mediaType, "/*") {
+			return h.format("")
+		}
+		if f, ok := zest.LookupContentFormat(c.mediaType); ok {
+			return f, true
+		}
+	}
+	return zest.ContentFormat{}, false
+}
+
+//StatusCode maps an error from a ZestClient call to an HTTP status. Zest
+//response codes use the HTTP status of the same meaning, other failures to
+//reach the store are reported as a bad gateway.
+func StatusCode(err error) int {
+	if be, ok := err.(*zest.BlockError); ok {
+		err = be.Err
+	}
+	switch e := err.(type) {
+	case *zest.ResponseError:
+		switch e.Code {
+		case 128, 136:
+			return http.StatusBadRequest
+		case 129:
+			return http.StatusUnauthorized
+		case 131:
+			return http.StatusForbidden
+		case 132:
+			return http.StatusNotFound
+		case 133:
+			return http.StatusMethodNotAllowed
+		case 134:
+			return http.StatusNotAcceptable
+		case 140:
+			return http.StatusPreconditionFailed
+		case 141:
+			return http.StatusRequestEntityTooLarge
+		case 143:
+			return http.StatusUnsupportedMediaType
+		case 160:
+			return http.StatusInternalServerError
+		case 161:
+			return http.StatusNotImplemented
+		case 162:
+			return http.StatusBadGateway
+		case 163:
+			return http.StatusServiceUnavailable
+		case 164:
+			return http.StatusGatewayTimeout
+		}
+		if e.Code>>5 == 4 {
+			return http.StatusBadRequest
+		}
+		return http.StatusBadGateway
+	case *zest.TimeoutError:
+		return http.StatusGatewayTimeout
+	case *http.MaxBytesError:
+		return http.StatusRequestEntityTooLarge
+	}
+	if err == zest.ErrRequestTimeout || err == context.DeadlineExceeded {
+		return http.StatusGatewayTimeout
+	}
+	return http.StatusBadGateway
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	http.Error(w, err.Error(), StatusCode(err))
+}
@@ -0,0 +1,254 @@
+package gateway_test
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	zest "github.com/me-box/goZestClient"
+	"github.com/me-box/goZestClient/gateway"
+	"github.com/me-box/goZestClient/zesttest"
+)
+
+//newGateway returns a gateway to s over a test HTTP server
+func newGateway(t *testing.T, s *zesttest.Server) (*gateway.Handler, *httptest.Server) {
+	z, err := zest.New("", "", "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	z.SetTransport(s)
+	h := gateway.New(z)
+	srv := httptest.NewServer(h)
+	t.Cleanup(srv.Close)
+	return h, srv
+}
+
+func do(t *testing.T, method string, url string, body string, header ...string) (*http.Response, string) {
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i+1 < len(header); i += 2 {
+		req.Header.Set(header[i], header[i+1])
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	data, _ := ioutil.ReadAll(resp.Body)
+	return resp, string(data)
+}
+
+//lastFormat is the Content-Format of the last request s received
+func lastFormat(s *zesttest.Server) string {
+	reqs := s.Requests()
+	return reqs[len(reqs)-1].ContentFormat
+}
+
+func TestContentTypePicksFormat(t *testing.T) {
+	s := zesttest.NewServer()
+	_, srv := newGateway(t, s)
+
+	cases := []struct {
+		contentType string
+		status      int
+		format      string
+	}{
+		{"application/json", http.StatusCreated, "application/json (50)"},
+		{"application/json; charset=utf-8", http.StatusCreated, "application/json (50)"},
+		{"text/plain", http.StatusCreated, "text/plain;charset=utf-8 (0)"},
+		{"application/octet-stream", http.StatusCreated, "application/octet-stream (42)"},
+		{"", http.StatusCreated, "application/json (50)"},
+		{"image/png", http.StatusUnsupportedMediaType, ""},
+	}
+	for _, c := range cases {
+		before := len(s.Requests())
+		resp, _ := do(t, "POST", srv.URL+"/kv/test/key", `{"a":1}`, "Content-Type", c.contentType)
+		if resp.StatusCode != c.status {
+			t.Errorf("Content-Type %q: status %d, want %d", c.contentType, resp.StatusCode, c.status)
+			continue
+		}
+		if c.format == "" {
+			if len(s.Requests()) != before {
+				t.Errorf("Content-Type %q: the request should not reach the store", c.contentType)
+			}
+			continue
+		}
+		if f := lastFormat(s); f != c.format {
+			t.Errorf("Content-Type %q: sent as %s, want %s", c.contentType, f, c.format)
+		}
+	}
+}
+
+func TestAcceptPicksFormat(t *testing.T) {
+	s := zesttest.NewServer()
+	s.Set("/kv/test/key", []byte("hello"), "TEXT")
+	_, srv := newGateway(t, s)
+
+	cases := []struct {
+		accept      string
+		status      int
+		contentType string
+	}{
+		{"text/plain", http.StatusOK, "text/plain;charset=utf-8"},
+		{"text/html, application/json;q=0.9", http.StatusOK, "application/json"},
+		{"*/*", http.StatusOK, "application/json"},
+		{"", http.StatusOK, "application/json"},
+		{"image/png", http.StatusNotAcceptable, ""},
+		//a browser asking for a page
+		{"text/html,application/xhtml+xml,application/xml;q=0.9,image/avif,image/webp,*/*;q=0.8", http.StatusOK, "application/json"},
+		{"text/*", http.StatusOK, "application/json"},
+		{"application/json;q=0.5, text/plain", http.StatusOK, "text/plain;charset=utf-8"},
+		{"text/plain;q=0, */*", http.StatusOK, "application/json"},
+		{"text/plain;q=0", http.StatusNotAcceptable, ""},
+		{"image/png, image/*", http.StatusOK, "application/json"},
+	}
+	for _, c := range cases {
+		resp, body := do(t, "GET", srv.URL+"/kv/test/key", "", "Accept", c.accept)
+		if resp.StatusCode != c.status {
+			t.Errorf("Accept %q: status %d, want %d", c.accept, resp.StatusCode, c.status)
+			continue
+		}
+		if c.status != http.StatusOK {
+			continue
+		}
+		if ct := resp.Header.Get("Content-Type"); ct != c.contentType {
+			t.Errorf("Accept %q: Content-Type %q, want %q", c.accept, ct, c.contentType)
+		}
+		if body != "hello" {
+			t.Errorf("Accept %q: body %q", c.accept, body)
+		}
+	}
+}
+
+func TestBearerTokenIsPassedOn(t *testing.T) {
+	s := zesttest.NewServer()
+	s.Token = "secret"
+	h, srv := newGateway(t, s)
+
+	resp, _ := do(t, "GET", srv.URL+"/kv/test/key", "")
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("without a token: status %d, want 401", resp.StatusCode)
+	}
+
+	resp, _ = do(t, "GET", srv.URL+"/kv/test/key", "", "Authorization", "Bearer secret")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("with a bearer token: status %d, want 200", resp.StatusCode)
+	}
+	reqs := s.Requests()
+	if tok := reqs[len(reqs)-1].Token; tok != "secret" {
+		t.Fatalf("sent token %q", tok)
+	}
+
+	h.Token = "secret"
+	resp, _ = do(t, "GET", srv.URL+"/kv/test/key", "")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("with the handler's token: status %d, want 200", resp.StatusCode)
+	}
+}
+
+func TestPathIsCleaned(t *testing.T) {
+	s := zesttest.NewServer()
+	s.Set("/cat", []byte("{}"), "JSON")
+	h, _ := newGateway(t, s)
+
+	for _, p := range []string{"/kv/../cat", "/ts/../cat", "/kv/./../cat"} {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest("GET", p, nil))
+		if rec.Code != http.StatusNotFound {
+			t.Errorf("GET %s: status %d, want 404", p, rec.Code)
+		}
+	}
+	if n := len(s.Requests()); n != 0 {
+		t.Fatalf("%d requests reached the store", n)
+	}
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("POST", "/kv/a/../b/key", strings.NewReader("1")))
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("status %d, want 201", rec.Code)
+	}
+	if _, ok := s.Value("/kv/b/key"); !ok {
+		t.Fatal("the cleaned path should be written")
+	}
+}
+
+func TestStatusCodeForZestResponses(t *testing.T) {
+	cases := map[uint8]int{
+		128: http.StatusBadRequest,
+		129: http.StatusUnauthorized,
+		131: http.StatusForbidden,
+		132: http.StatusNotFound,
+		133: http.StatusMethodNotAllowed,
+		134: http.StatusNotAcceptable,
+		136: http.StatusBadRequest,
+		140: http.StatusPreconditionFailed,
+		141: http.StatusRequestEntityTooLarge,
+		143: http.StatusUnsupportedMediaType,
+		150: http.StatusBadRequest,
+		160: http.StatusInternalServerError,
+		161: http.StatusNotImplemented,
+		162: http.StatusBadGateway,
+		163: http.StatusServiceUnavailable,
+		164: http.StatusGatewayTimeout,
+		165: http.StatusBadGateway,
+	}
+	for code, want := range cases {
+		err := &zest.ResponseError{Code: code}
+		if got := gateway.StatusCode(err); got != want {
+			t.Errorf("%s: status %d, want %d", zest.CodeName(code), got, want)
+		}
+		if got := gateway.StatusCode(&zest.BlockError{Block: 2, Err: err}); got != want {
+			t.Errorf("%s in a block: status %d, want %d", zest.CodeName(code), got, want)
+		}
+	}
+}
+
+func TestStatusCodeForOtherErrors(t *testing.T) {
+	cases := []struct {
+		err  error
+		want int
+	}{
+		{zest.ErrRequestTimeout, http.StatusGatewayTimeout},
+		{context.DeadlineExceeded, http.StatusGatewayTimeout},
+		{&zest.TimeoutError{Path: "/kv/a", MaxAge: 1}, http.StatusGatewayTimeout},
+		{&http.MaxBytesError{Limit: 10}, http.StatusRequestEntityTooLarge},
+		{errors.New("Can't connect so server"), http.StatusBadGateway},
+		//only the typed errors count, not their wording
+		{errors.New("timeout"), http.StatusBadGateway},
+	}
+	for _, c := range cases {
+		if got := gateway.StatusCode(c.err); got != c.want {
+			t.Errorf("%v: status %d, want %d", c.err, got, c.want)
+		}
+	}
+}
+
+func TestStoreErrorsBecomeStatuses(t *testing.T) {
+	s := zesttest.NewServer()
+	s.Handle = func(req zest.Frame) (zest.Frame, bool) {
+		return zest.Frame{Code: 132}, true
+	}
+	_, srv := newGateway(t, s)
+
+	resp, _ := do(t, "GET", srv.URL+"/kv/missing", "")
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("status %d, want 404", resp.StatusCode)
+	}
+}
+
+func TestBodyTooLarge(t *testing.T) {
+	s := zesttest.NewServer()
+	h, srv := newGateway(t, s)
+	h.MaxBodyBytes = 4
+
+	resp, _ := do(t, "POST", srv.URL+"/kv/test/key", "too long", "Content-Type", "text/plain")
+	if resp.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Fatalf("status %d, want 413", resp.StatusCode)
+	}
+}
@@ -0,0 +1,307 @@
+package gateway
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gorilla/websocket"
+	zest "github.com/me-box/goZestClient"
+)
+
+//viewerBuffer is how many events a slow viewer can fall behind before it misses some
+const viewerBuffer = 64
+
+//Stream serves Observe and Notify subscriptions to browsers as Server-Sent
+//Events, or over a WebSocket when the request asks to upgrade. The query
+//parameter mode picks data, audit or notification observation, or notify for
+//a single message, and timeout sets the Max-Age in seconds. Viewers of the
+//same path, mode, token and format share one upstream Observe, which is closed
+//when the last of them disconnects. The token comes from a bearer token or,
+//as browsers can't set headers on EventSource or WebSocket, the token parameter.
+type Stream struct {
+	Client *zest.ZestClient
+	//Token is used for requests without a token of their own
+	Token string
+	//Format is the content format for requests that don't name one
+	Format string
+	//Prefixes are the paths that can be observed
+	Prefixes []string
+	//Upgrader sets up WebSocket connections, by default only same origin requests are accepted
+	Upgrader websocket.Upgrader
+
+	mu   sync.Mutex
+	hubs map[hubKey]*hub
+}
+
+type hubKey struct {
+	path   string
+	mode   zest.ObserveMode
+	token  string
+	format string
+	maxAge uint32
+}
+
+//hub fans the events of one upstream Observe out to its viewers. ready is
+//closed once the Observe has started, or failed with err.
+type hub struct {
+	key     hubKey
+	ready   chan struct{}
+	err     error
+	done    chan struct{}
+	viewers map[chan []byte]struct{}
+}
+
+//NewStream returns a Stream for the /kv/ and /ts/ paths of the store client talks to
+func NewStream(client *zest.ZestClient) *Stream {
+	return &Stream{
+		Client:   client,
+		Format:   "JSON",
+		Prefixes: []string{"/kv/", "/ts/"},
+	}
+}
+
+func (s *Stream) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	//clean the path as Handler does, so /ts/../kv can't slip past the prefix check
+	target := path.Clean("/" + r.URL.Path)
+	allowed := false
+	for _, p := range s.Prefixes {
+		allowed = allowed || strings.HasPrefix(target, p)
+	}
+	if !allowed {
+		http.NotFound(w, r)
+		return
+	}
+
+	q := r.URL.Query()
+	token := s.Token
+	if t := q.Get("token"); t != "" {
+		token = t
+	}
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		token = strings.TrimSpace(auth[len("Bearer "):])
+	}
+	format := s.Format
+	if f := q.Get("format"); f != "" {
+		format = f
+	}
+	if _, ok := zest.LookupContentFormat(format); !ok {
+		http.Error(w, "unsupported format "+format, http.StatusUnsupportedMediaType)
+		return
+	}
+	var maxAge uint32
+	if t := q.Get("timeout"); t != "" {
+		n, err := strconv.ParseUint(t, 10, 32)
+		if err != nil {
+			http.Error(w, "timeout should be a number of seconds", http.StatusBadRequest)
+			return
+		}
+		maxAge = uint32(n)
+	}
+
+	mode := q.Get("mode")
+	if mode == "" {
+		mode = string(zest.ObserveModeData)
+	}
+	var events <-chan []byte
+	var leave func()
+	switch mode {
+	case string(zest.ObserveModeData), string(zest.ObserveModeAudit), string(zest.ObserveModeNotification):
+		h, viewer, err := s.join(hubKey{path: target, mode: zest.ObserveMode(mode), token: token, format: format, maxAge: maxAge})
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		events = viewer
+		leave = func() { s.leave(h, viewer) }
+	case "notify":
+		future, err := s.Client.Notify(token, target, format, maxAge)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		events = notifyEvents(r.Context(), future)
+		leave = future.Close
+	default:
+		http.Error(w, "mode should be data, audit, notification or notify", http.StatusBadRequest)
+		return
+	}
+	defer leave()
+
+	if websocket.IsWebSocketUpgrade(r) {
+		s.serveWebSocket(w, r, events)
+		return
+	}
+	serveSSE(w, r, mode, events)
+}
+
+//join adds a viewer to the hub for key. The first viewer starts the upstream
+//Observe, anyone joining meanwhile waits for it rather than starting another.
+func (s *Stream) join(key hubKey) (*hub, chan []byte, error) {
+	viewer := make(chan []byte, viewerBuffer)
+
+	s.mu.Lock()
+	h, ok := s.hubs[key]
+	if !ok {
+		h = &hub{key: key, ready: make(chan struct{}), viewers: map[chan []byte]struct{}{}}
+		if s.hubs == nil {
+			s.hubs = map[hubKey]*hub{}
+		}
+		s.hubs[key] = h
+	}
+	h.viewers[viewer] = struct{}{}
+	s.mu.Unlock()
+
+	if !ok {
+		dataChan, doneChan, err := s.Client.Observe(key.token, key.path, key.format, key.mode, key.maxAge)
+		s.mu.Lock()
+		h.err = err
+		h.done = doneChan
+		if err != nil {
+			delete(s.hubs, key)
+		}
+		s.mu.Unlock()
+		close(h.ready)
+		if err == nil {
+			go s.pump(h, dataChan)
+		}
+	}
+
+	<-h.ready
+	if h.err != nil {
+		return nil, nil, h.err
+	}
+	return h, viewer, nil
+}
+
+//leave removes a viewer, closing the upstream Observe after the last one
+func (s *Stream) leave(h *hub, viewer chan []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(h.viewers, viewer)
+	if len(h.viewers) == 0 && s.hubs[h.key] == h {
+		delete(s.hubs, h.key)
+		close(h.done)
+	}
+}
+
+//pump copies upstream events to every viewer until the Observe ends, then
+//closes the viewers' channels so their streams finish
+func (s *Stream) pump(h *hub, dataChan <-chan []byte) {
+	for msg := range dataChan {
+		s.mu.Lock()
+		for viewer := range h.viewers {
+			select {
+			case viewer <- msg:
+			default:
+				//the viewer is too slow and misses this event
+			}
+		}
+		s.mu.Unlock()
+	}
+
+	s.mu.Lock()
+	if s.hubs[h.key] == h {
+		delete(s.hubs, h.key)
+	}
+	for viewer := range h.viewers {
+		close(viewer)
+	}
+	h.viewers = map[chan []byte]struct{}{}
+	s.mu.Unlock()
+}
+
+//notifyEvents delivers the message a Notify resolves with, if any
+func notifyEvents(ctx context.Context, future *zest.NotifyFuture) <-chan []byte {
+	events := make(chan []byte, 1)
+	go func() {
+		defer close(events)
+		msg, err := future.Wait(ctx)
+		if err == nil {
+			events <- msg
+		}
+	}()
+	return events
+}
+
+func serveSSE(w http.ResponseWriter, r *http.Request, mode string, events <-chan []byte) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	for {
+		select {
+		case msg, ok := <-events:
+			if !ok {
+				return
+			}
+			var b bytes.Buffer
+			fmt.Fprintf(&b, "event: %s\n", mode)
+			for _, line := range strings.Split(string(msg), "\n") {
+				fmt.Fprintf(&b, "data: %s\n", strings.TrimSuffix(line, "\r"))
+			}
+			b.WriteString("\n")
+			_, err := w.Write(b.Bytes())
+			if err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+func (s *Stream) serveWebSocket(w http.ResponseWriter, r *http.Request, events <-chan []byte) {
+	conn, err := s.Upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		//Upgrade has already answered the request
+		return
+	}
+	defer conn.Close()
+
+	//anything the browser sends is ignored, reading notices when it goes away
+	gone := make(chan struct{})
+	go func() {
+		defer close(gone)
+		for {
+			_, _, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	for {
+		select {
+		case msg, ok := <-events:
+			if !ok {
+				conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+				return
+			}
+			err := conn.WriteMessage(websocket.TextMessage, msg)
+			if err != nil {
+				return
+			}
+		case <-gone:
+			return
+		}
+	}
+}
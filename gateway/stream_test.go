@@ -0,0 +1,195 @@
+package gateway_test
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	zest "github.com/me-box/goZestClient"
+	"github.com/me-box/goZestClient/gateway"
+	"github.com/me-box/goZestClient/zesttest"
+)
+
+//newStream returns a client of s and a Stream over it on a test HTTP server
+func newStream(t *testing.T, s *zesttest.Server) (*zest.ZestClient, *httptest.Server) {
+	z, err := zest.New("", "", "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	z.SetTransport(s)
+	srv := httptest.NewServer(gateway.NewStream(z))
+	t.Cleanup(srv.Close)
+	return z, srv
+}
+
+//observes counts the Observe requests s received
+func observes(s *zesttest.Server) int {
+	n := 0
+	for _, req := range s.Requests() {
+		if _, ok := req.Option(6); ok {
+			n++
+		}
+	}
+	return n
+}
+
+//waitObservers waits for s to have n subscriptions open
+func waitObservers(t *testing.T, s *zesttest.Server, n int) {
+	deadline := time.Now().Add(5 * time.Second)
+	for s.Observers() != n {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d observers open, want %d", s.Observers(), n)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+//sseViewer opens an event stream and returns its lines
+func sseViewer(t *testing.T, url string) (*http.Response, <-chan string) {
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/event-stream" {
+		resp.Body.Close()
+		t.Fatalf("got %d %s", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+	lines := make(chan string, 16)
+	go func() {
+		defer close(lines)
+		sc := bufio.NewScanner(resp.Body)
+		for sc.Scan() {
+			lines <- sc.Text()
+		}
+	}()
+	return resp, lines
+}
+
+//nextData returns the first data line of the next event
+func nextData(t *testing.T, lines <-chan string) string {
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case line, ok := <-lines:
+			if !ok {
+				t.Fatal("the stream ended")
+			}
+			if strings.HasPrefix(line, "data: ") {
+				return line[len("data: "):]
+			}
+		case <-timeout:
+			t.Fatal("no event was streamed")
+		}
+	}
+}
+
+func TestSSEViewersShareOneObserve(t *testing.T) {
+	s := zesttest.NewServer()
+	z, srv := newStream(t, s)
+
+	url := srv.URL + "/kv/test/greeting?format=TEXT"
+	first, firstLines := sseViewer(t, url)
+	second, secondLines := sseViewer(t, url)
+	if n := observes(s); n != 1 {
+		t.Fatalf("%d upstream Observes for two viewers, want 1", n)
+	}
+	waitObservers(t, s, 1)
+
+	if _, err := z.Post("", "/kv/test/greeting", []byte("hello"), "TEXT"); err != nil {
+		t.Fatal(err)
+	}
+	for _, lines := range []<-chan string{firstLines, secondLines} {
+		if data := nextData(t, lines); !strings.Contains(data, "hello") {
+			t.Fatalf("streamed %q", data)
+		}
+	}
+
+	//the upstream Observe outlives the first viewer and ends with the last
+	first.Body.Close()
+	time.Sleep(50 * time.Millisecond)
+	if n := s.Observers(); n != 1 {
+		t.Fatalf("%d observers open after one viewer left, want 1", n)
+	}
+	second.Body.Close()
+	waitObservers(t, s, 0)
+
+	//a new viewer starts a new Observe
+	third, _ := sseViewer(t, url)
+	defer third.Body.Close()
+	if n := observes(s); n != 2 {
+		t.Fatalf("%d upstream Observes, want 2", n)
+	}
+}
+
+func TestWebSocketStreams(t *testing.T) {
+	s := zesttest.NewServer()
+	z, srv := newStream(t, s)
+
+	url := "ws" + strings.TrimPrefix(srv.URL, "http") + "/kv/test/greeting?format=TEXT"
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitObservers(t, s, 1)
+
+	if _, err := z.Post("", "/kv/test/greeting", []byte("hello"), "TEXT"); err != nil {
+		t.Fatal(err)
+	}
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	kind, msg, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if kind != websocket.TextMessage || !strings.Contains(string(msg), "hello") {
+		t.Fatalf("got message %d %q", kind, msg)
+	}
+
+	conn.Close()
+	waitObservers(t, s, 0)
+}
+
+func TestStreamRefusesBadRequests(t *testing.T) {
+	s := zesttest.NewServer()
+	_, srv := newStream(t, s)
+
+	cases := []struct {
+		url    string
+		status int
+	}{
+		{"/kv/test/greeting?mode=everything", http.StatusBadRequest},
+		{"/kv/test/greeting?timeout=soon", http.StatusBadRequest},
+		{"/kv/test/greeting?format=XML", http.StatusUnsupportedMediaType},
+		{"/elsewhere", http.StatusNotFound},
+	}
+	for _, c := range cases {
+		resp, _ := do(t, "GET", srv.URL+c.url, "")
+		if resp.StatusCode != c.status {
+			t.Errorf("%s: got %d, want %d", c.url, resp.StatusCode, c.status)
+		}
+	}
+	if n := observes(s); n != 0 {
+		t.Fatalf("%d Observes for refused requests", n)
+	}
+}
+
+func TestStreamPathIsCleaned(t *testing.T) {
+	s := zesttest.NewServer()
+	z, _ := newStream(t, s)
+	stream := gateway.NewStream(z)
+	stream.Prefixes = []string{"/ts/"}
+
+	for _, p := range []string{"/ts/../kv/secret", "/ts/./../kv/secret", "/ts/a/../../kv/secret"} {
+		rec := httptest.NewRecorder()
+		stream.ServeHTTP(rec, httptest.NewRequest("GET", p, nil))
+		if rec.Code != http.StatusNotFound {
+			t.Errorf("GET %s: status %d, want 404", p, rec.Code)
+		}
+	}
+	if n := observes(s); n != 0 {
+		t.Fatalf("%d Observes reached the store", n)
+	}
+}
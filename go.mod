@@ -0,0 +1,23 @@
+module github.com/me-box/goZestClient
+
+go 1.21
+
+require (
+	github.com/chzyer/readline v1.5.1
+	github.com/eclipse/paho.mqtt.golang v1.4.3
+	github.com/fxamacker/cbor/v2 v2.9.4
+	github.com/golang/protobuf v1.5.4
+	github.com/gorilla/websocket v1.5.0
+	github.com/mochi-mqtt/server/v2 v2.6.6
+	github.com/pebbe/zmq4 v1.2.10
+)
+
+require (
+	github.com/rs/xid v1.4.0 // indirect
+	github.com/x448/float16 v0.8.4 // indirect
+	golang.org/x/net v0.23.0 // indirect
+	golang.org/x/sync v0.1.0 // indirect
+	golang.org/x/sys v0.18.0 // indirect
+	google.golang.org/protobuf v1.33.0 // indirect
+	gopkg.in/yaml.v3 v3.0.1 // indirect
+)
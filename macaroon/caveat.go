@@ -0,0 +1,160 @@
+package macaroon
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+//Request is what a token is checked against before it is sent. An empty
+//Target isn't checked and a zero Time means now.
+type Request struct {
+	Target string
+	Path   string
+	Method string
+	Time   time.Time
+}
+
+//CaveatError describes the first party caveat a request doesn't satisfy
+type CaveatError struct {
+	//Caveat is the caveat as written in the token
+	Caveat string
+	Key    string
+	Want   string
+	Got    string
+}
+
+func (e *CaveatError) Error() string {
+	if e.Key == "time" {
+		return "token expired, time caveat < " + e.Want + " is not after " + e.Got
+	}
+	return "token " + e.Key + " caveat " + e.Want + " does not match " + e.Got
+}
+
+//ParseCaveat splits a first party caveat such as "path = /kv/foo/*" or
+//"time < 2018-06-01T00:00:00Z" into its key, operator and value
+func ParseCaveat(id string) (key string, op string, value string, ok bool) {
+	parts := strings.SplitN(id, " ", 3)
+	if len(parts) != 3 {
+		return "", "", "", false
+	}
+	switch parts[1] {
+	case "=", "<", ">":
+		return parts[0], parts[1], strings.TrimSpace(parts[2]), true
+	}
+	return "", "", "", false
+}
+
+//ParseTime reads the value of a time caveat, RFC 3339 or a Unix time in
+//seconds or milliseconds
+func ParseTime(s string) (time.Time, bool) {
+	s = strings.TrimSpace(s)
+	if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+		if n > 1e12 {
+			return time.Unix(0, n*int64(time.Millisecond)), true
+		}
+		return time.Unix(n, 0), true
+	}
+	for _, layout := range []string{time.RFC3339Nano, "2006-01-02T15:04:05", "2006-01-02T15:04"} {
+		t, err := time.Parse(layout, s)
+		if err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+//MatchPath reports whether path satisfies a path caveat, in which * matches
+//any run of characters including /
+func MatchPath(pattern string, path string) bool {
+	parts := strings.Split(pattern, "*")
+	if len(parts) == 1 {
+		return pattern == path
+	}
+	if !strings.HasPrefix(path, parts[0]) {
+		return false
+	}
+	path = path[len(parts[0]):]
+	last := len(parts) - 1
+	for _, part := range parts[1:last] {
+		i := strings.Index(path, part)
+		if i < 0 {
+			return false
+		}
+		path = path[i+len(part):]
+	}
+	return strings.HasSuffix(path, parts[last])
+}
+
+//Expiry returns the earliest time caveat, reporting false if there is none
+func (m *Macaroon) Expiry() (time.Time, bool) {
+	var expiry time.Time
+	found := false
+	for _, c := range m.Caveats {
+		if c.ThirdParty() {
+			continue
+		}
+		key, op, value, ok := ParseCaveat(c.ID)
+		if !ok || key != "time" || op != "<" {
+			continue
+		}
+		t, ok := ParseTime(value)
+		if ok && (!found || t.Before(expiry)) {
+			expiry = t
+			found = true
+		}
+	}
+	return expiry, found
+}
+
+//Check tests the target, path, method and time caveats against r, returning a
+//*CaveatError for the first that fails. Other caveats are left to the store.
+func (m *Macaroon) Check(r Request) error {
+	now := r.Time
+	if now.IsZero() {
+		now = time.Now()
+	}
+	for _, c := range m.Caveats {
+		if c.ThirdParty() {
+			continue
+		}
+		key, op, value, ok := ParseCaveat(c.ID)
+		if !ok {
+			continue
+		}
+		fail := &CaveatError{Caveat: c.ID, Key: key, Want: value}
+		switch {
+		case key == "target" && op == "=":
+			if r.Target != "" && !strings.EqualFold(value, r.Target) {
+				fail.Got = r.Target
+				return fail
+			}
+		case key == "path" && op == "=":
+			if !MatchPath(value, r.Path) {
+				fail.Got = r.Path
+				return fail
+			}
+		case key == "method" && op == "=":
+			if !strings.EqualFold(value, r.Method) {
+				fail.Got = r.Method
+				return fail
+			}
+		case key == "time" && op == "<":
+			t, ok := ParseTime(value)
+			if ok && !now.Before(t) {
+				fail.Got = now.Format(time.RFC3339)
+				return fail
+			}
+		}
+	}
+	return nil
+}
+
+//Check decodes token and checks it against r
+func Check(token string, r Request) error {
+	m, err := Decode(token)
+	if err != nil {
+		return err
+	}
+	return m.Check(r)
+}
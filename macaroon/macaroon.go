@@ -0,0 +1,309 @@
+//Package macaroon decodes the macaroons databox uses as Zest tokens so their
+//caveats can be shown and checked before a request is sent. Signatures are
+//not verified, only the store holding the root key can do that.
+package macaroon
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//Macaroon is a decoded token
+type Macaroon struct {
+	Location   string
+	Identifier string
+	Caveats    []Caveat
+	Signature  []byte
+}
+
+//Caveat is a condition on a macaroon. First party caveats such as
+//"path = /kv/foo/*" are checked by the store, third party ones carry a
+//VerificationID and need a discharge from Location.
+type Caveat struct {
+	ID             string
+	VerificationID []byte
+	Location       string
+}
+
+//ThirdParty reports whether the caveat needs a discharge macaroon
+func (c Caveat) ThirdParty() bool {
+	return len(c.VerificationID) > 0
+}
+
+//Decode reads a serialized macaroon in the version 1 or 2 binary format,
+//base64 encoded with either alphabet and with or without padding, or in JSON
+func Decode(token string) (*Macaroon, error) {
+	token = strings.TrimSpace(token)
+	if strings.HasPrefix(token, "{") {
+		return decodeJSON([]byte(token))
+	}
+
+	var data []byte
+	var err error
+	for _, enc := range []*base64.Encoding{base64.URLEncoding, base64.RawURLEncoding, base64.StdEncoding, base64.RawStdEncoding} {
+		data, err = enc.DecodeString(token)
+		if err == nil {
+			break
+		}
+	}
+	if err != nil {
+		return nil, errors.New("token is not base64 " + err.Error())
+	}
+	if len(data) == 0 {
+		return nil, errors.New("empty token")
+	}
+	if data[0] == 2 {
+		return decodeV2(data)
+	}
+	return decodeV1(data)
+}
+
+//decodeV1 reads packets of a four hex digit length, counting itself, followed by "key value\n"
+func decodeV1(data []byte) (*Macaroon, error) {
+	m := &Macaroon{}
+	for len(data) > 0 {
+		if len(data) < 4 {
+			return nil, errors.New("truncated packet header")
+		}
+		n, err := strconv.ParseUint(string(data[:4]), 16, 16)
+		if err != nil || int(n) < 5 || int(n) > len(data) {
+			return nil, errors.New("bad packet length " + strconv.Quote(string(data[:4])))
+		}
+		packet := bytes.TrimSuffix(data[4:n], []byte("\n"))
+		data = data[n:]
+
+		i := bytes.IndexByte(packet, ' ')
+		if i < 0 {
+			return nil, errors.New("packet without a key " + strconv.Quote(string(packet)))
+		}
+		key, value := string(packet[:i]), packet[i+1:]
+		switch key {
+		case "location":
+			m.Location = string(value)
+		case "identifier":
+			m.Identifier = string(value)
+		case "cid":
+			m.Caveats = append(m.Caveats, Caveat{ID: string(value)})
+		case "vid":
+			if len(m.Caveats) == 0 {
+				return nil, errors.New("vid before any cid")
+			}
+			m.Caveats[len(m.Caveats)-1].VerificationID = append([]byte(nil), value...)
+		case "cl":
+			if len(m.Caveats) == 0 {
+				return nil, errors.New("cl before any cid")
+			}
+			m.Caveats[len(m.Caveats)-1].Location = string(value)
+		case "signature":
+			m.Signature = append([]byte(nil), value...)
+		default:
+			return nil, errors.New("unknown packet " + key)
+		}
+	}
+	if m.Identifier == "" {
+		return nil, errors.New("macaroon has no identifier")
+	}
+	return m, nil
+}
+
+//field types of the version 2 binary format
+const (
+	fieldEOS            = 0
+	fieldLocation       = 1
+	fieldIdentifier     = 2
+	fieldVerificationID = 4
+	fieldSignature      = 6
+)
+
+//decodeV2 reads the version byte, a header section, caveat sections ended by
+//an empty one, and the signature. Sections are varint type and length fields
+//ended by a zero type.
+func decodeV2(data []byte) (*Macaroon, error) {
+	r := &v2Reader{data: data[1:]}
+	m := &Macaroon{}
+
+	fields, err := r.section()
+	if err != nil {
+		return nil, err
+	}
+	m.Location = string(fields[fieldLocation])
+	m.Identifier = string(fields[fieldIdentifier])
+	if m.Identifier == "" {
+		return nil, errors.New("macaroon has no identifier")
+	}
+
+	for {
+		if len(r.data) == 0 {
+			return nil, errors.New("truncated caveats")
+		}
+		if r.data[0] == fieldEOS {
+			r.data = r.data[1:]
+			break
+		}
+		fields, err := r.section()
+		if err != nil {
+			return nil, err
+		}
+		m.Caveats = append(m.Caveats, Caveat{
+			ID:             string(fields[fieldIdentifier]),
+			VerificationID: fields[fieldVerificationID],
+			Location:       string(fields[fieldLocation]),
+		})
+	}
+
+	t, value, err := r.field()
+	if err != nil {
+		return nil, err
+	}
+	if t != fieldSignature {
+		return nil, errors.New("expected signature, got field " + strconv.Itoa(int(t)))
+	}
+	m.Signature = value
+	return m, nil
+}
+
+type v2Reader struct {
+	data []byte
+}
+
+func (r *v2Reader) varint() (uint64, error) {
+	v, n := binary.Uvarint(r.data)
+	if n <= 0 {
+		return 0, errors.New("bad varint")
+	}
+	r.data = r.data[n:]
+	return v, nil
+}
+
+func (r *v2Reader) field() (uint64, []byte, error) {
+	t, err := r.varint()
+	if err != nil {
+		return 0, nil, err
+	}
+	if t == fieldEOS {
+		return t, nil, nil
+	}
+	n, err := r.varint()
+	if err != nil {
+		return 0, nil, err
+	}
+	if n > uint64(len(r.data)) {
+		return 0, nil, errors.New("truncated field " + strconv.FormatUint(t, 10))
+	}
+	value := append([]byte(nil), r.data[:n]...)
+	r.data = r.data[n:]
+	return t, value, nil
+}
+
+func (r *v2Reader) section() (map[uint64][]byte, error) {
+	fields := map[uint64][]byte{}
+	for {
+		t, value, err := r.field()
+		if err != nil {
+			return nil, err
+		}
+		if t == fieldEOS {
+			return fields, nil
+		}
+		fields[t] = value
+	}
+}
+
+//jsonMacaroon covers the version 1 and version 2 JSON formats
+type jsonMacaroon struct {
+	Location   string `json:"location"`
+	Identifier string `json:"identifier"`
+	Signature  string `json:"signature"`
+	Caveats    []struct {
+		CID string `json:"cid"`
+		VID string `json:"vid"`
+		CL  string `json:"cl"`
+	} `json:"caveats"`
+
+	V   int    `json:"v"`
+	L   string `json:"l"`
+	I   string `json:"i"`
+	I64 string `json:"i64"`
+	S64 string `json:"s64"`
+	C   []struct {
+		I   string `json:"i"`
+		I64 string `json:"i64"`
+		V64 string `json:"v64"`
+		L   string `json:"l"`
+	} `json:"c"`
+}
+
+func decodeJSON(data []byte) (*Macaroon, error) {
+	var j jsonMacaroon
+	err := json.Unmarshal(data, &j)
+	if err != nil {
+		return nil, errors.New("bad JSON macaroon " + err.Error())
+	}
+
+	m := &Macaroon{}
+	//version 2 uses short field names and may leave out v
+	if j.V == 2 || (j.Identifier == "" && (j.I != "" || j.I64 != "")) {
+		m.Location = j.L
+		m.Identifier = j.I
+		if j.I64 != "" {
+			m.Identifier = string(decode64(j.I64))
+		}
+		m.Signature = decode64(j.S64)
+		for _, c := range j.C {
+			id := c.I
+			if c.I64 != "" {
+				id = string(decode64(c.I64))
+			}
+			m.Caveats = append(m.Caveats, Caveat{ID: id, VerificationID: decode64(c.V64), Location: c.L})
+		}
+	} else {
+		m.Location = j.Location
+		m.Identifier = j.Identifier
+		m.Signature, _ = hex.DecodeString(j.Signature)
+		for _, c := range j.Caveats {
+			m.Caveats = append(m.Caveats, Caveat{ID: c.CID, VerificationID: decode64(c.VID), Location: c.CL})
+		}
+	}
+	if m.Identifier == "" {
+		return nil, errors.New("macaroon has no identifier")
+	}
+	return m, nil
+}
+
+//decode64 decodes base64 in any of its variants, nil if it isn't base64
+func decode64(s string) []byte {
+	for _, enc := range []*base64.Encoding{base64.RawURLEncoding, base64.URLEncoding, base64.RawStdEncoding, base64.StdEncoding} {
+		b, err := enc.DecodeString(s)
+		if err == nil {
+			return b
+		}
+	}
+	return nil
+}
+
+//String lays the macaroon out one field per line
+func (m *Macaroon) String() string {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "location    %s\n", m.Location)
+	fmt.Fprintf(&b, "identifier  %s\n", m.Identifier)
+	for _, c := range m.Caveats {
+		if c.ThirdParty() {
+			fmt.Fprintf(&b, "caveat      %s (third party at %s)\n", c.ID, c.Location)
+		} else {
+			fmt.Fprintf(&b, "caveat      %s\n", c.ID)
+		}
+	}
+	if exp, ok := m.Expiry(); ok {
+		fmt.Fprintf(&b, "expires     %s\n", exp.Format(time.RFC3339))
+	}
+	fmt.Fprintf(&b, "signature   %x\n", m.Signature)
+	return b.String()
+}
@@ -0,0 +1,250 @@
+package macaroon
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"testing"
+	"time"
+)
+
+//encodeV1 serializes m in the version 1 binary format
+func encodeV1(m *Macaroon) string {
+	var b bytes.Buffer
+	packet := func(key string, value []byte) {
+		fmt.Fprintf(&b, "%04x%s %s\n", 4+len(key)+1+len(value)+1, key, value)
+	}
+	packet("location", []byte(m.Location))
+	packet("identifier", []byte(m.Identifier))
+	for _, c := range m.Caveats {
+		packet("cid", []byte(c.ID))
+		if c.ThirdParty() {
+			packet("vid", c.VerificationID)
+			packet("cl", []byte(c.Location))
+		}
+	}
+	packet("signature", m.Signature)
+	return base64.URLEncoding.EncodeToString(b.Bytes())
+}
+
+//encodeV2 serializes m in the version 2 binary format
+func encodeV2(m *Macaroon) string {
+	b := []byte{2}
+	field := func(t uint64, value []byte) {
+		b = binary.AppendUvarint(b, t)
+		b = binary.AppendUvarint(b, uint64(len(value)))
+		b = append(b, value...)
+	}
+	if m.Location != "" {
+		field(fieldLocation, []byte(m.Location))
+	}
+	field(fieldIdentifier, []byte(m.Identifier))
+	b = append(b, fieldEOS)
+	for _, c := range m.Caveats {
+		if c.Location != "" {
+			field(fieldLocation, []byte(c.Location))
+		}
+		field(fieldIdentifier, []byte(c.ID))
+		if c.ThirdParty() {
+			field(fieldVerificationID, c.VerificationID)
+		}
+		b = append(b, fieldEOS)
+	}
+	b = append(b, fieldEOS)
+	field(fieldSignature, m.Signature)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+var example = &Macaroon{
+	Location:   "arbiter",
+	Identifier: "id-1",
+	Caveats: []Caveat{
+		{ID: "target = store"},
+		{ID: "path = /kv/foo/*"},
+		{ID: "method = GET"},
+		{ID: "time < 2018-06-01T00:00:00Z"},
+		{ID: "discharge-id", VerificationID: []byte{1, 2, 3}, Location: "auth"},
+	},
+	Signature: []byte{0xde, 0xad, 0xbe, 0xef},
+}
+
+func sameMacaroon(t *testing.T, got *Macaroon, want *Macaroon) {
+	t.Helper()
+	if got.Location != want.Location || got.Identifier != want.Identifier || !bytes.Equal(got.Signature, want.Signature) {
+		t.Fatalf("got %+v, want %+v", got, want)
+	}
+	if len(got.Caveats) != len(want.Caveats) {
+		t.Fatalf("got %d caveats, want %d", len(got.Caveats), len(want.Caveats))
+	}
+	for i, c := range want.Caveats {
+		g := got.Caveats[i]
+		if g.ID != c.ID || !bytes.Equal(g.VerificationID, c.VerificationID) || g.Location != c.Location {
+			t.Fatalf("caveat %d: got %+v, want %+v", i, g, c)
+		}
+	}
+}
+
+func TestDecodeBinary(t *testing.T) {
+	tokens := map[string]string{
+		"v1":             encodeV1(example),
+		"v1 std":         base64.StdEncoding.EncodeToString(decode64(encodeV1(example))),
+		"v2":             encodeV2(example),
+		"v2 padded":      base64.URLEncoding.EncodeToString(decode64(encodeV2(example))),
+		"v2 with spaces": " " + encodeV2(example) + "\n",
+	}
+	for name, token := range tokens {
+		m, err := Decode(token)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		sameMacaroon(t, m, example)
+	}
+}
+
+func TestDecodeJSON(t *testing.T) {
+	v1 := `{"location":"arbiter","identifier":"id-1","signature":"deadbeef","caveats":[` +
+		`{"cid":"target = store"},{"cid":"path = /kv/foo/*"},{"cid":"method = GET"},` +
+		`{"cid":"time < 2018-06-01T00:00:00Z"},{"cid":"discharge-id","vid":"AQID","cl":"auth"}]}`
+	v2 := `{"v":2,"l":"arbiter","i64":"aWQtMQ","s64":"3q2-7w","c":[` +
+		`{"i":"target = store"},{"i":"path = /kv/foo/*"},{"i":"method = GET"},` +
+		`{"i":"time < 2018-06-01T00:00:00Z"},{"i64":"ZGlzY2hhcmdlLWlk","v64":"AQID","l":"auth"}]}`
+	for name, token := range map[string]string{"v1": v1, "v2": v2} {
+		m, err := Decode(token)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		sameMacaroon(t, m, example)
+	}
+}
+
+func TestDecodeRejects(t *testing.T) {
+	noID := *example
+	noID.Identifier = ""
+	v1 := decode64(encodeV1(example))
+	tokens := map[string]string{
+		"empty":          "",
+		"not base64":     "not a token!",
+		"truncated v1":   base64.URLEncoding.EncodeToString(v1[:len(v1)-3]),
+		"truncated v2":   encodeV2(example)[:20],
+		"no identifier":  encodeV2(&noID),
+		"bad JSON":       `{"v":2,`,
+		"JSON without i": `{"v":2,"l":"arbiter"}`,
+	}
+	for name, token := range tokens {
+		if _, err := Decode(token); err == nil {
+			t.Errorf("%s: decoded", name)
+		}
+	}
+}
+
+func TestCheck(t *testing.T) {
+	m := &Macaroon{Identifier: "id", Caveats: []Caveat{
+		{ID: "target = store"},
+		{ID: "path = /kv/foo/*"},
+		{ID: "method = GET"},
+		{ID: "time < 2018-06-01T00:00:00Z"},
+		//left to the store
+		{ID: "observe = data"},
+		{ID: "discharge-id", VerificationID: []byte{1}, Location: "auth"},
+	}}
+	before := time.Date(2018, 5, 1, 0, 0, 0, 0, time.UTC)
+	after := time.Date(2018, 7, 1, 0, 0, 0, 0, time.UTC)
+	ok := Request{Target: "store", Path: "/kv/foo/bar", Method: "GET", Time: before}
+
+	cases := []struct {
+		name string
+		edit func(r *Request)
+		err  string
+	}{
+		{"matching", func(r *Request) {}, ""},
+		{"wildcard spans segments", func(r *Request) { r.Path = "/kv/foo/bar/baz" }, ""},
+		{"target case", func(r *Request) { r.Target = "STORE" }, ""},
+		{"method case", func(r *Request) { r.Method = "get" }, ""},
+		{"target unchecked", func(r *Request) { r.Target = "" }, ""},
+		{"target", func(r *Request) { r.Target = "other" }, "token target caveat store does not match other"},
+		{"path", func(r *Request) { r.Path = "/kv/bar/foo" }, "token path caveat /kv/foo/* does not match /kv/bar/foo"},
+		{"path without the wildcard", func(r *Request) { r.Path = "/kv/foo" }, "token path caveat /kv/foo/* does not match /kv/foo"},
+		{"method", func(r *Request) { r.Method = "POST" }, "token method caveat GET does not match POST"},
+		{"time", func(r *Request) { r.Time = after }, "token expired, time caveat < 2018-06-01T00:00:00Z is not after 2018-07-01T00:00:00Z"},
+	}
+	for _, c := range cases {
+		r := ok
+		c.edit(&r)
+		err := m.Check(r)
+		if c.err == "" {
+			if err != nil {
+				t.Errorf("%s: %v", c.name, err)
+			}
+			continue
+		}
+		ce, isCaveat := err.(*CaveatError)
+		if !isCaveat {
+			t.Errorf("%s: got %v, want a *CaveatError", c.name, err)
+			continue
+		}
+		if ce.Error() != c.err {
+			t.Errorf("%s: got %q, want %q", c.name, ce.Error(), c.err)
+		}
+	}
+}
+
+func TestCheckToken(t *testing.T) {
+	token := encodeV2(example)
+	if err := Check(token, Request{Target: "store", Path: "/kv/foo/x", Method: "GET", Time: time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)}); err != nil {
+		t.Fatal(err)
+	}
+	if err := Check(token, Request{Path: "/kv/foo/x", Method: "GET"}); err == nil {
+		t.Fatal("an expired token passed")
+	}
+	if err := Check("not a token!", Request{}); err == nil {
+		t.Fatal("an undecodable token passed")
+	}
+}
+
+func TestMatchPath(t *testing.T) {
+	cases := []struct {
+		pattern string
+		path    string
+		match   bool
+	}{
+		{"/kv/foo", "/kv/foo", true},
+		{"/kv/foo", "/kv/foo/bar", false},
+		{"/kv/foo/*", "/kv/foo/", true},
+		{"/kv/foo/*", "/kv/foo/a/b", true},
+		{"/kv/foo/*", "/kv/foobar", false},
+		{"/ts/*/latest", "/ts/temp/latest", true},
+		{"/ts/*/latest", "/ts/temp/earliest", false},
+		{"/*/*/x", "/kv/a/b/x", true},
+	}
+	for _, c := range cases {
+		if MatchPath(c.pattern, c.path) != c.match {
+			t.Errorf("MatchPath(%q, %q) should be %v", c.pattern, c.path, c.match)
+		}
+	}
+}
+
+func TestTimes(t *testing.T) {
+	want := time.Date(2018, 6, 1, 0, 0, 0, 0, time.UTC)
+	for _, s := range []string{"2018-06-01T00:00:00Z", "1527811200", "1527811200000", "2018-06-01T00:00"} {
+		got, ok := ParseTime(s)
+		if !ok || !got.Equal(want) {
+			t.Errorf("ParseTime(%q) = %v %v", s, got, ok)
+		}
+	}
+	if _, ok := ParseTime("next week"); ok {
+		t.Error("parsed next week")
+	}
+
+	m := &Macaroon{Identifier: "id", Caveats: []Caveat{
+		{ID: "time < 2019-01-01T00:00:00Z"},
+		{ID: "time < 2018-06-01T00:00:00Z"},
+		{ID: "time > 2010-01-01T00:00:00Z"},
+	}}
+	if exp, ok := m.Expiry(); !ok || !exp.Equal(want) {
+		t.Fatalf("Expiry got %v %v, want the earliest", exp, ok)
+	}
+	if _, ok := (&Macaroon{Identifier: "id"}).Expiry(); ok {
+		t.Fatal("a macaroon without a time caveat has an expiry")
+	}
+}
@@ -0,0 +1,214 @@
+//Package mqttbridge copies messages between MQTT topics and the time series
+//and key value paths of a Zest store, for devices that only speak MQTT.
+package mqttbridge
+
+import (
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	zest "github.com/me-box/goZestClient"
+)
+
+//Direction says which way a Route copies messages
+type Direction int
+
+const (
+	//ToZest posts messages published on MQTT topics to Zest paths
+	ToZest Direction = iota
+	//ToMQTT publishes the data events observed on Zest paths to MQTT topics
+	ToMQTT
+)
+
+//Route maps MQTT topics to Zest paths. Topic and Path are templates in which
+//{name} matches text within one level and a final {name...} matches the rest, e.g. Topic "home/{room}/temperature" with Path
+//"/ts/temperature-{room}". Every name in the destination must appear in the source.
+type Route struct {
+	Topic     string
+	Path      string
+	Direction Direction
+	//QoS is the MQTT quality of service for the subscription or publications
+	QoS byte
+	//Retain marks messages published to MQTT as retained
+	Retain bool
+	//Format is the Zest content format, JSON if empty
+	Format string
+}
+
+//Bridge runs a set of routes between an MQTT client and a Zest client. The
+//MQTT client should be created with SetAutoAckDisabled(true) so that
+//messages are only acknowledged once they have been posted to the store. The
+//broker only sends an unacknowledged message again when the client reconnects
+//to the same session, so a message that fails to post is lost unless the
+//route's QoS is 1 or 2 and the client has SetCleanSession(false).
+type Bridge struct {
+	Zest  *zest.ZestClient
+	MQTT  mqtt.Client
+	Token string
+	//OnError is called for messages that could not be copied, by default they are logged
+	OnError func(error)
+
+	routes []route
+	mu     sync.Mutex
+	stops  []chan struct{}
+	wg     sync.WaitGroup
+}
+
+type route struct {
+	Route
+	topic template
+	path  template
+}
+
+//New checks the routes and returns a Bridge for them, Start begins copying
+func New(z *zest.ZestClient, m mqtt.Client, token string, routes ...Route) (*Bridge, error) {
+	b := &Bridge{Zest: z, MQTT: m, Token: token}
+	for _, r := range routes {
+		topic, err := parseTemplate(r.Topic)
+		if err != nil {
+			return nil, err
+		}
+		path, err := parseTemplate(r.Path)
+		if err != nil {
+			return nil, err
+		}
+		if r.QoS > 2 {
+			return nil, errors.New("QoS must be 0, 1 or 2")
+		}
+		if r.Format == "" {
+			r.Format = "JSON"
+		}
+		if _, ok := zest.LookupContentFormat(r.Format); !ok {
+			return nil, errors.New("Unsupported content format " + r.Format)
+		}
+
+		from, to := topic, path
+		if r.Direction == ToMQTT {
+			from, to = path, topic
+		}
+		known := map[string]bool{}
+		for _, name := range from.vars {
+			known[name] = true
+		}
+		for _, name := range to.vars {
+			if !known[name] {
+				return nil, errors.New("{" + name + "} in " + to.raw + " is not in " + from.raw)
+			}
+		}
+
+		b.routes = append(b.routes, route{Route: r, topic: topic, path: path})
+	}
+	return b, nil
+}
+
+//Start subscribes to the MQTT topics and observes the Zest paths of every route
+func (b *Bridge) Start() error {
+	for _, r := range b.routes {
+		var err error
+		if r.Direction == ToZest {
+			err = b.subscribe(r)
+		} else {
+			err = b.observe(r)
+		}
+		if err != nil {
+			b.Stop()
+			return err
+		}
+	}
+	return nil
+}
+
+//Stop unsubscribes from MQTT and ends the Zest observations, waiting for
+//messages already being copied
+func (b *Bridge) Stop() {
+	var filters []string
+	for _, r := range b.routes {
+		if r.Direction == ToZest {
+			filters = append(filters, r.topic.mqttFilter())
+		}
+	}
+	if len(filters) > 0 && b.MQTT.IsConnected() {
+		b.MQTT.Unsubscribe(filters...).WaitTimeout(time.Second * 10)
+	}
+
+	b.mu.Lock()
+	for _, stop := range b.stops {
+		close(stop)
+	}
+	b.stops = nil
+	b.mu.Unlock()
+
+	b.wg.Wait()
+}
+
+func (b *Bridge) subscribe(r route) error {
+	token := b.MQTT.Subscribe(r.topic.mqttFilter(), r.QoS, func(_ mqtt.Client, msg mqtt.Message) {
+		vars, ok := r.topic.match(msg.Topic())
+		if !ok {
+			//another route's subscription overlaps this one
+			msg.Ack()
+			return
+		}
+		path, err := r.path.fill(vars)
+		if err == nil {
+			_, err = b.Zest.Post(b.Token, path, msg.Payload(), r.Format)
+		}
+		if err != nil {
+			//left unacknowledged, the broker sends it again when a session kept
+			//with CleanSession false is resumed, otherwise it is lost
+			b.error(errors.New("posting " + msg.Topic() + " to " + path + ": " + err.Error()))
+			return
+		}
+		msg.Ack()
+	})
+	token.Wait()
+	return token.Error()
+}
+
+func (b *Bridge) observe(r route) error {
+	dataChan, doneChan, err := b.Zest.Observe(b.Token, r.path.observePath(), r.Format, zest.ObserveModeData, 0)
+	if err != nil {
+		return err
+	}
+
+	b.mu.Lock()
+	b.stops = append(b.stops, doneChan)
+	b.mu.Unlock()
+
+	b.wg.Add(1)
+	go func() {
+		defer b.wg.Done()
+		for msg := range dataChan {
+			event, err := zest.ParseDataEvent(msg)
+			if err != nil {
+				b.error(errors.New("observing " + r.Path + ": " + err.Error()))
+				continue
+			}
+			vars, ok := r.path.match(event.Path)
+			if !ok {
+				continue
+			}
+			topic, err := r.topic.fill(vars)
+			if err != nil {
+				b.error(err)
+				continue
+			}
+			token := b.MQTT.Publish(topic, r.QoS, r.Retain, event.Payload)
+			token.Wait()
+			if token.Error() != nil {
+				b.error(errors.New("publishing " + event.Path + " to " + topic + ": " + token.Error().Error()))
+			}
+		}
+	}()
+	return nil
+}
+
+func (b *Bridge) error(err error) {
+	if b.OnError != nil {
+		b.OnError(err)
+		return
+	}
+	log.Println("mqttbridge: " + err.Error())
+}
@@ -0,0 +1,194 @@
+package mqttbridge_test
+
+import (
+	"io"
+	"log/slog"
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	zest "github.com/me-box/goZestClient"
+	"github.com/me-box/goZestClient/mqttbridge"
+	"github.com/me-box/goZestClient/zesttest"
+	broker "github.com/mochi-mqtt/server/v2"
+	"github.com/mochi-mqtt/server/v2/hooks/auth"
+	"github.com/mochi-mqtt/server/v2/listeners"
+)
+
+//startBroker runs an embedded MQTT broker on a free local port
+func startBroker(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := broker.New(&broker.Options{Logger: slog.New(slog.NewTextHandler(io.Discard, nil))})
+	if err := b.AddHook(new(auth.AllowHook), nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.AddListener(listeners.NewNet("test", l)); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Serve(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { b.Close() })
+	return "tcp://" + l.Addr().String()
+}
+
+var clients int
+
+func connect(t *testing.T, addr string) mqtt.Client {
+	clients++
+	opts := mqtt.NewClientOptions().
+		AddBroker(addr).
+		SetClientID("test-" + strconv.Itoa(clients)).
+		SetAutoAckDisabled(true)
+	c := mqtt.NewClient(opts)
+	if token := c.Connect(); !token.WaitTimeout(5*time.Second) || token.Error() != nil {
+		t.Fatalf("connecting to %s: %v", addr, token.Error())
+	}
+	t.Cleanup(func() { c.Disconnect(100) })
+	return c
+}
+
+func newZest(t *testing.T, s *zesttest.Server) *zest.ZestClient {
+	z, err := zest.New("", "", "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	z.SetTransport(s)
+	return z
+}
+
+//errorLog collects the errors a bridge reports
+type errorLog struct {
+	mu   sync.Mutex
+	errs []error
+}
+
+func (l *errorLog) add(err error) {
+	l.mu.Lock()
+	l.errs = append(l.errs, err)
+	l.mu.Unlock()
+}
+
+func (l *errorLog) count() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return len(l.errs)
+}
+
+func startBridge(t *testing.T, z *zest.ZestClient, m mqtt.Client, routes ...mqttbridge.Route) *errorLog {
+	b, err := mqttbridge.New(z, m, "", routes...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	errs := &errorLog{}
+	b.OnError = errs.add
+	if err := b.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(b.Stop)
+	return errs
+}
+
+//waitFor polls cond for up to five seconds
+func waitFor(t *testing.T, what string, cond func() bool) {
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for " + what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func publish(t *testing.T, c mqtt.Client, topic string, payload string) {
+	token := c.Publish(topic, 1, false, payload)
+	if !token.WaitTimeout(5*time.Second) || token.Error() != nil {
+		t.Fatalf("publishing to %s: %v", topic, token.Error())
+	}
+}
+
+func TestMQTTToZest(t *testing.T) {
+	addr := startBroker(t)
+	s := zesttest.NewServer()
+	startBridge(t, newZest(t, s), connect(t, addr), mqttbridge.Route{
+		Topic: "home/{room}/temperature",
+		Path:  "/ts/temperature-{room}",
+		QoS:   1,
+	})
+
+	device := connect(t, addr)
+	publish(t, device, "home/kitchen/temperature", "21")
+	publish(t, device, "home/hall/temperature", "18")
+
+	waitFor(t, "both points", func() bool {
+		return len(s.Points("/ts/temperature-kitchen")) == 1 && len(s.Points("/ts/temperature-hall")) == 1
+	})
+	if p := s.Points("/ts/temperature-kitchen")[0]; string(p.Data) != "21" {
+		t.Fatalf("stored %q", p.Data)
+	}
+}
+
+func TestZestToMQTT(t *testing.T) {
+	addr := startBroker(t)
+	s := zesttest.NewServer()
+	z := newZest(t, s)
+	startBridge(t, z, connect(t, addr), mqttbridge.Route{
+		Topic:     "out/{name...}",
+		Path:      "/kv/app/{name...}",
+		Direction: mqttbridge.ToMQTT,
+		QoS:       1,
+	})
+
+	got := make(chan mqtt.Message, 10)
+	listener := connect(t, addr)
+	token := listener.Subscribe("out/#", 1, func(_ mqtt.Client, msg mqtt.Message) {
+		msg.Ack()
+		got <- msg
+	})
+	if !token.WaitTimeout(5*time.Second) || token.Error() != nil {
+		t.Fatal(token.Error())
+	}
+
+	if _, err := z.Post("", "/kv/app/lights/hall", []byte(`{"on":true}`), "JSON"); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case msg := <-got:
+		if msg.Topic() != "out/lights/hall" || string(msg.Payload()) != `{"on":true}` {
+			t.Fatalf("got %s %q", msg.Topic(), msg.Payload())
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("nothing was published")
+	}
+}
+
+func TestDotSegmentsAreNotPosted(t *testing.T) {
+	addr := startBroker(t)
+	s := zesttest.NewServer()
+	errs := startBridge(t, newZest(t, s), connect(t, addr), mqttbridge.Route{
+		Topic: "dev/{name...}",
+		Path:  "/kv/app/{name...}",
+		QoS:   1,
+	})
+
+	device := connect(t, addr)
+	publish(t, device, "dev/../other", "1")
+	publish(t, device, "dev/ok", "2")
+
+	waitFor(t, "the good message", func() bool {
+		_, ok := s.Value("/kv/app/ok")
+		return ok
+	})
+	waitFor(t, "the refused message", func() bool { return errs.count() == 1 })
+	for _, req := range s.Requests() {
+		if path, _ := req.Option(11); string(path) != "/kv/app/ok" {
+			t.Fatalf("sent a request for %s", path)
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package mqttbridge
+
+import (
+	"bytes"
+	"errors"
+	"regexp"
+	"strings"
+)
+
+var variablePattern = regexp.MustCompile(`\{([A-Za-z0-9_]*)(\.\.\.)?\}`)
+
+//template is a topic or path with named parts. {name} matches within one
+//level, e.g. sensor-{id}, and {name...} at the end matches every remaining level.
+type template struct {
+	raw      string
+	segments []string
+	vars     []string
+	re       *regexp.Regexp
+}
+
+func parseTemplate(s string) (template, error) {
+	t := template{raw: s, segments: strings.Split(s, "/")}
+
+	var pattern bytes.Buffer
+	pattern.WriteString("^")
+	last := 0
+	for _, m := range variablePattern.FindAllStringSubmatchIndex(s, -1) {
+		name := s[m[2]:m[3]]
+		rest := m[4] >= 0
+		if name == "" {
+			return template{}, errors.New("empty variable name in " + s)
+		}
+		if rest && m[1] != len(s) {
+			return template{}, errors.New("{" + name + "...} must come last in " + s)
+		}
+		literal := s[last:m[0]]
+		if strings.ContainsAny(literal, "{}+#*") {
+			return template{}, errors.New("bad template " + s + ", use {name} or {name...}")
+		}
+		pattern.WriteString(regexp.QuoteMeta(literal))
+		if rest {
+			pattern.WriteString("(.+)")
+		} else {
+			pattern.WriteString("([^/]+)")
+		}
+		t.vars = append(t.vars, name)
+		last = m[1]
+	}
+	if strings.ContainsAny(s[last:], "{}+#*") {
+		return template{}, errors.New("bad template " + s + ", use {name} or {name...}")
+	}
+	pattern.WriteString(regexp.QuoteMeta(s[last:]))
+	pattern.WriteString("$")
+
+	re, err := regexp.Compile(pattern.String())
+	if err != nil {
+		return template{}, err
+	}
+	t.re = re
+	return t, nil
+}
+
+//match extracts the variables of s, reporting false if s doesn't fit
+func (t template) match(s string) (map[string]string, bool) {
+	m := t.re.FindStringSubmatch(s)
+	if m == nil {
+		return nil, false
+	}
+	vars := map[string]string{}
+	for i, name := range t.vars {
+		if v, ok := vars[name]; ok && v != m[i+1] {
+			//a name used twice has to match the same text both times
+			return nil, false
+		}
+		vars[name] = m[i+1]
+	}
+	return vars, true
+}
+
+//fill substitutes vars into the template. Values with . or .. levels are
+//refused so a topic like dev/../cat can't reach a path outside the template,
+//as are values with a ? which would start a query on a Zest path.
+func (t template) fill(vars map[string]string) (string, error) {
+	var err error
+	out := variablePattern.ReplaceAllStringFunc(t.raw, func(v string) string {
+		name := variablePattern.FindStringSubmatch(v)[1]
+		value, ok := vars[name]
+		if !ok {
+			err = errors.New("no value for {" + name + "} in " + t.raw)
+		} else if hasDotSegment(value) {
+			err = errors.New("{" + name + "} can't contain . or .. levels, got " + value)
+		} else if strings.Contains(value, "?") {
+			err = errors.New("{" + name + "} can't contain ?, got " + value)
+		}
+		return value
+	})
+	if err != nil {
+		return "", err
+	}
+	return out, nil
+}
+
+func hasDotSegment(value string) bool {
+	for _, seg := range strings.Split(value, "/") {
+		if seg == "." || seg == ".." {
+			return true
+		}
+	}
+	return false
+}
+
+//mqttFilter is the MQTT subscription covering every topic the template matches
+func (t template) mqttFilter() string {
+	out := make([]string, len(t.segments))
+	for i, seg := range t.segments {
+		switch {
+		case strings.Contains(seg, "...}"):
+			out[i] = "#"
+		case strings.Contains(seg, "{"):
+			out[i] = "+"
+		default:
+			out[i] = seg
+		}
+	}
+	return strings.Join(out, "/")
+}
+
+//observePath is the Zest path to observe for every path the template matches,
+//the levels before the first variable followed by the store's * wildcard
+func (t template) observePath() string {
+	for i, seg := range t.segments {
+		if strings.Contains(seg, "{") {
+			return strings.Join(t.segments[:i], "/") + "/*"
+		}
+	}
+	return t.raw
+}
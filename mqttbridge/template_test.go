@@ -0,0 +1,77 @@
+package mqttbridge
+
+import "testing"
+
+func TestTemplateMatchAndFill(t *testing.T) {
+	topic, err := parseTemplate("home/{room}/{sensor...}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path, err := parseTemplate("/ts/{room}-{sensor...}")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	vars, ok := topic.match("home/kitchen/temperature/celsius")
+	if !ok {
+		t.Fatal("topic should match")
+	}
+	out, err := path.fill(vars)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out != "/ts/kitchen-temperature/celsius" {
+		t.Fatalf("filled %s", out)
+	}
+	if f := topic.mqttFilter(); f != "home/+/#" {
+		t.Fatalf("filter %s", f)
+	}
+	if p := path.observePath(); p != "/ts/*" {
+		t.Fatalf("observe path %s", p)
+	}
+}
+
+func TestTemplateRefusesDotSegments(t *testing.T) {
+	topic, _ := parseTemplate("dev/{room}/{name...}")
+	path, _ := parseTemplate("/kv/app/{room}/{name...}")
+
+	for _, s := range []string{"dev/../x", "dev/./x", "dev/a/..", "dev/a/b/../../..", "dev/a/./b"} {
+		vars, ok := topic.match(s)
+		if !ok {
+			t.Errorf("%s should match", s)
+			continue
+		}
+		if out, err := path.fill(vars); err == nil {
+			t.Errorf("%s filled as %s", s, out)
+		}
+	}
+
+	vars, _ := topic.match("dev/a/b..c/.d")
+	if out, err := path.fill(vars); err != nil || out != "/kv/app/a/b..c/.d" {
+		t.Fatalf("dots within a level should be allowed, got %s %v", out, err)
+	}
+}
+
+func TestTemplateRefusesQueries(t *testing.T) {
+	topic, _ := parseTemplate("dev/{room}/{name...}")
+	path, _ := parseTemplate("/kv/app/{room}/{name...}")
+
+	for _, s := range []string{"dev/a?limit=1/b", "dev/a/b?x", "dev/a/b/?"} {
+		vars, ok := topic.match(s)
+		if !ok {
+			t.Errorf("%s should match", s)
+			continue
+		}
+		if out, err := path.fill(vars); err == nil {
+			t.Errorf("%s filled as %s", s, out)
+		}
+	}
+}
+
+func TestParseTemplateErrors(t *testing.T) {
+	for _, s := range []string{"a/{}", "a/{rest...}/b", "a/+/b", "a/#", "/kv/*"} {
+		if _, err := parseTemplate(s); err == nil {
+			t.Errorf("%s should be refused", s)
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package proxy_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	zest "github.com/me-box/goZestClient"
+	"github.com/me-box/goZestClient/proxy"
+	"github.com/me-box/goZestClient/zesttest"
+	zmq "github.com/pebbe/zmq4"
+)
+
+//serveInproc runs a proxy for routes on inproc sockets and returns a client
+//connected to it the way an app would be
+func serveInproc(t *testing.T, routes ...proxy.Route) *zest.ZestClient {
+	_, secret, err := zmq.NewCurveKeypair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := proxy.New(secret, routes...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	endpoint := fmt.Sprintf("inproc://proxy-test-%p", p)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	go func() {
+		served <- p.ListenAndServe(ctx, endpoint, endpoint+"-router")
+	}()
+
+	app, err := zest.New(endpoint, endpoint+"-router", p.PublicKey(), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		app.Close()
+		cancel()
+		if err := <-served; err != nil {
+			t.Error(err)
+		}
+	})
+	return app
+}
+
+func storeClient(t *testing.T, s *zesttest.Server) *zest.ZestClient {
+	z, err := zest.New("", "", "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	z.SetTransport(s)
+	return z
+}
+
+func TestInprocRouting(t *testing.T) {
+	a, b := zesttest.NewServer(), zesttest.NewServer()
+	app := serveInproc(t,
+		proxy.Route{Prefix: "/kv/app1", Client: storeClient(t, a)},
+		proxy.Route{Prefix: "/kv/app2", Client: storeClient(t, b)},
+	)
+
+	if _, err := app.Post("", "/kv/app1/key", []byte("one"), "TEXT"); err != nil {
+		t.Fatal(err)
+	}
+	if v, ok := a.Value("/kv/app1/key"); !ok || string(v) != "one" {
+		t.Fatalf("store a has %q", v)
+	}
+	b.Set("/kv/app2/key", []byte("two"), "TEXT")
+	v, err := app.Get("", "/kv/app2/key", "TEXT")
+	if err != nil || string(v) != "two" {
+		t.Fatalf("got %q %v", v, err)
+	}
+	if _, err := app.Get("", "/kv/app3/key", "TEXT"); err == nil {
+		t.Fatal("an unrouted path was answered")
+	}
+}
+
+func TestInprocRelay(t *testing.T) {
+	s := zesttest.NewServer()
+	app := serveInproc(t, proxy.Route{Prefix: "/", Client: storeClient(t, s)})
+
+	events, done, err := app.Observe("", "/kv/app1/key", "TEXT", zest.ObserveModeData, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer close(done)
+	future, err := app.Notify("", "/kv/app1/key", "TEXT", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer future.Close()
+
+	if _, err := app.Post("", "/kv/app1/key", []byte("one"), "TEXT"); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case msg := <-events:
+		event, err := zest.ParseDataEvent(msg)
+		if err != nil || string(event.Payload) != "one" {
+			t.Fatalf("observed %q %v", msg, err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("nothing was relayed to the Observe")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	msg, err := future.Wait(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if event, err := zest.ParseDataEvent(msg); err != nil || string(event.Payload) != "one" {
+		t.Fatalf("notified %q %v", msg, err)
+	}
+}
@@ -0,0 +1,524 @@
+//Package proxy is a single Zest endpoint for apps in front of several stores.
+package proxy
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	zest "github.com/me-box/goZestClient"
+	zmq "github.com/pebbe/zmq4"
+)
+
+//defaultWorkers is how many requests a Proxy forwards at once by default
+const defaultWorkers = 16
+
+//authDomain is the ZAP domain the proxy's CURVE server sockets use
+const authDomain = "zest-proxy"
+
+//pollInterval is how often sockets and subscriptions check whether to stop
+const pollInterval = time.Millisecond * 100
+
+//connectTimeout is how long an app has to connect its dealer for a relayed Observe or Notify
+const connectTimeout = time.Second * 10
+
+//Route sends requests for paths under Prefix to the store Client talks to
+type Route struct {
+	Prefix string
+	Client *zest.ZestClient
+}
+
+//Proxy is a single Zest endpoint for apps in front of several stores. It
+//terminates CURVE from apps, parses each request and forwards it to the store
+//whose route prefix is the longest match for its Uri-Path, authenticating to
+//that store with the route client's own keys. Observe and Notify responses are
+//rewritten so the app subscribes to the proxy's router, which relays the
+//store's messages to it. A prefix matches whole path segments, /kv/app1
+//matches /kv/app1 and /kv/app1/key but not /kv/app10.
+//
+//Tokens are passed through unchanged, so the stores still decide what each app
+//may do. An observation is relayed until its Max-Age runs out, the proxy stops,
+//or a message can't be delivered because the app has gone away.
+type Proxy struct {
+	//ClientKeys are the public keys of the apps allowed to connect, any key when empty
+	ClientKeys []string
+	//Workers is how many requests are forwarded at once, 16 if 0
+	Workers int
+	//Logger receives a line for every proxied request, the standard logger if nil
+	Logger *log.Logger
+
+	routes    []Route
+	secretKey string
+	publicKey string
+
+	pushes chan delivery
+	relays sync.WaitGroup
+}
+
+//delivery is a frame for the router to send to the app subscribed as identity
+type delivery struct {
+	identity string
+	frame    []byte
+	result   chan error
+}
+
+//relayed is an Observe or Notify being relayed from a store to an app
+type relayed struct {
+	route    Route
+	path     string
+	notify   bool
+	identity string
+	sub      zest.Subscription
+	started  time.Time
+	maxAge   time.Duration
+}
+
+//New returns a Proxy serving with the CURVE secretKey, apps use its public
+//half, PublicKey, as their server key
+func New(secretKey string, routes ...Route) (*Proxy, error) {
+	publicKey, err := zmq.AuthCurvePublic(secretKey)
+	if err != nil {
+		return nil, errors.New("bad proxy secret key " + err.Error())
+	}
+	p := &Proxy{secretKey: secretKey, publicKey: publicKey}
+	for _, r := range routes {
+		if !strings.HasPrefix(r.Prefix, "/") {
+			return nil, errors.New("route prefix " + r.Prefix + " should start with /")
+		}
+		if r.Client == nil {
+			return nil, errors.New("route " + r.Prefix + " has no client")
+		}
+		p.routes = append(p.routes, r)
+	}
+	//longest prefix first so the most specific route wins
+	sort.SliceStable(p.routes, func(i, j int) bool {
+		return len(p.routes[i].Prefix) > len(p.routes[j].Prefix)
+	})
+	return p, nil
+}
+
+//PublicKey is the server key apps should use to connect to the proxy
+func (p *Proxy) PublicKey() string {
+	return p.publicKey
+}
+
+//route returns the route for path, reporting false if none matches
+func (p *Proxy) route(path string) (Route, bool) {
+	for _, r := range p.routes {
+		prefix := strings.TrimSuffix(r.Prefix, "/")
+		if prefix == "" || path == prefix || strings.HasPrefix(path, prefix+"/") {
+			return r, true
+		}
+	}
+	return Route{}, false
+}
+
+//ListenAndServe accepts requests on endpoint and serves Observe and Notify
+//subscriptions on dealerEndpoint until ctx is done
+func (p *Proxy) ListenAndServe(ctx context.Context, endpoint string, dealerEndpoint string) error {
+	err := zmq.AuthStart()
+	if err != nil {
+		return errors.New("AuthStart " + err.Error())
+	}
+	defer zmq.AuthStop()
+	if len(p.ClientKeys) == 0 {
+		zmq.AuthCurveAdd(authDomain, zmq.CURVE_ALLOW_ANY)
+	} else {
+		zmq.AuthCurveAdd(authDomain, p.ClientKeys...)
+	}
+
+	front, err := p.serverSocket(zmq.ROUTER, endpoint)
+	if err != nil {
+		return err
+	}
+	defer front.Close()
+
+	router, err := p.serverSocket(zmq.ROUTER, dealerEndpoint)
+	if err != nil {
+		return err
+	}
+	defer router.Close()
+	//report apps that have gone rather than silently dropping their messages
+	err = router.SetRouterMandatory(1)
+	if err != nil {
+		return errors.New("SetRouterMandatory " + err.Error())
+	}
+
+	//requests are handed to the workers over inproc, bound before they connect
+	back, err := zmq.NewSocket(zmq.DEALER)
+	if err != nil {
+		return err
+	}
+	defer back.Close()
+	back.SetLinger(0)
+	workerEndpoint := fmt.Sprintf("inproc://zest-proxy-%p", p)
+	err = back.Bind(workerEndpoint)
+	if err != nil {
+		return errors.New("Bind " + workerEndpoint + " " + err.Error())
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	p.pushes = make(chan delivery)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		p.sendPushes(ctx, router)
+	}()
+
+	workers := p.Workers
+	if workers <= 0 {
+		workers = defaultWorkers
+	}
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := p.work(ctx, workerEndpoint)
+			if err != nil {
+				p.logf("worker stopped: %s", err.Error())
+			}
+		}()
+	}
+
+	err = p.shuttle(ctx, front, back)
+	cancel()
+	p.relays.Wait()
+	wg.Wait()
+	return err
+}
+
+//serverSocket binds a CURVE server socket with the proxy's key
+func (p *Proxy) serverSocket(t zmq.Type, endpoint string) (*zmq.Socket, error) {
+	soc, err := zmq.NewSocket(t)
+	if err != nil {
+		return nil, err
+	}
+	soc.SetLinger(0)
+	err = soc.ServerAuthCurve(authDomain, p.secretKey)
+	if err != nil {
+		soc.Close()
+		return nil, errors.New("ServerAuthCurve " + err.Error())
+	}
+	err = soc.Bind(endpoint)
+	if err != nil {
+		soc.Close()
+		return nil, errors.New("Bind " + endpoint + " " + err.Error())
+	}
+	return soc, nil
+}
+
+//shuttle passes requests from apps to the workers and their replies back,
+//both sockets are only used from here
+func (p *Proxy) shuttle(ctx context.Context, front *zmq.Socket, back *zmq.Socket) error {
+	poller := zmq.NewPoller()
+	poller.Add(front, zmq.POLLIN)
+	poller.Add(back, zmq.POLLIN)
+	for ctx.Err() == nil {
+		polled, err := poller.Poll(pollInterval)
+		if err != nil {
+			return err
+		}
+		for _, item := range polled {
+			from, to := front, back
+			if item.Socket == back {
+				from, to = back, front
+			}
+			msg, err := from.RecvMessageBytes(0)
+			if err != nil {
+				return err
+			}
+			_, err = to.SendMessage(msg)
+			if err != nil {
+				p.logf("dropping message: %s", err.Error())
+			}
+		}
+	}
+	return nil
+}
+
+//work answers requests passed on by shuttle until ctx is done
+func (p *Proxy) work(ctx context.Context, workerEndpoint string) error {
+	soc, err := zmq.NewSocket(zmq.REP)
+	if err != nil {
+		return err
+	}
+	defer soc.Close()
+	soc.SetLinger(0)
+	err = soc.Connect(workerEndpoint)
+	if err != nil {
+		return err
+	}
+
+	poller := zmq.NewPoller()
+	poller.Add(soc, zmq.POLLIN)
+	for ctx.Err() == nil {
+		polled, err := poller.Poll(pollInterval)
+		if err != nil {
+			return err
+		}
+		if len(polled) == 0 {
+			continue
+		}
+		req, err := soc.RecvBytes(0)
+		if err != nil {
+			return err
+		}
+		_, err = soc.SendBytes(p.forward(ctx, req), 0)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//forward sends req to the store its path routes to and returns the reply for the app
+func (p *Proxy) forward(ctx context.Context, req []byte) []byte {
+	start := time.Now()
+
+	zr, err := zest.DecodeFrame(req)
+	if err != nil {
+		p.logf("bad request: %s", err.Error())
+		return reply(128)
+	}
+	path, _ := zr.Option(11)
+	method := zr.CodeName
+
+	r, ok := p.route(string(path))
+	//logged with the query
+	logPath := requestPath(zr)
+	if !ok {
+		p.logf("%s %s -> no route %s", method, logPath, zest.CodeName(132))
+		return reply(132)
+	}
+
+	resp, err := r.Client.Transport().RoundTrip(ctx, req)
+	if err != nil {
+		code := uint8(162)
+		if timedOut(err) {
+			code = 164
+		}
+		p.logf("%s %s -> %s %s %s: %s", method, logPath, r.Client.Endpoint, zest.CodeName(code), time.Since(start), err.Error())
+		return reply(code)
+	}
+
+	rh, err := zest.DecodeFrame(resp)
+	if err != nil {
+		p.logf("%s %s -> %s bad response %s: %s", method, logPath, r.Client.Endpoint, time.Since(start), err.Error())
+		return reply(162)
+	}
+
+	_, hasMaxAge := zr.Option(14)
+	if zr.Code == 1 && hasMaxAge && rh.Code == 69 {
+		resp, err = p.relay(ctx, r, zr, rh)
+		if err != nil {
+			p.logf("%s %s -> %s %s %s: %s", method, logPath, r.Client.Endpoint, zest.CodeName(162), time.Since(start), err.Error())
+			return reply(162)
+		}
+	}
+
+	p.logf("%s %s -> %s %s %s", method, logPath, r.Client.Endpoint, rh.CodeName, time.Since(start))
+	return resp
+}
+
+//timedOut reports whether a store failed to answer in time, rather than failing outright
+func timedOut(err error) bool {
+	var notify *zest.TimeoutError
+	return errors.Is(err, zest.ErrRequestTimeout) || errors.Is(err, context.DeadlineExceeded) || errors.As(err, &notify)
+}
+
+//relay subscribes to the store for an Observe or Notify and returns the
+//response rewritten to point the app at the proxy's router
+func (p *Proxy) relay(ctx context.Context, r Route, req zest.Frame, resp zest.Frame) ([]byte, error) {
+	pathOpt, _ := req.Option(11)
+	path := string(pathOpt)
+	_, observe := req.Option(6)
+
+	//a Notify is routed to its path's NotifyIdentity, an Observe to the
+	//identity the store returned
+	storeIdentity := zest.NotifyIdentity(path)
+	if observe {
+		storeIdentity = string(resp.Payload)
+		if storeIdentity == "" {
+			return nil, errors.New("the store did not return an identity")
+		}
+	}
+
+	serverKey, _ := resp.Option(2048)
+	sub, err := r.Client.Transport().Subscribe(storeIdentity, string(serverKey))
+	if err != nil {
+		return nil, err
+	}
+
+	//apps notifying subscribe with the same NotifyIdentity, observing with
+	//the identity returned in the payload
+	identity := storeIdentity
+	if observe {
+		identity, err = newIdentity()
+		if err != nil {
+			sub.Close()
+			return nil, err
+		}
+		resp.Payload = []byte(identity)
+	}
+
+	replaced := false
+	for i := range resp.Options {
+		if resp.Options[i].Number == 2048 {
+			resp.Options[i].Value = []byte(p.publicKey)
+			replaced = true
+		}
+	}
+	if !replaced {
+		resp.Options = append(resp.Options, zest.FrameOption{Number: 2048, Value: []byte(p.publicKey)})
+	}
+	frame, err := zest.EncodeFrame(resp)
+	if err != nil {
+		sub.Close()
+		return nil, err
+	}
+
+	rel := relayed{route: r, path: path, notify: !observe, identity: identity, sub: sub, started: time.Now()}
+	if maxAge, ok := req.Option(14); ok && len(maxAge) == 4 {
+		rel.maxAge = time.Duration(binary.BigEndian.Uint32(maxAge)) * time.Second
+	}
+	p.relays.Add(1)
+	go p.runRelay(ctx, rel)
+	return frame, nil
+}
+
+//runRelay copies the store's messages for rel to the app until it is finished with
+func (p *Proxy) runRelay(ctx context.Context, rel relayed) {
+	defer p.relays.Done()
+	defer rel.sub.Close()
+
+	kind := "observe"
+	if rel.notify {
+		kind = "notify"
+	}
+	delivered := false
+	count := 0
+	reason := "proxy stopped"
+	defer func() {
+		p.logf("%s %s -> %s relayed %d message(s), %s", kind, rel.path, rel.route.Client.Endpoint, count, reason)
+	}()
+
+	for ctx.Err() == nil {
+		//the store stops sending once Max-Age is up, allow for messages in flight
+		if rel.maxAge > 0 && time.Since(rel.started) > rel.maxAge+time.Second {
+			reason = "max-age expired"
+			return
+		}
+
+		frame, err := rel.sub.Recv()
+		if err == zest.ErrRecvTimeout {
+			continue
+		}
+		if err != nil {
+			reason = "store error " + err.Error()
+			return
+		}
+
+		err = p.push(ctx, rel, frame, delivered)
+		if err != nil {
+			if ctx.Err() == nil {
+				reason = "app gone " + err.Error()
+			}
+			return
+		}
+		delivered = true
+		count++
+		if rel.notify {
+			reason = "notified"
+			return
+		}
+	}
+}
+
+//push sends frame to the app. Until something has been delivered the app may
+//still be connecting its dealer, so unknown identities are retried for a while.
+func (p *Proxy) push(ctx context.Context, rel relayed, frame []byte, delivered bool) error {
+	for {
+		result := make(chan error, 1)
+		select {
+		case p.pushes <- delivery{identity: rel.identity, frame: frame, result: result}:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		err := <-result
+		if err == nil {
+			return nil
+		}
+		if delivered || zmq.AsErrno(err) != zmq.Errno(syscall.EHOSTUNREACH) || time.Since(rel.started) > connectTimeout {
+			return err
+		}
+
+		select {
+		case <-time.After(pollInterval):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+//sendPushes owns the router socket, sending the frames relays push
+func (p *Proxy) sendPushes(ctx context.Context, router *zmq.Socket) {
+	for {
+		select {
+		case m := <-p.pushes:
+			_, err := router.SendMessage(m.identity, m.frame)
+			m.result <- err
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (p *Proxy) logf(format string, args ...interface{}) {
+	if p.Logger != nil {
+		p.Logger.Printf(format, args...)
+		return
+	}
+	log.Printf("proxy: "+format, args...)
+}
+
+//reply is an error response the proxy answers with itself
+func reply(code uint8) []byte {
+	frame, _ := zest.EncodeFrame(zest.Frame{Code: code})
+	return frame
+}
+
+//requestPath is the Uri-Path of a request followed by its Uri-Query options
+func requestPath(f zest.Frame) string {
+	path, _ := f.Option(11)
+	s := string(path)
+	sep := "?"
+	for _, o := range f.Options {
+		if o.Number == 15 {
+			s += sep + string(o.Value)
+			sep = "&"
+		}
+	}
+	return s
+}
+
+//newIdentity returns a random identity for an app's subscription
+func newIdentity() (string, error) {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,263 @@
+package proxy
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io/ioutil"
+	"log"
+	"strings"
+	"testing"
+	"time"
+
+	zest "github.com/me-box/goZestClient"
+	"github.com/me-box/goZestClient/zesttest"
+)
+
+//store returns a client of s, named endpoint in the proxy's log
+func store(t *testing.T, endpoint string, transport zest.Transport) *zest.ZestClient {
+	z, err := zest.New(endpoint, "", "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	z.SetTransport(transport)
+	return z
+}
+
+func newTestProxy(t *testing.T, routes ...Route) *Proxy {
+	p, err := New("secret", routes...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.Logger = log.New(ioutil.Discard, "", 0)
+	p.pushes = make(chan delivery)
+	return p
+}
+
+//request is a request frame for path, options are number, value pairs
+func request(t *testing.T, code uint8, path string, payload string, options ...zest.FrameOption) []byte {
+	f := zest.Frame{Code: code, Payload: []byte(payload)}
+	f.Options = append(f.Options, zest.FrameOption{Number: 11, Value: []byte(path)}, zest.FrameOption{Number: 12, Value: []byte{0, 0}})
+	f.Options = append(f.Options, options...)
+	frame, err := zest.EncodeFrame(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return frame
+}
+
+func decode(t *testing.T, frame []byte) zest.Frame {
+	f, err := zest.DecodeFrame(frame)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+func maxAge(seconds uint32) zest.FrameOption {
+	v := make([]byte, 4)
+	binary.BigEndian.PutUint32(v, seconds)
+	return zest.FrameOption{Number: 14, Value: v}
+}
+
+func TestRoutePicksLongestWholeSegmentPrefix(t *testing.T) {
+	p := newTestProxy(t,
+		Route{Prefix: "/", Client: &zest.ZestClient{Endpoint: "default"}},
+		Route{Prefix: "/kv/app1", Client: &zest.ZestClient{Endpoint: "app1"}},
+		Route{Prefix: "/kv/app1/private/", Client: &zest.ZestClient{Endpoint: "private"}},
+	)
+	cases := map[string]string{
+		"/kv/app1":             "app1",
+		"/kv/app1/key":         "app1",
+		"/kv/app10/key":        "default",
+		"/kv/app1/private/key": "private",
+		"/ts/sensors":          "default",
+	}
+	for path, want := range cases {
+		r, ok := p.route(path)
+		if !ok || r.Client.Endpoint != want {
+			t.Errorf("%s routed to %s, want %s", path, r.Client.Endpoint, want)
+		}
+	}
+
+	p = newTestProxy(t, Route{Prefix: "/kv/app1", Client: &zest.ZestClient{}})
+	if _, ok := p.route("/ts/sensors"); ok {
+		t.Error("a path outside every route was routed")
+	}
+}
+
+func TestNewChecksRoutes(t *testing.T) {
+	if _, err := New("secret", Route{Prefix: "kv", Client: &zest.ZestClient{}}); err == nil {
+		t.Error("a prefix without a leading / was accepted")
+	}
+	if _, err := New("secret", Route{Prefix: "/kv"}); err == nil {
+		t.Error("a route without a client was accepted")
+	}
+}
+
+func TestForwardRoutesToStores(t *testing.T) {
+	a, b := zesttest.NewServer(), zesttest.NewServer()
+	p := newTestProxy(t,
+		Route{Prefix: "/kv/app1", Client: store(t, "tcp://a:5555", a)},
+		Route{Prefix: "/kv/app2", Client: store(t, "tcp://b:5555", b)},
+	)
+	ctx := context.Background()
+
+	resp := decode(t, p.forward(ctx, request(t, 2, "/kv/app1/key", "one")))
+	if resp.Code != 65 {
+		t.Fatalf("POST got %s", resp.CodeName)
+	}
+	if v, ok := a.Value("/kv/app1/key"); !ok || string(v) != "one" {
+		t.Fatalf("store a has %q", v)
+	}
+	if _, ok := b.Value("/kv/app1/key"); ok {
+		t.Fatal("the write went to both stores")
+	}
+
+	b.Set("/kv/app2/key", []byte("two"), "TEXT")
+	resp = decode(t, p.forward(ctx, request(t, 1, "/kv/app2/key", "")))
+	if resp.Code != 69 || string(resp.Payload) != "two" {
+		t.Fatalf("GET got %s %q", resp.CodeName, resp.Payload)
+	}
+
+	if resp := decode(t, p.forward(ctx, request(t, 1, "/kv/app3/key", ""))); resp.Code != 132 {
+		t.Fatalf("an unrouted path got %s, want 4.04", resp.CodeName)
+	}
+	if resp := decode(t, p.forward(ctx, []byte{1})); resp.Code != 128 {
+		t.Fatalf("a bad frame got %s, want 4.00", resp.CodeName)
+	}
+}
+
+//failingTransport fails every request with err
+type failingTransport struct {
+	*zesttest.Server
+	err error
+}
+
+func (f failingTransport) RoundTrip(ctx context.Context, req []byte) ([]byte, error) {
+	return nil, f.err
+}
+
+func TestForwardStoreErrors(t *testing.T) {
+	cases := []struct {
+		err  error
+		code uint8
+	}{
+		{zest.ErrRequestTimeout, 164},
+		{context.DeadlineExceeded, 164},
+		{errors.New("connection refused"), 162},
+		//only the typed errors count as timeouts
+		{errors.New("timeout in the store's own words"), 162},
+	}
+	for _, c := range cases {
+		p := newTestProxy(t, Route{Prefix: "/", Client: store(t, "tcp://a:5555", failingTransport{zesttest.NewServer(), c.err})})
+		resp := decode(t, p.forward(context.Background(), request(t, 1, "/kv/app1/key", "")))
+		if resp.Code != c.code {
+			t.Errorf("%v: got %s, want %s", c.err, resp.CodeName, zest.CodeName(c.code))
+		}
+	}
+}
+
+//next waits for the relay to push a frame and reports err back to it
+func next(t *testing.T, p *Proxy, err error) delivery {
+	select {
+	case d := <-p.pushes:
+		d.result <- err
+		return d
+	case <-time.After(5 * time.Second):
+		t.Fatal("nothing was relayed")
+	}
+	return delivery{}
+}
+
+func TestRelayObserve(t *testing.T) {
+	s := zesttest.NewServer()
+	p := newTestProxy(t, Route{Prefix: "/", Client: store(t, "tcp://a:5555", s)})
+	p.publicKey = "proxy-public-key"
+	ctx, cancel := context.WithCancel(context.Background())
+
+	req := request(t, 1, "/kv/app1/key", "", zest.FrameOption{Number: 6, Value: []byte("data")}, maxAge(0))
+	resp := decode(t, p.forward(ctx, req))
+	if resp.Code != 69 {
+		t.Fatalf("Observe got %s", resp.CodeName)
+	}
+	identity := string(resp.Payload)
+	if len(identity) != 32 || strings.HasPrefix(identity, "observe-") {
+		t.Fatalf("the app was given identity %q instead of one of the proxy's", identity)
+	}
+	if key, _ := resp.Option(2048); string(key) != "proxy-public-key" {
+		t.Fatalf("the app was given server key %q", key)
+	}
+
+	for _, value := range []string{"one", "two"} {
+		if resp := decode(t, p.forward(ctx, request(t, 2, "/kv/app1/key", value))); resp.Code != 65 {
+			t.Fatalf("POST got %s", resp.CodeName)
+		}
+		d := next(t, p, nil)
+		if d.identity != identity {
+			t.Fatalf("relayed to %q, want %q", d.identity, identity)
+		}
+		event, err := zest.ParseDataEvent(decode(t, d.frame).Payload)
+		if err != nil || string(event.Payload) != value {
+			t.Fatalf("relayed %q %v", d.frame, err)
+		}
+	}
+
+	cancel()
+	p.relays.Wait()
+	if n := s.Observers(); n != 0 {
+		t.Fatalf("%d subscriptions to the store left open", n)
+	}
+}
+
+func TestRelayNotify(t *testing.T) {
+	s := zesttest.NewServer()
+	p := newTestProxy(t, Route{Prefix: "/", Client: store(t, "tcp://a:5555", s)})
+	ctx := context.Background()
+
+	resp := decode(t, p.forward(ctx, request(t, 1, "/kv/app1/reply", "", maxAge(10))))
+	if resp.Code != 69 {
+		t.Fatalf("Notify got %s", resp.CodeName)
+	}
+	decode(t, p.forward(ctx, request(t, 2, "/kv/app1/reply", "done")))
+	if d := next(t, p, nil); d.identity != "/kv/app1/reply" {
+		t.Fatalf("relayed to %q, want the path", d.identity)
+	}
+	//a Notify ends with its first message
+	p.relays.Wait()
+}
+
+func TestRelayEndsWhenAppIsGone(t *testing.T) {
+	s := zesttest.NewServer()
+	p := newTestProxy(t, Route{Prefix: "/", Client: store(t, "tcp://a:5555", s)})
+	ctx := context.Background()
+
+	req := request(t, 1, "/kv/app1/key", "", zest.FrameOption{Number: 6, Value: []byte("data")}, maxAge(0))
+	decode(t, p.forward(ctx, req))
+	decode(t, p.forward(ctx, request(t, 2, "/kv/app1/key", "one")))
+	next(t, p, nil)
+	decode(t, p.forward(ctx, request(t, 2, "/kv/app1/key", "two")))
+	next(t, p, errors.New("host unreachable"))
+	p.relays.Wait()
+}
+
+func TestRelayNotifyLongPath(t *testing.T) {
+	s := zesttest.NewServer()
+	p := newTestProxy(t, Route{Prefix: "/", Client: store(t, "tcp://a:5555", s)})
+	ctx := context.Background()
+
+	path := "/kv/" + strings.Repeat("a", 300)
+	if resp := decode(t, p.forward(ctx, request(t, 1, path, "", maxAge(10)))); resp.Code != 69 {
+		t.Fatalf("Notify got %s", resp.CodeName)
+	}
+	decode(t, p.forward(ctx, request(t, 2, path, "done")))
+	d := next(t, p, nil)
+	if d.identity != zest.NotifyIdentity(path) || len(d.identity) > 255 {
+		t.Fatalf("relayed to %q, want the path's NotifyIdentity", d.identity)
+	}
+	event, err := zest.ParseDataEvent(decode(t, d.frame).Payload)
+	if err != nil || string(event.Payload) != "done" {
+		t.Fatalf("relayed %q %v", d.frame, err)
+	}
+	p.relays.Wait()
+}
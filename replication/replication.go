@@ -0,0 +1,303 @@
+//Package replication mirrors a datasource of one Zest store into another,
+//for example from a primary databox store to a backup.
+package replication
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	zest "github.com/me-box/goZestClient"
+)
+
+//Position is how far a time series has been replicated: every value up to
+//Timestamp, of which Count had exactly that timestamp
+type Position = zest.Position
+
+//Checkpoint persists the replication position so a restarted replicator
+//carries on where it stopped
+type Checkpoint interface {
+	Load() (Position, error)
+	Save(Position) error
+}
+
+//FileCheckpoint keeps the position as JSON in a file, a missing file is the start
+type FileCheckpoint string
+
+func (f FileCheckpoint) Load() (Position, error) {
+	var p Position
+	data, err := ioutil.ReadFile(string(f))
+	if os.IsNotExist(err) {
+		return p, nil
+	}
+	if err != nil {
+		return p, err
+	}
+	err = json.Unmarshal(data, &p)
+	return p, err
+}
+
+//Save writes the position to a temporary file and renames it into place so a
+//crash never leaves a half written checkpoint
+func (f FileCheckpoint) Save(p Position) error {
+	data, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(string(f)), filepath.Base(string(f))+".tmp")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), string(f))
+}
+
+//memoryCheckpoint is used when no Checkpoint is set, it doesn't survive a restart
+type memoryCheckpoint struct {
+	p Position
+}
+
+func (m *memoryCheckpoint) Load() (Position, error) { return m.p, nil }
+func (m *memoryCheckpoint) Save(p Position) error  { m.p = p; return nil }
+
+//Replicator copies the datasource at Path in Source to DestPath in Dest.
+//
+//Time series (/ts/ paths) are copied with range queries from the checkpointed
+//position, so nothing is skipped or written twice across restarts. Data
+//observations of the source, and a poll every Interval in case one is missed,
+//trigger a copy of whatever is new. Values written with a timestamp older
+//than the position, e.g. with /at/ in the past, are not picked up.
+//
+//Key value datasources (/kv/ paths) are copied in full at start up, after
+//which each observed write is copied as it happens. Deletes are not replicated.
+type Replicator struct {
+	Source      *zest.ZestClient
+	SourceToken string
+	Dest        *zest.ZestClient
+	DestToken   string
+	Path        string
+	//DestPath defaults to Path
+	DestPath string
+	//Format is the content format of key value data, JSON if empty
+	Format string
+	//Checkpoint defaults to one kept in memory
+	Checkpoint Checkpoint
+	//Window is how many milliseconds of a time series to read per range query, 0 for an hour
+	Window int64
+	//Interval is how often to check for new data without an observation, 0 for a minute
+	Interval time.Duration
+	//OnError is told about failures the replicator recovers from, by default they are logged
+	OnError func(error)
+	//OnCopy is called after each value is copied, for progress reporting
+	OnCopy func(zest.Record)
+}
+
+//Run replicates until ctx is done or a copy fails
+func (r *Replicator) Run(ctx context.Context) error {
+	r.Path = strings.TrimSuffix(r.Path, "/")
+	if r.DestPath == "" {
+		r.DestPath = r.Path
+	}
+	r.DestPath = strings.TrimSuffix(r.DestPath, "/")
+	if r.Format == "" {
+		r.Format = "JSON"
+	}
+	if r.Checkpoint == nil {
+		r.Checkpoint = &memoryCheckpoint{}
+	}
+	if r.Window <= 0 {
+		r.Window = int64(time.Hour / time.Millisecond)
+	}
+	if r.Interval <= 0 {
+		r.Interval = time.Minute
+	}
+
+	kv := strings.HasPrefix(r.Path, "/kv/")
+	if !kv && !strings.HasPrefix(r.Path, "/ts/") {
+		return errors.New("replication needs a /kv/ or /ts/ path")
+	}
+
+	//observe before the first copy so writes made during it aren't missed
+	observePath := r.Path
+	if kv {
+		observePath += "/*"
+	}
+	events, done, err := r.Source.Observe(r.SourceToken, observePath, r.Format, zest.ObserveModeData, 0)
+	if err != nil {
+		return err
+	}
+	defer close(done)
+
+	sync := r.syncTS
+	if kv {
+		sync = r.syncKV
+	}
+	err = sync(ctx)
+	if err != nil {
+		return err
+	}
+
+	ticker := time.NewTicker(r.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+
+		case <-ticker.C:
+			err = sync(ctx)
+
+		case msg, ok := <-events:
+			if !ok {
+				return errors.New("observation of " + observePath + " ended")
+			}
+			if kv {
+				err = r.copyEvent(ctx, msg)
+			} else {
+				drain(events)
+				err = r.syncTS(ctx)
+			}
+		}
+		if err != nil && ctx.Err() == nil {
+			return err
+		}
+	}
+}
+
+//drain discards queued observations, one range query covers them all
+func drain(events <-chan []byte) {
+	for {
+		select {
+		case _, ok := <-events:
+			if !ok {
+				return
+			}
+		default:
+			return
+		}
+	}
+}
+
+//syncTS copies every value after the checkpointed position, a window at a
+//time. The checkpoint is saved once per window rather than per value, so a
+//crash may copy up to one window again but a failed copy or a clean stop
+//doesn't.
+func (r *Replicator) syncTS(ctx context.Context) error {
+	pos, err := r.Checkpoint.Load()
+	if err != nil {
+		return err
+	}
+	if pos == (Position{}) {
+		//nothing copied yet, start at the oldest value rather than walking
+		//empty windows up from 1970
+		first, err := zest.NewTSClient(r.Source, r.SourceToken, r.Path, "JSON").FirstN(1)
+		if err != nil {
+			return err
+		}
+		if len(first) == 0 {
+			return nil
+		}
+		pos.Timestamp = first[0].Timestamp
+	}
+	now := time.Now().UnixNano() / int64(time.Millisecond)
+
+	//read from the position itself as more values may share its timestamp,
+	//skipping the ones already copied
+	from := pos
+	saved := pos
+	for start := pos.Timestamp; start <= now; start += r.Window {
+		end := start + r.Window - 1
+		if end > now {
+			end = now
+		}
+		err = r.Source.ExportTSAfter(ctx, r.SourceToken, r.Path, from, end, 0, func(rec zest.Record) error {
+			err := r.Dest.ImportRecord(ctx, r.DestToken, r.DestPath, rec)
+			if err != nil {
+				return err
+			}
+
+			pos = pos.Next(rec)
+			r.copied(rec)
+			return nil
+		})
+		from = Position{Timestamp: end + 1}
+
+		//keep what was copied even if the window failed part way
+		if pos != saved {
+			if serr := r.Checkpoint.Save(pos); serr != nil && err == nil {
+				err = serr
+			}
+			saved = pos
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//syncKV copies every key, writes are idempotent so there is nothing to checkpoint
+func (r *Replicator) syncKV(ctx context.Context) error {
+	return r.Source.ExportKV(ctx, r.SourceToken, r.Path, r.Format, "", func(rec zest.Record) error {
+		err := r.Dest.ImportRecord(ctx, r.DestToken, r.DestPath, rec)
+		if err != nil {
+			return err
+		}
+		r.copied(rec)
+		return nil
+	})
+}
+
+//copyEvent copies the key value write described by a data observation
+func (r *Replicator) copyEvent(ctx context.Context, msg []byte) error {
+	event, err := zest.ParseDataEvent(msg)
+	if err != nil {
+		r.error(errors.New("bad observation of " + r.Path + ": " + err.Error()))
+		return nil
+	}
+	if !strings.HasPrefix(event.Path, r.Path+"/") {
+		return nil
+	}
+	key := strings.TrimPrefix(event.Path, r.Path+"/")
+	data, err := zest.NewRecordData(event.Payload, r.Format)
+	if err != nil {
+		return err
+	}
+	rec := zest.Record{Key: key, Format: r.Format, Data: data}
+	err = r.Dest.ImportRecord(ctx, r.DestToken, r.DestPath, rec)
+	if err != nil {
+		return err
+	}
+	r.copied(rec)
+	return nil
+}
+
+func (r *Replicator) copied(rec zest.Record) {
+	if r.OnCopy != nil {
+		r.OnCopy(rec)
+	}
+}
+
+func (r *Replicator) error(err error) {
+	if r.OnError != nil {
+		r.OnError(err)
+		return
+	}
+	log.Println("replication: " + err.Error())
+}
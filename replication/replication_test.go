@@ -0,0 +1,170 @@
+package replication_test
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	zest "github.com/me-box/goZestClient"
+	"github.com/me-box/goZestClient/replication"
+	"github.com/me-box/goZestClient/zesttest"
+)
+
+func newClient(t *testing.T, s *zesttest.Server) *zest.ZestClient {
+	z, err := zest.New("", "", "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	z.SetTransport(s)
+	return z
+}
+
+//run replicates until want values are at path in dest, or until the replicator fails
+func run(t *testing.T, r *replication.Replicator, dest *zesttest.Server, path string, want int) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	result := make(chan error, 1)
+	go func() { result <- r.Run(ctx) }()
+
+	deadline := time.After(5 * time.Second)
+	for {
+		select {
+		case err := <-result:
+			return err
+		case <-deadline:
+			t.Fatal("timed out waiting for the replicator")
+		case <-time.After(10 * time.Millisecond):
+		}
+		if len(dest.Points(path)) >= want {
+			cancel()
+			return <-result
+		}
+	}
+}
+
+//rangeQueries counts the range requests s received
+func rangeQueries(s *zesttest.Server) int {
+	n := 0
+	for _, req := range s.Requests() {
+		if path, _ := req.Option(11); strings.Contains(string(path), "/range/") {
+			n++
+		}
+	}
+	return n
+}
+
+func TestFirstSyncStartsAtEarliest(t *testing.T) {
+	src := zesttest.NewServer()
+	now := time.Now().UnixNano() / int64(time.Millisecond)
+	for i := int64(0); i < 3; i++ {
+		src.AddPoint("/ts/temp", now-3000+i*1000, []byte(strconv.FormatInt(i, 10)))
+	}
+	dest := zesttest.NewServer()
+
+	r := &replication.Replicator{
+		Source: newClient(t, src),
+		Dest:   newClient(t, dest),
+		Path:   "/ts/temp",
+	}
+	if err := run(t, r, dest, "/ts/temp", 3); err != nil {
+		t.Fatal(err)
+	}
+	//an hour window from the earliest value covers everything
+	if n := rangeQueries(src); n != 1 {
+		t.Fatalf("sent %d range queries, want 1", n)
+	}
+}
+
+func TestEmptySeriesIsNotWalked(t *testing.T) {
+	src := zesttest.NewServer()
+	r := &replication.Replicator{
+		Source: newClient(t, src),
+		Dest:   newClient(t, zesttest.NewServer()),
+		Path:   "/ts/temp",
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if err := r.Run(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if n := rangeQueries(src); n != 0 {
+		t.Fatalf("sent %d range queries for an empty series", n)
+	}
+}
+
+func TestResumeFromCheckpoint(t *testing.T) {
+	src := zesttest.NewServer()
+	now := time.Now().UnixNano() / int64(time.Millisecond)
+	var want []zesttest.Point
+	for i := 0; i < 12; i++ {
+		//pairs of values share a millisecond
+		ts := now - 10000 + int64(i/2)*1000
+		data := []byte(strconv.Itoa(i))
+		src.AddPoint("/ts/temp", ts, data)
+		want = append(want, zesttest.Point{Timestamp: ts, Data: data})
+	}
+
+	dest := zesttest.NewServer()
+	posts := 0
+	dest.Handle = func(req zest.Frame) (zest.Frame, bool) {
+		if req.Code == 2 {
+			posts++
+			if posts == 6 {
+				return zest.Frame{Code: 163}, true
+			}
+		}
+		return zest.Frame{}, false
+	}
+
+	checkpoint := replication.FileCheckpoint(filepath.Join(t.TempDir(), "checkpoint"))
+	newReplicator := func() *replication.Replicator {
+		return &replication.Replicator{
+			Source:     newClient(t, src),
+			Dest:       newClient(t, dest),
+			Path:       "/ts/temp",
+			DestPath:   "/ts/copy",
+			Checkpoint: checkpoint,
+			//several windows, with same millisecond values split across a failure
+			Window: 3000,
+		}
+	}
+
+	err := run(t, newReplicator(), dest, "/ts/copy", len(want))
+	var re *zest.ResponseError
+	if !errors.As(err, &re) {
+		t.Fatalf("got %v, want the store's failure", err)
+	}
+	pos, err := checkpoint.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if copied := len(dest.Points("/ts/copy")); copied != 5 || pos.Count != 1 {
+		t.Fatalf("after the failure %d values were copied and the checkpoint is %+v", copied, pos)
+	}
+
+	//a restarted replicator carries on from the checkpoint
+	if err := run(t, newReplicator(), dest, "/ts/copy", len(want)); err != nil {
+		t.Fatal(err)
+	}
+	got := dest.Points("/ts/copy")
+	if len(got) != len(want) {
+		t.Fatalf("copied %d values, want %d", len(got), len(want))
+	}
+	seen := map[string]bool{}
+	for _, p := range got {
+		key := strconv.FormatInt(p.Timestamp, 10) + " " + string(p.Data)
+		if seen[key] {
+			t.Fatalf("%s was copied twice", key)
+		}
+		seen[key] = true
+	}
+	for _, p := range want {
+		if !seen[strconv.FormatInt(p.Timestamp, 10)+" "+string(p.Data)] {
+			t.Fatalf("%d %s was not copied", p.Timestamp, p.Data)
+		}
+	}
+}
@@ -1,3 +1,3 @@
 docker build -t toshbrown/gozestclient .
 
-docker run --network host -it toshbrown/gozestclient /test/test.sh
\ No newline at end of file
+docker run --network host -it toshbrown/gozestclient test/test.sh
\ No newline at end of file
@@ -4,83 +4,126 @@ ME='goZestClient'
 
 source test/utils.sh
 
-#CMD="go run ./client/client.go --enable-logging "
-CMD="go run ./client/client.go "
+#connection settings for the example server come from the test profile
+export ZEST_CONFIG=test/zest-config.json
+export ZEST_PROFILE=test
 
-EXPECTED="created"
-RES=$($CMD --mode post --format json --path /kv/test/key --payload "{\"name\":\"tosh\",\"age\":38}")
-test_assert "$EXPECTED" "$RES" "Test KV POST JSON "
+ZEST=$(mktemp -d)/zest
+go build -o $ZEST ./client || exit 1
+
+#CMD="$ZEST --enable-logging "
+CMD="$ZEST"
+
+$CMD post --format json --payload "{\"name\":\"tosh\",\"age\":38}" /kv/test/key
+test_exit 0 $? "Test KV POST JSON "
 
 EXPECTED='{"name":"tosh","age":38}'
-RES=$($CMD --mode get --format json --path /kv/test/key)
+RES=$($CMD get --format json /kv/test/key)
 test_assert "$EXPECTED" "$RES" "Test KV GET JSON "
 
-EXPECTED="created"
-RES=$($CMD --mode post --format text --path /kv/test/key --payload "{\"name\":\"Tosh\",\"age\":37}")
-test_assert "$EXPECTED" "$RES" "Test KV POST TEXT "
+$CMD post --format text --payload "{\"name\":\"Tosh\",\"age\":37}" /kv/test/key
+test_exit 0 $? "Test KV POST TEXT "
 
 EXPECTED='{"name":"Tosh","age":37}'
-RES=$($CMD --mode get --format text --path /kv/test/key)
+RES=$($CMD get --format text /kv/test/key)
 test_assert "$EXPECTED" "$RES" "Test KV GET TEXT "
 
-EXPECTED="created"
-RES=$($CMD --mode post --format binary --path /kv/test/key --payload "{\"name\":\"tosh\",\"age\":36}")
-test_assert "$EXPECTED" "$RES" "Test KV POST BINARY "
+$CMD post --format binary --payload "{\"name\":\"tosh\",\"age\":36}" /kv/test/key
+test_exit 0 $? "Test KV POST BINARY "
+
+BINDIR=$(mktemp -d)
+head -c 1000 /dev/urandom > $BINDIR/in.bin
+$CMD post --format binary --payload @$BINDIR/in.bin /kv/test/binary
+test_exit 0 $? "Test KV POST BINARY FILE "
+
+$CMD get --format binary --out $BINDIR/out.bin /kv/test/binary
+cmp -s $BINDIR/in.bin $BINDIR/out.bin
+test_exit 0 $? "Test KV GET BINARY round trip "
 
-#EXPECTED='{"name":"tosh","age":36}'
-#RES=$($CMD --mode get --format binary --path /kv/test/)
-#test_assert "$EXPECTED" "$RES" "Test KV GET BINARY "
+EXPECTED='{"name":"tosh","age":35}'
+RES=$(echo -n "$EXPECTED" | $CMD post --format json --payload - /kv/test/stdin && $CMD get --format json /kv/test/stdin)
+test_assert "$EXPECTED" "$RES" "Test KV POST from stdin "
 
+EXPECTED=$(echo -n '{"name":"tosh","age":35}' | base64)
+RES=$($CMD get --format json --display base64 /kv/test/stdin)
+test_assert "$EXPECTED" "$RES" "Test KV GET base64 display "
 
-EXPECTED="created"
-RES=$($CMD --mode post --format json --path /ts/blob/test --payload "{\"name\":\"tosh\",\"age\":38}")
-test_assert "$EXPECTED" "$RES" "Test TS POST JSON "
+$CMD post --format json --payload "{\"name\":\"tosh\",\"age\":38}" /ts/blob/test
+test_exit 0 $? "Test TS POST JSON "
 
-EXPECTED="created"
-RES=$($CMD --mode post --format json --path /ts/blob/test --payload "{\"name\":\"tosh\",\"age\":39}")
-test_assert "$EXPECTED" "$RES" "Test TS POST JSON "
+$CMD post --format json --payload "{\"name\":\"tosh\",\"age\":39}" /ts/blob/test
+test_exit 0 $? "Test TS POST JSON "
 
-EXPECTED="created"
-RES=$($CMD --mode post --format json --path /ts/blob/test --payload "{\"name\":\"tosh\",\"age\":40}")
-test_assert "$EXPECTED" "$RES" "Test TS POST JSON "
+$CMD post --format json --payload "{\"name\":\"tosh\",\"age\":40}" /ts/blob/test
+test_exit 0 $? "Test TS POST JSON "
 
 EXPECTED='{"name":"tosh","age":40}'
-RES=$($CMD --mode get --format json --path /ts/blob/test/latest)
+RES=$($CMD get --format json /ts/blob/test/latest)
 test_contains "$EXPECTED" "$RES" "Test TS GET LATEST JSON "
 
 EXPECTED='{"name":"tosh","age":39}'
-RES=$($CMD --mode get --format json --path /ts/blob/test/last/20)
+RES=$($CMD get --format json /ts/blob/test/last/20)
 test_contains "$EXPECTED" "$RES" "Test TS GET LAST 20 JSON "
 
 EXPECTED='{"name":"dave","age":100}'
-RES=$($CMD --mode test --path /ts/blob/testing)
+RES=$($CMD test /ts/blob/testing)
 test_contains "$EXPECTED" "$RES" "Test TS GET LATEST after test JSON "
 
 
-EXPECTED='created'
-RES=$($CMD --mode post --format json --path /kv/testing/tosh --payload "{\"name\":\"tosh\",\"age\":30}")
-test_contains "$EXPECTED" "$RES" "Test KV write"
+$CMD post --format json --payload "{\"name\":\"tosh\",\"age\":30}" /kv/testing/tosh
+test_exit 0 $? "Test KV write"
 
-EXPECTED='created'
-RES=$($CMD --mode post --format json --path /kv/testing/dave --payload "{\"name\":\"dave\",\"age\":30}")
-test_contains "$EXPECTED" "$RES" "Test KV write"
+$CMD post --format json --payload "{\"name\":\"dave\",\"age\":30}" /kv/testing/dave
+test_exit 0 $? "Test KV write"
 
 EXPECTED='{"name":"tosh","age":30}'
-RES=$($CMD --mode get --format json --path /kv/testing/tosh)
+RES=$($CMD get --format json /kv/testing/tosh)
 test_contains "$EXPECTED" "$RES" "Test KV read"
 
-EXPECTED="deleted"
-RES=$($CMD --mode delete --format json --path /kv/testing/tosh)
-test_assert "$EXPECTED" "$RES" "Test KV delete"
+EXPORT=$(mktemp)
+$CMD export --format json --out $EXPORT.csv /kv/testing 2>/dev/null
+test_exit 0 $? "Test KV export"
+
+$CMD import --format json --in $EXPORT.csv /kv/testing-copy 2>/dev/null
+test_exit 0 $? "Test KV import"
+
+EXPECTED='{"name":"dave","age":30}'
+RES=$($CMD get --format json /kv/testing-copy/dave)
+test_contains "$EXPECTED" "$RES" "Test KV import round trip"
+rm -f $EXPORT $EXPORT.csv
+
+$CMD delete --format json /kv/testing/tosh
+test_exit 0 $? "Test KV delete"
 
 EXPECTED=''
-RES=$($CMD --mode get --format json --path /kv/testing/tosh)
-test_contains "$EXPECTED" "$RES" "Test Item is gone"
+RES=$($CMD get --format json /kv/testing/tosh)
+test_assert "$EXPECTED" "$RES" "Test Item is gone"
 
-EXPECTED="deleted"
-RES=$($CMD --mode delete --format json --path /kv/testing)
-test_assert "$EXPECTED" "$RES" "Test KV delete all"
+$CMD delete --format json /kv/testing
+test_exit 0 $? "Test KV delete all"
 
 EXPECTED=''
-RES=$($CMD --mode get --format json --path /kv/testing/dave)
-test_contains "$EXPECTED" "$RES" "Test dave Item is gone"
\ No newline at end of file
+RES=$($CMD get --format json /kv/testing/dave)
+test_assert "$EXPECTED" "$RES" "Test dave Item is gone"
+
+$CMD post --format xml --payload "<a/>" /kv/testing/xml 2>/dev/null
+test_exit 1 $? "Test unsupported format fails"
+
+$CMD get /kv/testing/tosh /kv/testing/dave 2>/dev/null
+test_exit 2 $? "Test bad usage"
+
+$CMD gateway --listen 127.0.0.1:18080 2>/dev/null &
+GATEWAY=$!
+sleep 1
+
+curl -s -o /dev/null -w "%{http_code}" -X POST -H "Content-Type: application/json" -d '{"name":"gateway"}' http://127.0.0.1:18080/kv/gateway/x | grep -q 201
+test_exit 0 $? "Test gateway POST"
+
+EXPECTED='{"name":"gateway"}'
+RES=$(curl -s -H "Accept: application/json" http://127.0.0.1:18080/kv/gateway/x)
+test_contains "$EXPECTED" "$RES" "Test gateway GET"
+
+curl -s -o /dev/null -w "%{http_code}" -X POST -H "Content-Type: application/xml" -d '<a/>' http://127.0.0.1:18080/kv/gateway/x | grep -q 415
+test_exit 0 $? "Test gateway unsupported Content-Type"
+
+kill $GATEWAY
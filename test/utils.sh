@@ -24,6 +24,15 @@ function test_contains {
   fi
 }
 
+function test_exit {
+  if [ "$1" != "$2" ]
+  then
+    fail "$3" "exit status $1" "exit status $2"
+  else
+    success "$3"
+  fi
+}
+
 function fail {
     echo -e "[$(datef) $ME]: ${1} $(red FAILED) \n Expected: ${2} \n GOT: ${3}"
     exit 1
@@ -1,13 +1,15 @@
 package zest
 
 import (
+	"bytes"
+	"context"
 	"encoding/binary"
 	"encoding/hex"
 	"errors"
 	"fmt"
 	"os"
 	"strconv"
-	"strings"
+	"sync"
 	"time"
 
 	zmq "github.com/pebbe/zmq4"
@@ -54,19 +56,50 @@ func pack_32(i uint32) []byte {
 	return b[:]
 }
 
+//requestTimeout bounds how long a request waits for its reply
+const requestTimeout = time.Second * 10
+
+//pollInterval is how often a waiting request checks whether its context is done
+const pollInterval = time.Millisecond * 100
+
+//maxIdleSockets caps how many connected request sockets a client keeps for reuse
+const maxIdleSockets = 8
+
+//ErrClientClosed is returned by requests made after Close
+var ErrClientClosed = errors.New("client closed")
+
+//ZestClient is safe for concurrent use by multiple goroutines. Request sockets
+//are pooled and each one is only used by a single request at a time. Observe
+//and Notify subscriptions are tracked so that Close can tear them down.
+//Endpoint and DealerEndpoint must not be changed once the client is in use.
 type ZestClient struct {
 	serverKey      string
 	Endpoint       string
 	DealerEndpoint string
 	enableLogging  bool
 	hostname       string
+
+	mu        sync.Mutex
+	idle      []*zmq.Socket
+	closed    bool
+	closing   chan struct{}
+	subs      sync.WaitGroup
+	blockSize int
+	transport Transport
+
+	checkTokens bool
+	cache       *ResponseCache
+
+	clientPublic string
+	clientSecret string
 }
 
 //New returns a ZestClient connected to endpoint using serverKey as an identity
-func New(endpoint string, dealerEndpoint string, serverKey string, enableLogging bool) (ZestClient, error) {
+func New(endpoint string, dealerEndpoint string, serverKey string, enableLogging bool) (*ZestClient, error) {
 
-	z := ZestClient{}
+	z := &ZestClient{}
 	z.enableLogging = enableLogging
+	z.closing = make(chan struct{})
 
 	//cache the host name to save 10ms
 	z.hostname, _ = os.Hostname()
@@ -77,7 +110,7 @@ func New(endpoint string, dealerEndpoint string, serverKey string, enableLogging
 	return z, nil
 }
 
-func (z ZestClient) createSocket(t zmq.Type) (*zmq.Socket, error) {
+func (z *ZestClient) createSocket(t zmq.Type) (*zmq.Socket, error) {
 	z.log("Connecting")
 	ZMQsoc, err := zmq.NewSocket(zmq.REQ)
 	if err != nil {
@@ -86,7 +119,7 @@ func (z ZestClient) createSocket(t zmq.Type) (*zmq.Socket, error) {
 	ZMQsoc.SetRcvtimeo(time.Second * 10)
 	ZMQsoc.SetConnectTimeout(time.Second * 10)
 
-	clientPublic, clientSecret, err := zmq.NewCurveKeypair()
+	clientPublic, clientSecret, err := z.clientKeys()
 	if err != nil {
 		return nil, err
 	}
@@ -104,32 +137,157 @@ func (z ZestClient) createSocket(t zmq.Type) (*zmq.Socket, error) {
 	return ZMQsoc, nil
 }
 
-func (z ZestClient) Post(token string, path string, payload []byte, contentFormat string) ([]byte, error) {
+//SetClientKey makes the client authenticate with a fixed curve key pair
+//instead of a new one per socket, for servers that only accept known clients.
+//The public key is derived from secretKey, both are z85 encoded.
+func (z *ZestClient) SetClientKey(secretKey string) error {
+	publicKey, err := zmq.AuthCurvePublic(secretKey)
+	if err != nil {
+		return err
+	}
 
-	z.log("Posting")
+	z.mu.Lock()
+	z.clientPublic = publicKey
+	z.clientSecret = secretKey
+	z.mu.Unlock()
 
-	err := checkContentFormatFormat(contentFormat)
-	if err != nil {
-		return []byte{}, err
+	return nil
+}
+
+//clientKeys returns the fixed client key pair if one is set, otherwise a new one
+func (z *ZestClient) clientKeys() (string, string, error) {
+	z.mu.Lock()
+	public, secret := z.clientPublic, z.clientSecret
+	z.mu.Unlock()
+
+	if secret != "" {
+		return public, secret, nil
 	}
+	return zmq.NewCurveKeypair()
+}
 
-	//post request
+//getSocket hands out an idle request socket, connecting a new one if none are free
+func (z *ZestClient) getSocket() (*zmq.Socket, error) {
+	z.mu.Lock()
+	if z.closed {
+		z.mu.Unlock()
+		return nil, ErrClientClosed
+	}
+	if n := len(z.idle); n > 0 {
+		soc := z.idle[n-1]
+		z.idle = z.idle[:n-1]
+		z.mu.Unlock()
+		return soc, nil
+	}
+	z.mu.Unlock()
+
+	return z.createSocket(zmq.REQ)
+}
+
+//putSocket returns a socket to the pool once it has completed a send/receive
+//cycle. A REQ socket that failed part way through can't send again and must be
+//closed instead.
+func (z *ZestClient) putSocket(soc *zmq.Socket) {
+	z.mu.Lock()
+	if !z.closed && len(z.idle) < maxIdleSockets {
+		z.idle = append(z.idle, soc)
+		soc = nil
+	}
+	z.mu.Unlock()
+
+	if soc != nil {
+		soc.Close()
+	}
+}
+
+//track registers a subscription goroutine with the client, it reports false
+//once the client has been closed
+func (z *ZestClient) track() bool {
+	z.mu.Lock()
+	defer z.mu.Unlock()
+	if z.closed {
+		return false
+	}
+	z.subs.Add(1)
+	return true
+}
+
+//Close releases the pooled sockets and ends every Observe and Notify started by
+//the client, waiting for their sockets to be closed. The client can't be used afterwards.
+func (z *ZestClient) Close() error {
+	z.mu.Lock()
+	if z.closed {
+		z.mu.Unlock()
+		return nil
+	}
+	z.closed = true
+	idle := z.idle
+	z.idle = nil
+	close(z.closing)
+	z.mu.Unlock()
+
+	for _, soc := range idle {
+		soc.Close()
+	}
+	z.subs.Wait()
+
+	return z.Transport().Close()
+}
+
+//newRequest builds a request header with the Uri-Path, Uri-Host and Content-Format
+//options every request carries, and a Uri-Query for each argument of a query on path
+func (z *ZestClient) newRequest(code uint8, token string, path string, contentFormat string) zestHeader {
 	zr := zestHeader{}
-	zr.Code = 2
+	zr.Code = code
 	zr.Token = token
-	zr.Payload = payload
 
-	//post options
+	//options
+	path, query := splitQuery(path)
 	zr.Options = append(zr.Options, zestOptions{Number: 11, Value: path})
 	zr.Options = append(zr.Options, zestOptions{Number: 3, Value: z.hostname})
 	zr.Options = append(zr.Options, zestOptions{Number: 12, Value: string(pack_16(contentFormatToInt(contentFormat)))})
+	for _, arg := range query {
+		zr.Options = append(zr.Options, zestOptions{Number: 15, Value: arg})
+	}
 
-	bytes, marshalErr := zr.Marshal()
+	return zr
+}
+
+func (z *ZestClient) Post(token string, path string, payload []byte, contentFormat string) ([]byte, error) {
+	return z.post(context.Background(), token, path, payload, contentFormat)
+}
+
+func (z *ZestClient) post(ctx context.Context, token string, path string, payload []byte, contentFormat string, extra ...zestOptions) ([]byte, error) {
+
+	z.log("Posting")
+
+	err := checkContentFormatFormat(contentFormat)
+	if err != nil {
+		return []byte{}, err
+	}
+	defer z.invalidate(path)
+
+	blockSize := z.getBlockSize()
+	if blockSize != 0 && len(payload) > blockSize {
+		return z.postBlocks(ctx, token, path, bytes.NewReader(payload), contentFormat, blockSize, 0, extra...)
+	}
+
+	//post request
+	zr := z.newRequest(2, token, path, contentFormat)
+	zr.Options = append(zr.Options, extra...)
+	zr.Payload = payload
+
+	msg, marshalErr := zr.Marshal()
 	if marshalErr != nil {
 		return []byte{}, marshalErr
 	}
 
-	resp, reqErr := z.sendRequestAndAwaitResponse(bytes)
+	resp, reqErr := z.roundTrip(ctx, msg)
+	if respErr, ok := reqErr.(*ResponseError); ok && respErr.Code == 141 && blockSize == 0 {
+		//too large for the server in one go, split it instead
+		z.log("=> Request entity too large, retrying block-wise")
+		return z.postBlocks(ctx, token, path, bytes.NewReader(payload), contentFormat, sizeFromSize1(resp), 0, extra...)
+	}
 	if reqErr != nil {
 		return []byte{}, reqErr
 	}
@@ -137,7 +295,7 @@ func (z ZestClient) Post(token string, path string, payload []byte, contentForma
 	return resp.Payload, nil
 }
 
-func (z ZestClient) Delete(token string, path string, contentFormat string) error {
+func (z *ZestClient) Delete(token string, path string, contentFormat string) error {
 
 	z.log("Deleting")
 
@@ -145,16 +303,10 @@ func (z ZestClient) Delete(token string, path string, contentFormat string) erro
 	if err != nil {
 		return err
 	}
+	defer z.invalidate(path)
 
 	//Delete request
-	zr := zestHeader{}
-	zr.Code = 4
-	zr.Token = token
-
-	//Delete options
-	zr.Options = append(zr.Options, zestOptions{Number: 11, Value: path})
-	zr.Options = append(zr.Options, zestOptions{Number: 3, Value: z.hostname})
-	zr.Options = append(zr.Options, zestOptions{Number: 12, Value: string(pack_16(contentFormatToInt(contentFormat)))})
+	zr := z.newRequest(4, token, path, contentFormat)
 
 	bytes, marshalErr := zr.Marshal()
 	if marshalErr != nil {
@@ -169,7 +321,11 @@ func (z ZestClient) Delete(token string, path string, contentFormat string) erro
 	return nil
 }
 
-func (z ZestClient) Get(token string, path string, contentFormat string) ([]byte, error) {
+func (z *ZestClient) Get(token string, path string, contentFormat string) ([]byte, error) {
+	return z.get(context.Background(), token, path, contentFormat)
+}
+
+func (z *ZestClient) get(ctx context.Context, token string, path string, contentFormat string) ([]byte, error) {
 
 	z.log("Getting")
 
@@ -178,26 +334,40 @@ func (z ZestClient) Get(token string, path string, contentFormat string) ([]byte
 		return nil, err
 	}
 
-	zr := zestHeader{}
-	zr.Code = 1
-	zr.Token = token
-
-	//options
-	zr.Options = append(zr.Options, zestOptions{Number: 11, Value: path})
-	zr.Options = append(zr.Options, zestOptions{Number: 3, Value: z.hostname})
-	zr.Options = append(zr.Options, zestOptions{Number: 12, Value: string(pack_16(contentFormatToInt(contentFormat)))})
-
-	bytes, marshalErr := zr.Marshal()
-	if marshalErr != nil {
-		return bytes, marshalErr
+	cache := z.getCache()
+	if cache == nil {
+		//reassembles the value if the server sends it in blocks
+		var buf bytes.Buffer
+		_, _, err = z.getBlocks(ctx, token, path, &buf, contentFormat, z.getBlockSize(), 0)
+		if err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
 	}
 
-	resp, reqErr := z.sendRequestAndAwaitResponse(bytes)
-	if reqErr != nil {
-		return bytes, reqErr
+	key := newCacheKey(z.Endpoint, path, contentFormat)
+	cached := cache.lookup(key)
+	if cached.found && cached.fresh {
+		z.log("=> Cached")
+		return cached.value, nil
 	}
 
-	return resp.Payload, nil
+	var extra []zestOptions
+	if cached.found && cached.etag != "" {
+		extra = append(extra, zestOptions{Number: optionETag, Value: cached.etag})
+	}
+	var buf bytes.Buffer
+	_, resp, err := z.getBlocks(ctx, token, path, &buf, contentFormat, z.getBlockSize(), 0, extra...)
+	if err != nil {
+		return nil, err
+	}
+	if resp.Code == 67 {
+		z.log("=> Valid")
+		cache.renew(key, cached, resp)
+		return cached.value, nil
+	}
+	cache.store(key, cached, buf.Bytes(), resp)
+	return buf.Bytes(), nil
 }
 
 type ObserveMode string
@@ -206,22 +376,17 @@ const ObserveModeData ObserveMode = "data"
 const ObserveModeAudit ObserveMode = "audit"
 const ObserveModeNotification ObserveMode = "notification"
 
-func (z ZestClient) Observe(token string, path string, contentFormat string, observeMode ObserveMode, timeout uint32) (<-chan []byte, chan struct{}, error) {
+func (z *ZestClient) Observe(token string, path string, contentFormat string, observeMode ObserveMode, timeout uint32) (<-chan []byte, chan struct{}, error) {
 
 	err := checkContentFormatFormat(contentFormat)
 	if err != nil {
 		return nil, nil, err
 	}
 
-	zr := zestHeader{}
-	zr.Code = 1
-	zr.Token = token
+	zr := z.newRequest(1, token, path, contentFormat)
 
-	//options
-	zr.Options = append(zr.Options, zestOptions{Number: 11, Value: path})
-	zr.Options = append(zr.Options, zestOptions{Number: 3, Value: z.hostname})
+	//observe options
 	zr.Options = append(zr.Options, zestOptions{Number: 6, Value: string(observeMode)})
-	zr.Options = append(zr.Options, zestOptions{Number: 12, Value: string(pack_16(contentFormatToInt(contentFormat)))})
 	zr.Options = append(zr.Options, zestOptions{Number: 14, Value: string(pack_32(timeout))})
 	bytes, marshalErr := zr.Marshal()
 	if marshalErr != nil {
@@ -233,7 +398,7 @@ func (z ZestClient) Observe(token string, path string, contentFormat string, obs
 		return nil, nil, reqErr
 	}
 
-	dataChan, doneChan, err := z.readFromRouterSocket(resp, "", -1)
+	dataChan, doneChan, err := z.readFromRouterSocket(resp, "", path, -1)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -242,43 +407,42 @@ func (z ZestClient) Observe(token string, path string, contentFormat string, obs
 
 }
 
-func (z ZestClient) Notify(token string, path string, contentFormat string, timeout uint32) (<-chan []byte, chan struct{}, error) {
+//Notify registers interest in the next message posted to path and returns a
+//future that resolves with it. timeout is the Max-Age in seconds, 0 waits until
+//the future is closed or the context passed to Wait is done. The message is
+//routed to NotifyIdentity(path).
+func (z *ZestClient) Notify(token string, path string, contentFormat string, timeout uint32) (*NotifyFuture, error) {
 
 	err := checkContentFormatFormat(contentFormat)
 	if err != nil {
-		return nil, nil, err
+		return nil, err
 	}
 
-	zr := zestHeader{}
-	zr.Code = 1
-	zr.Token = token
+	zr := z.newRequest(1, token, path, contentFormat)
 
-	//options
-	zr.Options = append(zr.Options, zestOptions{Number: 11, Value: path})
-	zr.Options = append(zr.Options, zestOptions{Number: 3, Value: z.hostname})
-	zr.Options = append(zr.Options, zestOptions{Number: 12, Value: string(pack_16(contentFormatToInt(contentFormat)))})
+	//notify options
 	zr.Options = append(zr.Options, zestOptions{Number: 14, Value: string(pack_32(timeout))})
 
 	bytes, marshalErr := zr.Marshal()
 	if marshalErr != nil {
-		return nil, nil, errors.New("Zest Header Marshal " + marshalErr.Error())
+		return nil, marshalErr
 	}
 
 	resp, reqErr := z.sendRequestAndAwaitResponse(bytes)
 	if reqErr != nil {
-		return nil, nil, errors.New("sendRequestAndAwaitResponse " + reqErr.Error())
+		return nil, reqErr
 	}
 
-	dataChan, doneChan, err := z.readFromRouterSocket(resp, path, 1)
+	sub, err := z.subscribe(NotifyIdentity(path), resp)
 	if err != nil {
-		return nil, nil, errors.New("readFromRouterSocket " + err.Error())
+		return nil, err
 	}
 
-	return dataChan, doneChan, nil
+	return z.newNotifyFuture(sub, path, timeout)
 
 }
 
-func (z ZestClient) sendRequest(msg []byte) error {
+func (z *ZestClient) sendRequest(msg []byte) error {
 
 	ZMQsoc, err := z.createSocket(zmq.ROUTER)
 	if err != nil {
@@ -297,151 +461,124 @@ func (z ZestClient) sendRequest(msg []byte) error {
 	return nil
 }
 
-func (z ZestClient) sendRequestAndAwaitResponse(msg []byte) (zestHeader, error) {
+func (z *ZestClient) sendRequestAndAwaitResponse(msg []byte) (zestHeader, error) {
+	return z.roundTrip(context.Background(), msg)
+}
 
-	ZMQsoc, err := z.createSocket(zmq.ROUTER)
-	if err != nil {
-		return zestHeader{}, errors.New("Can't connect so server")
-	}
-	defer ZMQsoc.Close()
+//roundTrip sends msg with the client's transport and parses the reply
+func (z *ZestClient) roundTrip(ctx context.Context, msg []byte) (zestHeader, error) {
 
 	z.log("Sending request:")
 	z.Hexlog(msg)
 
-	_, err = ZMQsoc.SendBytes(msg, 0)
+	err := z.checkToken(msg)
 	if err != nil {
 		return zestHeader{}, err
 	}
 
-	respChan, errChan := RecvBytesOverChan(ZMQsoc)
-	var resp []byte
-	var recvErr error
-	z.enableLogging = false
-	select {
-	case err := <-errChan:
-		recvErr = err
-		z.log("got error")
-
-	case resp = <-respChan:
-		z.log("got response")
-		z.Hexlog(resp)
-
-	case <-time.After(11 * time.Second):
-		z.log("timeout reading from router")
+	resp, err := z.Transport().RoundTrip(ctx, msg)
+	if err != nil {
+		return zestHeader{}, err
 	}
 
-	if recvErr != nil {
-		return zestHeader{}, recvErr
-	}
+	z.log("got response")
+	z.Hexlog(resp)
 
-	parsedResp, errResp := z.handleResponse(resp)
-	if errResp != nil {
-		return zestHeader{}, errResp
-	}
-
-	z.enableLogging = false
-	return parsedResp, nil
+	//error responses are returned too, they can carry options such as Size1
+	return z.handleResponse(resp)
 }
 
-func (z *ZestClient) readFromRouterSocket(header zestHeader, path string, numReads int) (<-chan []byte, chan struct{}, error) {
-
-	dealerRcvtimeo := time.Second * 1
-	dealer, err := zmq.NewSocket(zmq.DEALER)
-	dealer.SetRcvtimeo(dealerRcvtimeo)
-	dealer.SetConnectTimeout(time.Second * 10)
-
-	if err != nil {
-		return nil, nil, err
-	}
+//readFromRouterSocket delivers the messages for an Observe, or a Notify on
+//path when path is set. observed is the path the events are about.
+func (z *ZestClient) readFromRouterSocket(header zestHeader, path string, observed string, numReads int) (<-chan []byte, chan struct{}, error) {
 
-	serverKey := ""
-	if path != "" {
-		//Notify uri_path
-		err = dealer.SetIdentity(path)
-		if err != nil {
-			return nil, nil, errors.New("dealer.SetIdentity " + err.Error())
-		}
-	} else {
+	identity := path
+	if identity == "" {
 		//Observe
-		err = dealer.SetIdentity(string(header.Payload))
-		if err != nil {
-			return nil, nil, errors.New("dealer.SetIdentity " + err.Error())
-		}
+		identity = string(header.Payload)
 	}
 
-	for _, option := range header.Options {
-		//set Public key
-		if option.Number == 2048 {
-			serverKey = option.Value
-			break
-		}
-	}
-
-	z.log("Using serverKey " + serverKey)
-	clientPublic, clientSecret, err := zmq.NewCurveKeypair()
+	sub, err := z.subscribe(identity, header)
 	if err != nil {
 		return nil, nil, err
 	}
 
-	err = dealer.ClientAuthCurve(serverKey, clientPublic, clientSecret)
-	if err != nil {
-		return nil, nil, errors.New("ClientAuthCurve " + err.Error())
-	}
-
-	connError := dealer.Connect(z.DealerEndpoint)
-	if err != nil {
-		return nil, nil, errors.New("dealer.Connect " + connError.Error())
+	if !z.track() {
+		sub.Close()
+		return nil, nil, ErrClientClosed
 	}
 
 	dataChan := make(chan []byte)
 	doneChan := make(chan struct{})
 	timesRead := 0
+	//the subscription is only ever touched from this goroutine
 	go func() {
-		done := false
+		defer z.subs.Done()
+		defer close(dataChan)
+		defer func() {
+			z.log("readFromRouterSocket:: closing socket")
+			sub.Close()
+		}()
+
 		for {
-			z.log("readFromRouterSocket:: Waiting for response on id " + string(header.Payload) + " .....")
-			respChan, errChan := RecvBytesOverChan(dealer)
 			select {
-			case err := <-errChan:
-				z.log("readfromTRouterSocket:: entered case errChan")
-				if err.Error() != "resource temporarily unavailable" {
-					z.log("readFromRouterSocket:: Error reading from dealer " + err.Error())
-				}
-				break
-			case resp := <-respChan:
-				parsedResp, errResp := z.handleResponse(resp)
-				if errResp != nil {
-					z.log("readFromRouterSocket:: Error decoding response from dealer")
-					continue
-				}
-				dataChan <- parsedResp.Payload
-				timesRead++
-				if numReads > 0 && timesRead >= numReads {
-					done = true
-				}
-				break
 			case <-doneChan:
 				z.log("readFromRouterSocket:: got message on doneChan")
-				done = true
-				break
-			case <-time.After(11 * time.Second):
-				z.log("readFromRouterSocket:: timeout reading from dealer")
-				break
+				return
+			case <-z.closing:
+				z.log("readFromRouterSocket:: client closed")
+				return
+			default:
+			}
+
+			z.log("readFromRouterSocket:: Waiting for response on id " + identity + " .....")
+			resp, err := sub.Recv()
+			if err == ErrRecvTimeout {
+				continue
+			}
+			if err != nil {
+				//the socket is broken, retrying would spin, so end the Observe
+				z.log("readFromRouterSocket:: Error reading from dealer " + err.Error())
+				return
 			}
-			if done {
+
+			parsedResp, errResp := z.handleResponse(resp)
+			if errResp != nil {
+				z.log("readFromRouterSocket:: Error decoding response from dealer")
+				continue
+			}
+
+			//the value changed, so cached reads of it are stale
+			if cache := z.getCache(); cache != nil && cache.InvalidateOnObserve {
+				cache.Invalidate(observed)
+			}
+
+			select {
+			case dataChan <- parsedResp.Payload:
+			case <-doneChan:
+				return
+			case <-z.closing:
+				return
+			}
+
+			timesRead++
+			if numReads > 0 && timesRead >= numReads {
 				z.log("readFromRouterSocket:: exiting for loop")
-				break
+				return
 			}
 		}
-		z.log("readFromRouterSocket:: exiting waiting for timeout ")
-		time.Sleep(dealerRcvtimeo * 2)
-		z.log("readFromRouterSocket:: closing socket")
-		dealer.Close()
 	}()
 
 	return dataChan, doneChan, nil
 }
 
+//subscribe starts receiving the messages routed to identity, authenticating
+//with the server key carried in header (option 2048)
+func (z *ZestClient) subscribe(identity string, header zestHeader) (Subscription, error) {
+	serverKey, _ := header.option(2048)
+	return z.Transport().Subscribe(identity, serverKey)
+}
+
 func RecvBytesOverChan(soc *zmq.Socket) (chan []byte, chan error) {
 	dataChan := make(chan []byte)
 	errChan := make(chan error)
@@ -460,7 +597,7 @@ func RecvBytesOverChan(soc *zmq.Socket) (chan []byte, chan error) {
 	return dataChan, errChan
 }
 
-func (z ZestClient) handleResponse(msg []byte) (zestHeader, error) {
+func (z *ZestClient) handleResponse(msg []byte) (zestHeader, error) {
 
 	z.log("Got response:")
 	z.Hexlog(msg)
@@ -479,66 +616,69 @@ func (z ZestClient) handleResponse(msg []byte) (zestHeader, error) {
 	case 66:
 		//Deleted
 		return zr, nil
+	case 67:
+		//valid, the ETag sent with a Get still matches
+		return zr, nil
+	case 68:
+		//changed
+		return zr, nil
 	case 69:
 		//content
 		return zr, nil
+	case 95:
+		//continue, a block of a block-wise upload was accepted
+		return zr, nil
 	case 128:
-		return zr, errors.New("bad request")
+		return zr, &ResponseError{Code: zr.Code, Message: "bad request"}
 	case 129:
-		return zr, errors.New("unauthorized")
+		return zr, &ResponseError{Code: zr.Code, Message: "unauthorized"}
+	case 132:
+		return zr, &ResponseError{Code: zr.Code, Message: "not found"}
 	case 143:
-		return zr, errors.New("unsupported content format")
+		return zr, &ResponseError{Code: zr.Code, Message: "unsupported content format"}
 	case 163:
-		return zr, errors.New("service unavailable")
+		return zr, &ResponseError{Code: zr.Code, Message: "service unavailable"}
 	case 134:
-		return zr, errors.New("not acceptable")
+		return zr, &ResponseError{Code: zr.Code, Message: "not acceptable"}
+	case 140:
+		return zr, &ResponseError{Code: zr.Code, Message: "precondition failed"}
+	case 136:
+		return zr, &ResponseError{Code: zr.Code, Message: "request entity incomplete"}
 	case 141:
-		return zr, errors.New("request entity too large")
+		return zr, &ResponseError{Code: zr.Code, Message: "request entity too large"}
 	case 160:
-		return zr, errors.New("internal server error")
+		return zr, &ResponseError{Code: zr.Code, Message: "internal server error"}
+	case 162:
+		return zr, &ResponseError{Code: zr.Code, Message: "bad gateway"}
+	case 164:
+		return zr, &ResponseError{Code: zr.Code, Message: "gateway timeout"}
 	}
 	return zr, errors.New("invalid code:" + strconv.Itoa(int(zr.Code)))
 }
 
-func (z ZestClient) log(msg string) {
+//ResponseError is returned when the server answers with an error response code
+type ResponseError struct {
+	Code    uint8
+	Message string
+}
+
+func (e *ResponseError) Error() string {
+	return e.Message
+}
+
+func (z *ZestClient) log(msg string) {
 	if z.enableLogging {
 		t := time.Now()
 		fmt.Println("[", me, " ", t, "] ", msg)
 	}
 }
 
-func (z ZestClient) Hexlog(msg []byte) {
+//Hexlog logs a frame decoded by DumpFrame followed by its raw bytes, with
+//the token masked
+func (z *ZestClient) Hexlog(msg []byte) {
 	if z.enableLogging {
 		t := time.Now()
-		fmt.Println("[", me, " ", t, "] \n", hex.Dump(msg))
+		msg = maskToken(msg)
+		fmt.Println("[", me, " ", t, "] \n", DumpFrame(msg)+hex.Dump(msg))
 	}
 }
-
-func checkContentFormatFormat(format string) error {
-
-	switch strings.ToUpper(format) {
-	case "TEXT":
-		return nil
-	case "BINARY":
-		return nil
-	case "JSON":
-		return nil
-	default:
-		return errors.New("Unsupported Content format: " + format)
-	}
-
-}
-
-func contentFormatToInt(format string) uint16 {
-
-	switch strings.ToUpper(format) {
-	case "TEXT":
-		return 0
-	case "BINARY":
-		return 42
-	case "JSON":
-		return 50
-	}
-
-	return 0
-}
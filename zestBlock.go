@@ -0,0 +1,302 @@
+package zest
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"strconv"
+)
+
+//Block-wise transfers follow CoAP (RFC 7959) using the same option numbers in
+//the Zest option space. A block option value is NUM<<4 | M<<3 | SZX packed as
+//a minimal big endian uint, where the block size is 2^(SZX+4) bytes.
+const (
+	optionBlock2 = 23
+	optionBlock1 = 27
+	optionSize2  = 28
+	optionSize1  = 60
+)
+
+const (
+	minBlockSize     = 16
+	maxBlockSize     = 1024
+	defaultBlockSize = 1024
+)
+
+type block struct {
+	num  uint32
+	more bool
+	szx  uint8
+}
+
+func (b block) size() int {
+	return 1 << (uint(b.szx) + 4)
+}
+
+func (b block) value() string {
+	v := b.num<<4 | uint32(b.szx)
+	if b.more {
+		v |= 0x08
+	}
+	return packUint(v)
+}
+
+func parseBlock(value string) (block, error) {
+	if len(value) > 3 {
+		return block{}, errors.New("block option too long")
+	}
+	v := unpackUint(value)
+	if v&0x07 == 7 {
+		return block{}, errors.New("reserved block size")
+	}
+	return block{num: v >> 4, more: v&0x08 != 0, szx: uint8(v & 0x07)}, nil
+}
+
+func blockSZX(size int) (uint8, error) {
+	for szx := uint8(0); szx <= 6; szx++ {
+		if 1<<(uint(szx)+4) == size {
+			return szx, nil
+		}
+	}
+	return 0, errors.New("block size must be a power of two from " + strconv.Itoa(minBlockSize) + " to " + strconv.Itoa(maxBlockSize) + ": " + strconv.Itoa(size))
+}
+
+//packUint encodes v as a minimal length big endian uint, zero has no bytes
+func packUint(v uint32) string {
+	var b []byte
+	for v > 0 {
+		b = append([]byte{byte(v)}, b...)
+		v >>= 8
+	}
+	return string(b)
+}
+
+func unpackUint(s string) uint32 {
+	var v uint32
+	for i := 0; i < len(s); i++ {
+		v = v<<8 | uint32(s[i])
+	}
+	return v
+}
+
+//BlockError reports a block-wise transfer that stopped part way through. Block
+//is the first block that wasn't acknowledged and Offset is where it starts in
+//the payload. Pass it to ResumePostReader or ResumeGetTo to carry on from there.
+type BlockError struct {
+	Block  uint32
+	Size   int
+	Offset int64
+	Err    error
+}
+
+func (e *BlockError) Error() string {
+	return "block " + strconv.Itoa(int(e.Block)) + " at offset " + strconv.FormatInt(e.Offset, 10) + ": " + e.Err.Error()
+}
+
+func newBlockError(num uint32, size int, err error) error {
+	//nothing to resume from if the first block failed
+	if num == 0 {
+		return err
+	}
+	return &BlockError{Block: num, Size: size, Offset: int64(num) * int64(size), Err: err}
+}
+
+//SetBlockSize sets the block size used to split uploads and request downloads.
+//size must be a power of two from 16 to 1024. With the default of 0, Post and
+//Get only switch to block-wise transfer when the server asks for it, and
+//PostReader splits anything larger than 1024 bytes.
+func (z *ZestClient) SetBlockSize(size int) error {
+	if size != 0 {
+		_, err := blockSZX(size)
+		if err != nil {
+			return err
+		}
+	}
+
+	z.mu.Lock()
+	z.blockSize = size
+	z.mu.Unlock()
+
+	return nil
+}
+
+func (z *ZestClient) getBlockSize() int {
+	z.mu.Lock()
+	defer z.mu.Unlock()
+	return z.blockSize
+}
+
+//sizeFromSize1 picks the largest block size within the limit a server sent in
+//the Size1 option of a 4.13 response
+func sizeFromSize1(resp zestHeader) int {
+	size := defaultBlockSize
+	v, ok := resp.option(optionSize1)
+	if !ok {
+		return size
+	}
+	limit := int(unpackUint(v))
+	for size > minBlockSize && size > limit {
+		size /= 2
+	}
+	return size
+}
+
+//postBlocks uploads r with Block1 options starting at block from. Only one
+//block of r is held in memory at a time. extra options are added to every request.
+func (z *ZestClient) postBlocks(ctx context.Context, token string, path string, r io.Reader, contentFormat string, size int, from uint32, extra ...zestOptions) ([]byte, error) {
+
+	szx, err := blockSZX(size)
+	if err != nil {
+		return nil, err
+	}
+
+	br := bufio.NewReaderSize(r, size)
+	buf := make([]byte, size)
+	num := from
+	for {
+		n, err := io.ReadFull(br, buf[:size])
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return nil, newBlockError(num, size, err)
+		}
+		_, err = br.Peek(1)
+		if err != nil && err != io.EOF {
+			return nil, newBlockError(num, size, err)
+		}
+		more := err == nil
+
+		zr := z.newRequest(2, token, path, contentFormat)
+		zr.Payload = buf[:n]
+		zr.Options = append(zr.Options, extra...)
+		zr.Options = append(zr.Options, zestOptions{Number: optionBlock1, Value: block{num: num, more: more, szx: szx}.value()})
+
+		bytes, marshalErr := zr.Marshal()
+		if marshalErr != nil {
+			return nil, marshalErr
+		}
+
+		z.log("Posting block " + strconv.Itoa(int(num)))
+		resp, reqErr := z.roundTrip(ctx, bytes)
+		if reqErr != nil {
+			return nil, newBlockError(num, size, reqErr)
+		}
+
+		if !more {
+			z.log("=> Created")
+			return resp.Payload, nil
+		}
+		if resp.Code != 95 {
+			return nil, newBlockError(num+1, size, errors.New("server did not continue the block-wise upload"))
+		}
+
+		next := num + 1
+		//the server may ask for smaller blocks from here on
+		if v, ok := resp.option(optionBlock1); ok {
+			ack, err := parseBlock(v)
+			if err == nil && ack.szx < szx {
+				next = uint32(int64(next) * int64(size) / int64(ack.size()))
+				szx = ack.szx
+				size = ack.size()
+			}
+		}
+		num = next
+	}
+}
+
+//getBlocks downloads path into w, following Block2 options until the last
+//block, and returns the last response so its options can be read. When size
+//is 0 the first request carries no Block2 option and the server decides
+//whether to split the response. extra options are only added to the first request.
+func (z *ZestClient) getBlocks(ctx context.Context, token string, path string, w io.Writer, contentFormat string, size int, from uint32, extra ...zestOptions) (int64, zestHeader, error) {
+
+	var szx uint8
+	if size != 0 {
+		var err error
+		szx, err = blockSZX(size)
+		if err != nil {
+			return 0, zestHeader{}, err
+		}
+	}
+
+	var written int64
+	var resp zestHeader
+	var etag []byte
+	num := from
+	for {
+		zr := z.newRequest(1, token, path, contentFormat)
+		if num == from {
+			zr.Options = append(zr.Options, extra...)
+		}
+		if size != 0 {
+			zr.Options = append(zr.Options, zestOptions{Number: optionBlock2, Value: block{num: num, szx: szx}.value()})
+		}
+
+		bytes, marshalErr := zr.Marshal()
+		if marshalErr != nil {
+			return written, resp, marshalErr
+		}
+
+		var reqErr error
+		resp, reqErr = z.roundTrip(ctx, bytes)
+		if reqErr != nil {
+			return written, resp, newBlockError(num, size, reqErr)
+		}
+
+		//every block must come from the same version of the value
+		if v, ok := resp.option(optionETag); ok {
+			if etag != nil && v != string(etag) {
+				return written, resp, newBlockError(num, size, errors.New("value changed during block-wise transfer"))
+			}
+			etag = []byte(v)
+		}
+
+		n, err := w.Write(resp.Payload)
+		written += int64(n)
+		if err != nil {
+			return written, resp, newBlockError(num, size, err)
+		}
+
+		v, ok := resp.option(optionBlock2)
+		if !ok {
+			return written, resp, nil
+		}
+		b, err := parseBlock(v)
+		if err != nil {
+			return written, resp, newBlockError(num+1, size, err)
+		}
+		if !b.more {
+			return written, resp, nil
+		}
+
+		z.log("Getting block " + strconv.Itoa(int(b.num+1)))
+		szx = b.szx
+		size = b.size()
+		num = b.num + 1
+	}
+}
+
+//ResumePostReader carries on a block-wise upload that failed with a
+//*BlockError. r must be positioned at the error's Offset.
+func (z *ZestClient) ResumePostReader(ctx context.Context, token string, path string, r io.Reader, contentFormat string, from *BlockError) ([]byte, error) {
+
+	err := checkContentFormatFormat(contentFormat)
+	if err != nil {
+		return nil, err
+	}
+	defer z.invalidate(path)
+
+	return z.postBlocks(ctx, token, path, r, contentFormat, from.Size, from.Block)
+}
+
+//ResumeGetTo carries on a block-wise download that failed with a *BlockError,
+//writing the rest of the value to w.
+func (z *ZestClient) ResumeGetTo(ctx context.Context, token string, path string, w io.Writer, contentFormat string, from *BlockError) (int64, error) {
+
+	err := checkContentFormatFormat(contentFormat)
+	if err != nil {
+		return 0, err
+	}
+
+	n, _, err := z.getBlocks(ctx, token, path, w, contentFormat, from.Size, from.Block)
+	return n, err
+}
@@ -0,0 +1,211 @@
+package zest_test
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	zest "github.com/me-box/goZestClient"
+	"github.com/me-box/goZestClient/zesttest"
+)
+
+//newTestClient returns a client talking to s
+func newTestClient(t *testing.T, s *zesttest.Server) *zest.ZestClient {
+	z, err := zest.New("", "", "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	z.SetTransport(s)
+	return z
+}
+
+//testPayload is n bytes that differ from block to block
+func testPayload(n int) []byte {
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = byte('a' + i%26)
+	}
+	return b
+}
+
+//blockNum is the block number of a Block1 or Block2 option, -1 without one
+func blockNum(f zest.Frame, number uint16) int {
+	v, ok := f.Option(number)
+	if !ok {
+		return -1
+	}
+	var n int
+	for _, c := range v {
+		n = n<<8 | int(c)
+	}
+	return n >> 4
+}
+
+func TestPostSplitsIntoBlocks(t *testing.T) {
+	s := zesttest.NewServer()
+	z := newTestClient(t, s)
+	z.SetBlockSize(16)
+
+	payload := testPayload(100)
+	_, err := z.Post("", "/kv/test/big", payload, "BINARY")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stored, _ := s.Value("/kv/test/big")
+	if !bytes.Equal(stored, payload) {
+		t.Fatalf("stored %q, want %q", stored, payload)
+	}
+	reqs := s.Requests()
+	if len(reqs) != 7 {
+		t.Fatalf("sent %d requests, want 7 blocks of 16 bytes", len(reqs))
+	}
+	for i, req := range reqs {
+		if n := blockNum(req, 27); n != i {
+			t.Errorf("request %d carried block %d", i, n)
+		}
+	}
+}
+
+func TestGetReassemblesBlocks(t *testing.T) {
+	s := zesttest.NewServer()
+	s.BlockSize = 16
+	payload := testPayload(100)
+	s.Set("/kv/test/big", payload, "BINARY")
+	z := newTestClient(t, s)
+
+	value, err := z.Get("", "/kv/test/big", "BINARY")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(value, payload) {
+		t.Fatalf("got %q, want %q", value, payload)
+	}
+	if n := len(s.Requests()); n != 7 {
+		t.Fatalf("sent %d requests, want 7", n)
+	}
+}
+
+func TestServerBlockSizeIsRounded(t *testing.T) {
+	cases := []struct {
+		blockSize int
+		length    int
+		requests  int
+	}{
+		{100, 200, 4},
+		{8, 40, 3},
+		{5000, 3000, 3},
+		{1024, 3000, 3},
+	}
+	for _, tc := range cases {
+		s := zesttest.NewServer()
+		s.BlockSize = tc.blockSize
+		payload := testPayload(tc.length)
+		s.Set("/kv/test/big", payload, "BINARY")
+		z := newTestClient(t, s)
+
+		value, err := z.Get("", "/kv/test/big", "BINARY")
+		if err != nil {
+			t.Errorf("BlockSize %d: %v", tc.blockSize, err)
+			continue
+		}
+		if !bytes.Equal(value, payload) {
+			t.Errorf("BlockSize %d: got %d bytes back, not the value", tc.blockSize, len(value))
+		}
+		if n := len(s.Requests()); n != tc.requests {
+			t.Errorf("BlockSize %d: sent %d requests, want %d", tc.blockSize, n, tc.requests)
+		}
+	}
+}
+
+func TestPostFallsBackToBlocksOnTooLarge(t *testing.T) {
+	s := zesttest.NewServer()
+	s.MaxPayload = 64
+	z := newTestClient(t, s)
+
+	payload := testPayload(200)
+	_, err := z.Post("", "/kv/test/big", payload, "BINARY")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stored, _ := s.Value("/kv/test/big")
+	if !bytes.Equal(stored, payload) {
+		t.Fatalf("stored %q, want %q", stored, payload)
+	}
+	reqs := s.Requests()
+	if blockNum(reqs[0], 27) != -1 {
+		t.Fatal("the first attempt should be sent in one go")
+	}
+	//Size1 of 64 allows 64 byte blocks
+	if len(reqs) != 1+4 {
+		t.Fatalf("sent %d requests, want 1 refused and 4 blocks", len(reqs))
+	}
+}
+
+func TestResumePostReader(t *testing.T) {
+	s := zesttest.NewServer()
+	failed := false
+	s.Handle = func(req zest.Frame) (zest.Frame, bool) {
+		if blockNum(req, 27) == 3 && !failed {
+			failed = true
+			return zest.Frame{Code: 160}, true
+		}
+		return zest.Frame{}, false
+	}
+	z := newTestClient(t, s)
+	z.SetBlockSize(16)
+
+	payload := testPayload(100)
+	_, err := z.PostReader(context.Background(), "", "/kv/test/big", bytes.NewReader(payload), "BINARY")
+	be, ok := err.(*zest.BlockError)
+	if !ok {
+		t.Fatalf("got %v, want a *BlockError", err)
+	}
+	if be.Block != 3 || be.Offset != 48 {
+		t.Fatalf("failed at block %d offset %d, want block 3 offset 48", be.Block, be.Offset)
+	}
+
+	_, err = z.ResumePostReader(context.Background(), "", "/kv/test/big", bytes.NewReader(payload[be.Offset:]), "BINARY", be)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored, _ := s.Value("/kv/test/big")
+	if !bytes.Equal(stored, payload) {
+		t.Fatalf("stored %q, want %q", stored, payload)
+	}
+}
+
+func TestResumeGetTo(t *testing.T) {
+	s := zesttest.NewServer()
+	s.BlockSize = 16
+	payload := testPayload(100)
+	s.Set("/kv/test/big", payload, "BINARY")
+	failed := false
+	s.Handle = func(req zest.Frame) (zest.Frame, bool) {
+		if blockNum(req, 23) == 2 && !failed {
+			failed = true
+			return zest.Frame{Code: 163}, true
+		}
+		return zest.Frame{}, false
+	}
+	z := newTestClient(t, s)
+
+	var buf bytes.Buffer
+	_, err := z.GetTo(context.Background(), "", "/kv/test/big", &buf, "BINARY")
+	be, ok := err.(*zest.BlockError)
+	if !ok {
+		t.Fatalf("got %v, want a *BlockError", err)
+	}
+	if be.Block != 2 || int(be.Offset) != buf.Len() {
+		t.Fatalf("failed at block %d offset %d after %d bytes", be.Block, be.Offset, buf.Len())
+	}
+
+	_, err = z.ResumeGetTo(context.Background(), "", "/kv/test/big", &buf, "BINARY", be)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf.Bytes(), payload) {
+		t.Fatalf("got %q, want %q", buf.Bytes(), payload)
+	}
+}
@@ -0,0 +1,129 @@
+package zest
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/fxamacker/cbor/v2"
+)
+
+//cborCodec encodes values as CBOR (RFC 8949). Struct fields use their cbor
+//tags, or their json tags when they have none, and []byte is a byte string.
+//With senml set, SenML labels are replaced by their integer keys (RFC 8428).
+type cborCodec struct {
+	senml bool
+}
+
+//senmlLabels maps SenML JSON labels to the integer keys used in SenML CBOR
+var senmlLabels = map[string]int64{
+	"bver": -1, "bn": -2, "bt": -3, "bu": -4, "bv": -5, "bs": -6,
+	"n": 0, "u": 1, "v": 2, "vs": 3, "vb": 4, "s": 5, "t": 6, "ut": 7, "vd": 8,
+}
+
+var senmlKeys = map[int64]string{}
+
+//the core deterministic encoding sorts map keys, so equal values encode the same
+var cborEnc, _ = cbor.CoreDetEncOptions().EncMode()
+var cborDec, _ = cbor.DecOptions{}.DecMode()
+
+func init() {
+	for label, key := range senmlLabels {
+		senmlKeys[key] = label
+	}
+}
+
+func (c cborCodec) Marshal(v interface{}) ([]byte, error) {
+	b, err := cborEnc.Marshal(v)
+	if err != nil || !c.senml {
+		return b, err
+	}
+
+	//swap the labels the value was encoded with for their integer keys
+	var generic interface{}
+	err = cborDec.Unmarshal(b, &generic)
+	if err != nil {
+		return nil, err
+	}
+	return cborEnc.Marshal(relabel(generic, c.senml, true))
+}
+
+func (c cborCodec) Unmarshal(data []byte, v interface{}) error {
+	if !c.senml {
+		if t, ok := v.(*interface{}); ok {
+			var generic interface{}
+			err := cborDec.Unmarshal(data, &generic)
+			*t = relabel(generic, false, false)
+			return err
+		}
+		return cborDec.Unmarshal(data, v)
+	}
+
+	var generic interface{}
+	err := cborDec.Unmarshal(data, &generic)
+	if err != nil {
+		return err
+	}
+	generic = relabel(generic, true, false)
+	if t, ok := v.(*interface{}); ok {
+		*t = generic
+		return nil
+	}
+	//decode again with labels so v can be a map or a struct with json tags
+	b, err := cborEnc.Marshal(generic)
+	if err != nil {
+		return err
+	}
+	return cborDec.Unmarshal(b, v)
+}
+
+//relabel rewrites the map keys of a decoded value. Going to CBOR, SenML labels
+//become their integer keys. Coming from it every key becomes a string, the
+//SenML label for an integer key if senml is set, so the value can be shown as
+//JSON or decoded into a map[string]interface{}.
+func relabel(v interface{}, senml bool, toCBOR bool) interface{} {
+	switch t := v.(type) {
+	case []interface{}:
+		out := make([]interface{}, len(t))
+		for i, item := range t {
+			out[i] = relabel(item, senml, toCBOR)
+		}
+		return out
+	case map[interface{}]interface{}:
+		if toCBOR {
+			out := make(map[interface{}]interface{}, len(t))
+			for k, item := range t {
+				if s, ok := k.(string); ok && senml {
+					if key, ok := senmlLabels[s]; ok {
+						k = key
+					}
+				}
+				out[k] = relabel(item, senml, toCBOR)
+			}
+			return out
+		}
+		out := make(map[string]interface{}, len(t))
+		for k, item := range t {
+			out[keyString(k, senml)] = relabel(item, senml, toCBOR)
+		}
+		return out
+	}
+	return v
+}
+
+func keyString(k interface{}, senml bool) string {
+	if s, ok := k.(string); ok {
+		return s
+	}
+	rv := reflect.ValueOf(k)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if label, ok := senmlKeys[rv.Int()]; ok && senml {
+			return label
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if label, ok := senmlKeys[int64(rv.Uint())]; ok && senml && rv.Uint() < 1<<63 {
+			return label
+		}
+	}
+	return fmt.Sprint(k)
+}
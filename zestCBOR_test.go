@@ -0,0 +1,134 @@
+package zest
+
+import (
+	"bytes"
+	"encoding/hex"
+	"reflect"
+	"testing"
+)
+
+func TestCBORRoundTrip(t *testing.T) {
+	type reading struct {
+		Room  string  `json:"room"`
+		Value float64 `json:"value"`
+		Raw   []byte  `json:"raw"`
+	}
+	in := reading{Room: "kitchen", Value: 21.5, Raw: []byte{0, 1, 2}}
+	b, err := cborCodec{}.Marshal(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out reading
+	if err := (cborCodec{}).Unmarshal(b, &out); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(in, out) {
+		t.Fatalf("got %+v, want %+v", out, in)
+	}
+
+	var generic interface{}
+	if err := (cborCodec{}).Unmarshal(b, &generic); err != nil {
+		t.Fatal(err)
+	}
+	m, ok := generic.(map[string]interface{})
+	if !ok || m["room"] != "kitchen" || !bytes.Equal(m["raw"].([]byte), in.Raw) {
+		t.Fatalf("decoded %#v", generic)
+	}
+}
+
+func TestCBORByteString(t *testing.T) {
+	b, err := cborCodec{}.Marshal([]byte("hi"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hex.EncodeToString(b) != "426869" {
+		t.Fatalf("encoded %x, want a byte string", b)
+	}
+	var out []byte
+	if err := (cborCodec{}).Unmarshal(b, &out); err != nil || string(out) != "hi" {
+		t.Fatalf("got %q %v", out, err)
+	}
+}
+
+func TestCBORIsDeterministic(t *testing.T) {
+	v := map[string]interface{}{"b": 1, "a": 2, "cc": 3}
+	first, _ := cborCodec{}.Marshal(v)
+	for i := 0; i < 10; i++ {
+		again, _ := cborCodec{}.Marshal(v)
+		if !bytes.Equal(first, again) {
+			t.Fatal("the same map encoded differently")
+		}
+	}
+}
+
+func TestSenMLCBORRoundTrip(t *testing.T) {
+	pack := []map[string]interface{}{
+		{"bn": "urn:dev:ow:10e2073a01080063:", "bt": 1.276020076e+09, "bu": "A", "n": "voltage", "v": 120.1},
+		{"n": "current", "t": -5, "v": 1.2},
+		{"n": "open", "vb": true},
+		{"n": "blob", "vd": []byte{1, 2}},
+	}
+	b, err := cborCodec{senml: true}.Marshal(pack)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//on the wire the labels are integer keys
+	var wire []map[int64]interface{}
+	if err := cborDec.Unmarshal(b, &wire); err != nil {
+		t.Fatalf("SenML CBOR should have integer keys: %v", err)
+	}
+	if wire[0][-2] != "urn:dev:ow:10e2073a01080063:" || wire[0][0] != "voltage" || wire[2][4] != true {
+		t.Fatalf("encoded %#v", wire)
+	}
+
+	var out []map[string]interface{}
+	if err := (cborCodec{senml: true}).Unmarshal(b, &out); err != nil {
+		t.Fatal(err)
+	}
+	if len(out) != 4 || out[0]["bn"] != pack[0]["bn"] || out[0]["v"] != 120.1 || out[1]["n"] != "current" || out[2]["vb"] != true {
+		t.Fatalf("decoded %#v", out)
+	}
+	if !bytes.Equal(out[3]["vd"].([]byte), []byte{1, 2}) {
+		t.Fatalf("vd decoded as %#v", out[3]["vd"])
+	}
+}
+
+func TestSenMLCBORStruct(t *testing.T) {
+	type record struct {
+		Name  string  `json:"n"`
+		Unit  string  `json:"u,omitempty"`
+		Value float64 `json:"v"`
+		Time  float64 `json:"t"`
+	}
+	in := []record{{Name: "temperature", Unit: "Cel", Value: 23.1, Time: 1.5e9}}
+	b, err := cborCodec{senml: true}.Marshal(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out []record
+	if err := (cborCodec{senml: true}).Unmarshal(b, &out); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(in, out) {
+		t.Fatalf("got %+v, want %+v", out, in)
+	}
+}
+
+func TestSenMLCBORDecode(t *testing.T) {
+	//[{0: "temp", 1: "Cel", 2: 23.0}] encoded by hand
+	b, _ := hex.DecodeString("81a3006474656d70016343656c02fb4037000000000000")
+	var v interface{}
+	if err := (cborCodec{senml: true}).Unmarshal(b, &v); err != nil {
+		t.Fatal(err)
+	}
+	want := []interface{}{map[string]interface{}{"n": "temp", "u": "Cel", "v": 23.0}}
+	if !reflect.DeepEqual(v, want) {
+		t.Fatalf("got %#v", v)
+	}
+
+	f := Frame{Options: []FrameOption{{Number: 12, Value: pack_16(112)}}, Payload: b}
+	if text := f.PayloadText(); !bytes.Contains([]byte(text), []byte(`"n": "temp"`)) {
+		t.Fatalf("payload shown as %s", text)
+	}
+}
@@ -0,0 +1,209 @@
+package zest
+
+import (
+	"container/list"
+	"strings"
+	"sync"
+	"time"
+)
+
+//optionMaxAge is how many seconds a response stays fresh, and for Observe and
+//Notify requests how long the subscription lasts
+const optionMaxAge = 14
+
+//ResponseCache keeps the values read by Get so that polling a path doesn't
+//cost a round trip each time. Entries are keyed by store endpoint, path, query
+//and content format, not by token, so a cache should only be shared by clients
+//acting for the same app. A value is reused without asking the store until its Max-Age
+//(option 14) runs out. After that, if it had an ETag, the next Get sends the
+//ETag and a 2.03 Valid answer renews the entry without the value being sent
+//again. Writes and deletes through a client using the cache drop the entries
+//at and below their path.
+type ResponseCache struct {
+	//MaxEntries caps the number of values kept, the least recently used is
+	//dropped first. 0 means no limit.
+	MaxEntries int
+	//DefaultMaxAge is how long a value without a Max-Age is fresh. It is 0 by
+	//default, so such values are only kept if they have an ETag to revalidate.
+	DefaultMaxAge time.Duration
+	//InvalidateOnObserve drops the entries at and below a path whenever an
+	//Observe on it, made with a client using the cache, receives an event
+	InvalidateOnObserve bool
+
+	mu      sync.Mutex
+	lru     *list.List
+	entries map[cacheKey]*list.Element
+	//generation counts invalidations so a read that raced one isn't stored
+	generation uint64
+}
+
+type cacheKey struct {
+	endpoint string
+	path     string
+	query    string
+	format   uint16
+}
+
+type cacheEntry struct {
+	key     cacheKey
+	value   []byte
+	etag    string
+	expires time.Time
+}
+
+//NewResponseCache returns a cache holding at most maxEntries values
+func NewResponseCache(maxEntries int) *ResponseCache {
+	return &ResponseCache{MaxEntries: maxEntries}
+}
+
+//SetCache makes Get answer from c when it can, nil turns caching off.
+//GetTo, GetWithETag and the other streaming and conditional reads always
+//go to the store.
+func (z *ZestClient) SetCache(c *ResponseCache) {
+	z.mu.Lock()
+	z.cache = c
+	z.mu.Unlock()
+}
+
+func (z *ZestClient) getCache() *ResponseCache {
+	z.mu.Lock()
+	defer z.mu.Unlock()
+	return z.cache
+}
+
+//invalidate drops the cached values at and below path after a write to it
+func (z *ZestClient) invalidate(path string) {
+	if c := z.getCache(); c != nil {
+		c.Invalidate(path)
+	}
+}
+
+//Len is the number of values in the cache
+func (c *ResponseCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.lru == nil {
+		return 0
+	}
+	return c.lru.Len()
+}
+
+//Invalidate drops the entries for path, with any query, and for the paths
+//below it, in every store. A trailing * is ignored, so an observed path such
+//as /kv/foo/* can be passed as it is.
+func (c *ResponseCache) Invalidate(path string) {
+	path, _ = splitQuery(path)
+	path = strings.TrimRight(strings.TrimSuffix(path, "*"), "/")
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.generation++
+	for key, e := range c.entries {
+		if key.path == path || strings.HasPrefix(key.path, path+"/") {
+			c.lru.Remove(e)
+			delete(c.entries, key)
+		}
+	}
+}
+
+//Purge empties the cache
+func (c *ResponseCache) Purge() {
+	c.mu.Lock()
+	c.lru = nil
+	c.entries = nil
+	c.generation++
+	c.mu.Unlock()
+}
+
+func newCacheKey(endpoint string, path string, contentFormat string) cacheKey {
+	path, query := splitQuery(path)
+	return cacheKey{endpoint: endpoint, path: path, query: strings.Join(query, "&"), format: contentFormatToInt(contentFormat)}
+}
+
+//cacheLookup is what the cache holds for a key. generation is passed back
+//to store or renew once the store has answered.
+type cacheLookup struct {
+	value      []byte
+	etag       string
+	found      bool
+	fresh      bool
+	generation uint64
+}
+
+//lookup returns a copy of the cached value for key
+func (c *ResponseCache) lookup(key cacheKey) cacheLookup {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	l := cacheLookup{generation: c.generation}
+	e, ok := c.entries[key]
+	if !ok {
+		return l
+	}
+	c.lru.MoveToFront(e)
+	entry := e.Value.(*cacheEntry)
+	l.value = append([]byte(nil), entry.value...)
+	l.etag = entry.etag
+	l.found = true
+	l.fresh = time.Now().Before(entry.expires)
+	return l
+}
+
+//maxAge is how long the value in resp is fresh
+func (c *ResponseCache) maxAge(resp zestHeader) time.Duration {
+	if v, ok := resp.option(optionMaxAge); ok {
+		return time.Duration(unpackUint(v)) * time.Second
+	}
+	return c.DefaultMaxAge
+}
+
+//store keeps value for key according to the Max-Age and ETag of resp, unless
+//the cache was invalidated since the lookup
+func (c *ResponseCache) store(key cacheKey, l cacheLookup, value []byte, resp zestHeader) {
+	etag, _ := resp.option(optionETag)
+	maxAge := c.maxAge(resp)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.generation != l.generation {
+		return
+	}
+	if maxAge <= 0 && etag == "" {
+		c.remove(key)
+		return
+	}
+	if c.entries == nil {
+		c.lru = list.New()
+		c.entries = map[cacheKey]*list.Element{}
+	}
+	entry := &cacheEntry{key: key, value: append([]byte(nil), value...), etag: etag, expires: time.Now().Add(maxAge)}
+	if e, ok := c.entries[key]; ok {
+		e.Value = entry
+		c.lru.MoveToFront(e)
+	} else {
+		c.entries[key] = c.lru.PushFront(entry)
+	}
+	for c.MaxEntries > 0 && c.lru.Len() > c.MaxEntries {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cacheEntry).key)
+	}
+}
+
+//renew extends a revalidated entry by the Max-Age of the 2.03 response
+func (c *ResponseCache) renew(key cacheKey, l cacheLookup, resp zestHeader) {
+	maxAge := c.maxAge(resp)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.entries[key]; ok && c.generation == l.generation {
+		e.Value.(*cacheEntry).expires = time.Now().Add(maxAge)
+	}
+}
+
+//remove drops key, c.mu must be held
+func (c *ResponseCache) remove(key cacheKey) {
+	if e, ok := c.entries[key]; ok {
+		c.lru.Remove(e)
+		delete(c.entries, key)
+	}
+}
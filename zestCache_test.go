@@ -0,0 +1,232 @@
+package zest_test
+
+import (
+	"testing"
+	"time"
+
+	zest "github.com/me-box/goZestClient"
+	"github.com/me-box/goZestClient/zesttest"
+)
+
+//gets counts the GET requests s received for path
+func gets(s *zesttest.Server, path string) int {
+	n := 0
+	for _, req := range s.Requests() {
+		if p, _ := req.Option(11); req.Code == 1 && string(p) == path {
+			n++
+		}
+	}
+	return n
+}
+
+func newCachedClient(t *testing.T, s *zesttest.Server, c *zest.ResponseCache) *zest.ZestClient {
+	z := newTestClient(t, s)
+	z.SetCache(c)
+	return z
+}
+
+func get(t *testing.T, z *zest.ZestClient, path string) string {
+	v, err := z.Get("", path, "TEXT")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(v)
+}
+
+func TestCacheMaxAge(t *testing.T) {
+	s := zesttest.NewServer()
+	s.MaxAge = 1
+	z := newCachedClient(t, s, zest.NewResponseCache(0))
+
+	s.Set("/kv/test/a", []byte("one"), "TEXT")
+	get(t, z, "/kv/test/a")
+	//changed behind the client's back, the cached value is still fresh
+	s.Set("/kv/test/a", []byte("two"), "TEXT")
+	if v := get(t, z, "/kv/test/a"); v != "one" || gets(s, "/kv/test/a") != 1 {
+		t.Fatalf("got %q after %d requests, want the cached value", v, gets(s, "/kv/test/a"))
+	}
+
+	time.Sleep(1100 * time.Millisecond)
+	if v := get(t, z, "/kv/test/a"); v != "two" || gets(s, "/kv/test/a") != 2 {
+		t.Fatalf("got %q after %d requests once Max-Age ran out", v, gets(s, "/kv/test/a"))
+	}
+}
+
+func TestCacheWithoutMaxAgeOrETag(t *testing.T) {
+	s := zesttest.NewServer()
+	c := zest.NewResponseCache(0)
+	z := newCachedClient(t, s, c)
+
+	//a missing key is answered without an ETag, so there is nothing to keep
+	get(t, z, "/kv/test/missing")
+	get(t, z, "/kv/test/missing")
+	if n := gets(s, "/kv/test/missing"); n != 2 {
+		t.Fatalf("%d requests, want 2", n)
+	}
+	if n := c.Len(); n != 0 {
+		t.Fatalf("%d entries cached", n)
+	}
+}
+
+func TestCacheRevalidatesETag(t *testing.T) {
+	s := zesttest.NewServer()
+	c := zest.NewResponseCache(0)
+	z := newCachedClient(t, s, c)
+
+	s.Set("/kv/test/a", []byte("one"), "TEXT")
+	get(t, z, "/kv/test/a")
+	first := s.Requests()[len(s.Requests())-1]
+	if _, ok := first.Option(4); ok {
+		t.Fatal("the first read sent an ETag")
+	}
+
+	//without a Max-Age every read is revalidated, and 2.03 keeps the value
+	valid := false
+	s.Handle = func(req zest.Frame) (zest.Frame, bool) {
+		_, valid = req.Option(4)
+		return zest.Frame{}, false
+	}
+	if v := get(t, z, "/kv/test/a"); v != "one" || !valid {
+		t.Fatalf("got %q, sent ETag %v", v, valid)
+	}
+
+	//a changed value has a new ETag and is read again
+	s.Set("/kv/test/a", []byte("two"), "TEXT")
+	if v := get(t, z, "/kv/test/a"); v != "two" {
+		t.Fatalf("got %q after the value changed", v)
+	}
+
+	//a 2.03 carrying a Max-Age renews the entry for that long
+	s.MaxAge = 60
+	get(t, z, "/kv/test/a")
+	n := gets(s, "/kv/test/a")
+	if v := get(t, z, "/kv/test/a"); v != "two" || gets(s, "/kv/test/a") != n {
+		t.Fatalf("got %q, the renewed entry wasn't used", v)
+	}
+}
+
+func TestCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	s := zesttest.NewServer()
+	s.MaxAge = 60
+	c := zest.NewResponseCache(2)
+	z := newCachedClient(t, s, c)
+
+	for _, p := range []string{"a", "b", "c"} {
+		s.Set("/kv/test/"+p, []byte(p), "TEXT")
+	}
+	get(t, z, "/kv/test/a")
+	get(t, z, "/kv/test/b")
+	//a is used again, so b is the one to go
+	get(t, z, "/kv/test/a")
+	get(t, z, "/kv/test/c")
+	if n := c.Len(); n != 2 {
+		t.Fatalf("%d entries, want MaxEntries 2", n)
+	}
+
+	get(t, z, "/kv/test/a")
+	get(t, z, "/kv/test/c")
+	if gets(s, "/kv/test/a") != 1 || gets(s, "/kv/test/c") != 1 {
+		t.Fatal("a recently used entry was evicted")
+	}
+	get(t, z, "/kv/test/b")
+	if n := gets(s, "/kv/test/b"); n != 2 {
+		t.Fatalf("b was read %d times, want it evicted and read again", n)
+	}
+}
+
+func TestCacheInvalidatesWritesByPrefix(t *testing.T) {
+	s := zesttest.NewServer()
+	s.MaxAge = 60
+	c := zest.NewResponseCache(0)
+	z := newCachedClient(t, s, c)
+
+	paths := []string{"/kv/test", "/kv/test/a", "/kv/test/a/b", "/kv/testing"}
+	for _, p := range paths {
+		s.Set(p, []byte(p), "TEXT")
+		get(t, z, p)
+	}
+
+	if _, err := z.Post("", "/kv/test/a", []byte("new"), "TEXT"); err != nil {
+		t.Fatal(err)
+	}
+	//the write and what is below it go, not the parent or a sibling sharing the prefix
+	if n := c.Len(); n != 2 {
+		t.Fatalf("%d entries after a write, want 2", n)
+	}
+	if v := get(t, z, "/kv/test/a"); v != "new" {
+		t.Fatalf("got %q after writing it", v)
+	}
+	for _, p := range []string{"/kv/test", "/kv/testing"} {
+		get(t, z, p)
+		if n := gets(s, p); n != 1 {
+			t.Fatalf("%s was read %d times, its entry was dropped", p, n)
+		}
+	}
+
+	if err := z.Delete("", "/kv/test", "TEXT"); err != nil {
+		t.Fatal(err)
+	}
+	if n := c.Len(); n != 1 {
+		t.Fatalf("%d entries after a delete, want only /kv/testing", n)
+	}
+}
+
+func TestCacheInvalidateOnObserve(t *testing.T) {
+	for _, on := range []bool{false, true} {
+		s := zesttest.NewServer()
+		s.MaxAge = 60
+		c := zest.NewResponseCache(0)
+		c.InvalidateOnObserve = on
+		z := newCachedClient(t, s, c)
+		//writes from elsewhere don't pass through z's cache
+		other := newTestClient(t, s)
+
+		s.Set("/kv/test/a", []byte("one"), "TEXT")
+		get(t, z, "/kv/test/a")
+		events, done, err := z.Observe("", "/kv/test/*", "TEXT", zest.ObserveModeData, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := other.Post("", "/kv/test/a", []byte("two"), "TEXT"); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case <-events:
+		case <-time.After(5 * time.Second):
+			t.Fatal("nothing was observed")
+		}
+		close(done)
+
+		want := "one"
+		if on {
+			want = "two"
+		}
+		if v := get(t, z, "/kv/test/a"); v != want {
+			t.Fatalf("InvalidateOnObserve %v: got %q, want %q", on, v, want)
+		}
+	}
+}
+
+func TestCacheIgnoresReadsRacingInvalidation(t *testing.T) {
+	s := zesttest.NewServer()
+	s.MaxAge = 60
+	c := zest.NewResponseCache(0)
+	z := newCachedClient(t, s, c)
+
+	s.Set("/kv/test/a", []byte("one"), "TEXT")
+	//a write lands while the read is with the store
+	s.Handle = func(req zest.Frame) (zest.Frame, bool) {
+		c.Invalidate("/kv/test")
+		return zest.Frame{}, false
+	}
+	get(t, z, "/kv/test/a")
+	if n := c.Len(); n != 0 {
+		t.Fatalf("%d entries, a read that raced an invalidation was kept", n)
+	}
+
+	s.Handle = nil
+	get(t, z, "/kv/test/a")
+	if n := c.Len(); n != 1 {
+		t.Fatalf("%d entries, want the read stored", n)
+	}
+}
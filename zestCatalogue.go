@@ -0,0 +1,274 @@
+package zest
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"net/url"
+	"strings"
+)
+
+//Relations used in Hypercat items by databox
+const (
+	RelContentType  = "urn:X-hypercat:rels:isContentType"
+	RelDescription  = "urn:X-hypercat:rels:hasDescription:en"
+	RelVendor       = "urn:X-databox:rels:hasVendor"
+	RelType         = "urn:X-databox:rels:hasType"
+	RelDatasourceID = "urn:X-databox:rels:hasDatasourceid"
+	RelStoreType    = "urn:X-databox:rels:hasStoreType"
+	RelUnit         = "urn:X-databox:rels:hasUnit"
+	RelLocation     = "urn:X-databox:rels:hasLocation"
+	RelIsActuator   = "urn:X-databox:rels:isActuator"
+)
+
+//cataloguePath is where a store publishes its Hypercat catalogue
+const cataloguePath = "/cat"
+
+//Relation is one rel/val pair of Hypercat metadata. Values that aren't JSON
+//strings, such as the boolean of isActuator, are kept as their JSON text and
+//written back unchanged.
+type Relation struct {
+	Rel string
+	Val string
+
+	raw bool
+}
+
+type jsonRelation struct {
+	Rel string          `json:"rel"`
+	Val json.RawMessage `json:"val"`
+}
+
+func (r *Relation) UnmarshalJSON(data []byte) error {
+	var jr jsonRelation
+	err := json.Unmarshal(data, &jr)
+	if err != nil {
+		return err
+	}
+	r.Rel = jr.Rel
+	r.Val = ""
+	r.raw = false
+	if len(jr.Val) == 0 {
+		return nil
+	}
+	err = json.Unmarshal(jr.Val, &r.Val)
+	if err != nil {
+		r.Val = string(jr.Val)
+		r.raw = true
+	}
+	return nil
+}
+
+func (r Relation) MarshalJSON() ([]byte, error) {
+	val := json.RawMessage(r.Val)
+	if !r.raw {
+		var err error
+		val, err = json.Marshal(r.Val)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return json.Marshal(jsonRelation{Rel: r.Rel, Val: val})
+}
+
+//CatalogueItem describes a datasource, Href is where it lives, e.g.
+//tcp://store:5555/ts/temperature
+type CatalogueItem struct {
+	Metadata []Relation `json:"item-metadata"`
+	Href     string     `json:"href"`
+}
+
+//Get returns the value of the first rel relation
+func (i CatalogueItem) Get(rel string) (string, bool) {
+	for _, r := range i.Metadata {
+		if r.Rel == rel {
+			return r.Val, true
+		}
+	}
+	return "", false
+}
+
+//Set replaces the value of rel, or adds it
+func (i *CatalogueItem) Set(rel string, val string) {
+	for n := range i.Metadata {
+		if i.Metadata[n].Rel == rel {
+			i.Metadata[n] = Relation{Rel: rel, Val: val}
+			return
+		}
+	}
+	i.Metadata = append(i.Metadata, Relation{Rel: rel, Val: val})
+}
+
+func (i CatalogueItem) get(rel string) string {
+	v, _ := i.Get(rel)
+	return v
+}
+
+//ContentType is the media type of the datasource's values
+func (i CatalogueItem) ContentType() string { return i.get(RelContentType) }
+
+//Description is the item's English description
+func (i CatalogueItem) Description() string { return i.get(RelDescription) }
+
+//Vendor is who provides the datasource
+func (i CatalogueItem) Vendor() string { return i.get(RelVendor) }
+
+//DatasourceType is the kind of data, e.g. temperature
+func (i CatalogueItem) DatasourceType() string { return i.get(RelType) }
+
+//DatasourceID is the datasource's name in its store
+func (i CatalogueItem) DatasourceID() string { return i.get(RelDatasourceID) }
+
+//StoreType is the kind of store holding the datasource, e.g. kv or ts
+func (i CatalogueItem) StoreType() string { return i.get(RelStoreType) }
+
+//Unit is the unit of the values
+func (i CatalogueItem) Unit() string { return i.get(RelUnit) }
+
+//Location is where the data comes from
+func (i CatalogueItem) Location() string { return i.get(RelLocation) }
+
+//IsActuator reports whether writing to the datasource controls a device
+func (i CatalogueItem) IsActuator() bool { return i.get(RelIsActuator) == "true" }
+
+//Catalogue is a Hypercat catalogue as published at /cat
+type Catalogue struct {
+	Metadata []Relation      `json:"catalogue-metadata"`
+	Items    []CatalogueItem `json:"items"`
+}
+
+//Filter returns a catalogue of the items with a rel relation equal to val,
+//or with any value when val is empty. Filters can be chained.
+func (c Catalogue) Filter(rel string, val string) Catalogue {
+	out := Catalogue{Metadata: c.Metadata}
+	for _, item := range c.Items {
+		v, ok := item.Get(rel)
+		if ok && (val == "" || v == val) {
+			out.Items = append(out.Items, item)
+		}
+	}
+	return out
+}
+
+//Catalogue fetches and decodes the store's Hypercat catalogue
+func (z *ZestClient) Catalogue(token string) (Catalogue, error) {
+	var c Catalogue
+	resp, err := z.Get(token, cataloguePath, "JSON")
+	if err != nil {
+		return c, err
+	}
+	err = json.Unmarshal(resp, &c)
+	if err != nil {
+		return c, errors.New("decoding catalogue " + err.Error())
+	}
+	return c, nil
+}
+
+//RegisterItem adds a datasource item to the store's catalogue
+func (z *ZestClient) RegisterItem(token string, item CatalogueItem) error {
+	if item.Href == "" {
+		return errors.New("catalogue item has no href")
+	}
+	payload, err := json.Marshal(item)
+	if err != nil {
+		return err
+	}
+	_, err = z.Post(token, cataloguePath, payload, "JSON")
+	return err
+}
+
+//KV returns a client for the key value datasource item describes
+func (z *ZestClient) KV(token string, item CatalogueItem) (*KVClient, error) {
+	c, path, owned, err := z.resolve(item, "/kv/")
+	if err != nil {
+		return nil, err
+	}
+	kv := NewKVClient(c, token, path, itemFormat(item))
+	kv.owned = owned
+	return kv, nil
+}
+
+//TS returns a client for the time series datasource item describes
+func (z *ZestClient) TS(token string, item CatalogueItem) (*TSClient, error) {
+	c, path, owned, err := z.resolve(item, "/ts/")
+	if err != nil {
+		return nil, err
+	}
+	ts := NewTSClient(c, token, path, itemFormat(item))
+	ts.owned = owned
+	return ts, nil
+}
+
+//resolve finds the client and path for item's href. A datasource in the store
+//z talks to uses z, one in another store gets a new client with z's keys,
+//router port, block size, token checking and cache, which reports owned so it
+//is closed with the datasource. A transport set with SetTransport stands in
+//for the network, so it is shared too.
+func (z *ZestClient) resolve(item CatalogueItem, kind string) (*ZestClient, string, bool, error) {
+	u, err := url.Parse(item.Href)
+	if err != nil {
+		return nil, "", false, errors.New("bad href " + item.Href + " " + err.Error())
+	}
+	if u.Scheme != "tcp" || u.Host == "" {
+		return nil, "", false, errors.New("href " + item.Href + " is not a tcp:// Zest address")
+	}
+	if !strings.HasPrefix(u.Path, kind) {
+		return nil, "", false, errors.New("href " + item.Href + " is not a " + strings.Trim(kind, "/") + " datasource")
+	}
+
+	endpoint := "tcp://" + u.Host
+	if endpoint == z.Endpoint {
+		return z, u.Path, false, nil
+	}
+
+	dealerPort := "5556"
+	if d, err := url.Parse(z.DealerEndpoint); err == nil && d.Port() != "" {
+		dealerPort = d.Port()
+	}
+	c, err := New(endpoint, "tcp://"+net.JoinHostPort(u.Hostname(), dealerPort), z.serverKey, z.enableLogging)
+	if err != nil {
+		return nil, "", false, err
+	}
+	z.mu.Lock()
+	secret, blockSize, checkTokens, cache, transport := z.clientSecret, z.blockSize, z.checkTokens, z.cache, z.transport
+	z.mu.Unlock()
+	if secret != "" {
+		err = c.SetClientKey(secret)
+		if err != nil {
+			c.Close()
+			return nil, "", false, err
+		}
+	}
+	c.blockSize = blockSize
+	c.checkTokens = checkTokens
+	c.cache = cache
+	if _, ok := transport.(zmqTransport); transport != nil && !ok {
+		c.transport = sharedTransport{transport}
+	}
+	return c, u.Path, true, nil
+}
+
+//sharedTransport is a transport lent to another client, closing that client
+//leaves it open for its owner
+type sharedTransport struct {
+	Transport
+}
+
+func (sharedTransport) Close() error {
+	return nil
+}
+
+//itemFormat is the content format for an item's content type, JSON if it has none
+func itemFormat(item CatalogueItem) string {
+	ct := item.ContentType()
+	if f, ok := LookupContentFormat(ct); ok {
+		return f.Name
+	}
+	switch {
+	case ct == "" || strings.Contains(ct, "json"):
+		return "JSON"
+	case strings.HasPrefix(ct, "text/"):
+		return "TEXT"
+	}
+	return "BINARY"
+}
@@ -0,0 +1,183 @@
+package zest_test
+
+import (
+	"errors"
+	"testing"
+
+	zest "github.com/me-box/goZestClient"
+	"github.com/me-box/goZestClient/macaroon"
+	"github.com/me-box/goZestClient/zesttest"
+)
+
+func item(href string, rels ...string) zest.CatalogueItem {
+	i := zest.CatalogueItem{Href: href}
+	for n := 0; n+1 < len(rels); n += 2 {
+		i.Set(rels[n], rels[n+1])
+	}
+	return i
+}
+
+func TestCatalogueFilter(t *testing.T) {
+	c := zest.Catalogue{Items: []zest.CatalogueItem{
+		item("tcp://store:5555/ts/temp", zest.RelType, "temperature", zest.RelStoreType, "ts"),
+		item("tcp://store:5555/kv/lights", zest.RelType, "light", zest.RelStoreType, "kv", zest.RelIsActuator, "true"),
+		item("tcp://store:5555/ts/humidity", zest.RelType, "humidity", zest.RelStoreType, "ts"),
+		item("tcp://store:5555/kv/notes"),
+	}}
+
+	hrefs := func(c zest.Catalogue) []string {
+		var h []string
+		for _, i := range c.Items {
+			h = append(h, i.Href)
+		}
+		return h
+	}
+	cases := []struct {
+		name string
+		got  zest.Catalogue
+		want []string
+	}{
+		{"value", c.Filter(zest.RelStoreType, "ts"), []string{"tcp://store:5555/ts/temp", "tcp://store:5555/ts/humidity"}},
+		{"any value", c.Filter(zest.RelType, ""), []string{"tcp://store:5555/ts/temp", "tcp://store:5555/kv/lights", "tcp://store:5555/ts/humidity"}},
+		{"chained", c.Filter(zest.RelStoreType, "ts").Filter(zest.RelType, "humidity"), []string{"tcp://store:5555/ts/humidity"}},
+		{"no match", c.Filter(zest.RelIsActuator, "false"), nil},
+	}
+	for _, tc := range cases {
+		got := hrefs(tc.got)
+		if len(got) != len(tc.want) {
+			t.Errorf("%s: got %v, want %v", tc.name, got, tc.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != tc.want[i] {
+				t.Errorf("%s: got %v, want %v", tc.name, got, tc.want)
+			}
+		}
+	}
+	if !c.Items[1].IsActuator() || c.Items[0].IsActuator() {
+		t.Error("IsActuator doesn't follow the isActuator relation")
+	}
+}
+
+//closeCounter counts how often the transport is closed
+type closeCounter struct {
+	*zesttest.Server
+	closes int
+}
+
+func (c *closeCounter) Close() error {
+	c.closes++
+	return nil
+}
+
+func TestResolveAnotherStore(t *testing.T) {
+	s := zesttest.NewServer()
+	s.MaxAge = 60
+	transport := &closeCounter{Server: s}
+	z, err := zest.New("tcp://store-a:5555", "tcp://store-a:5556", "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	z.SetTransport(transport)
+	cache := zest.NewResponseCache(0)
+	z.SetCache(cache)
+
+	kv, err := z.KV("", item("tcp://store-b:5555/kv/lights", zest.RelContentType, "text/plain"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	//the new client goes through the parent's transport and cache
+	if err := kv.Put("hall", []byte("on")); err != nil {
+		t.Fatal(err)
+	}
+	if v, ok := s.Value("/kv/lights/hall"); !ok || string(v) != "on" {
+		t.Fatalf("the write didn't reach the transport, store has %q", v)
+	}
+	kv.Get("hall")
+	kv.Get("hall")
+	if n := gets(s, "/kv/lights/hall"); n != 1 {
+		t.Fatalf("read %d times, want the second read from the cache", n)
+	}
+	if cache.Len() != 1 {
+		t.Fatalf("%d entries in the parent's cache", cache.Len())
+	}
+	//entries are kept apart by store
+	z.Get("", "/kv/lights/hall", "TEXT")
+	if cache.Len() != 2 {
+		t.Fatalf("%d entries, want one per store", cache.Len())
+	}
+
+	//closing it leaves the parent's transport open
+	if err := kv.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if transport.closes != 0 {
+		t.Fatal("closing the datasource closed the parent's transport")
+	}
+	if err := z.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if transport.closes != 1 {
+		t.Fatalf("closed %d times, want once with the parent", transport.closes)
+	}
+}
+
+func TestResolveChecksTokens(t *testing.T) {
+	s := zesttest.NewServer()
+	z, err := zest.New("tcp://store-a:5555", "tcp://store-a:5556", "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	z.SetTransport(s)
+	z.SetCheckTokens(true)
+	s.AddPoint("/ts/temp", 1500000000000, []byte(`{"value":21}`))
+
+	token := `{"v":2,"i":"id","c":[{"i":"target = store-b"},{"i":"method = GET"}]}`
+	ts, err := z.TS(token, item("tcp://store-b:5555/ts/temp"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ts.Close()
+	if _, err := ts.Latest(); err != nil {
+		t.Fatalf("a token for store-b was refused for it: %v", err)
+	}
+	err = ts.Write([]byte(`{"value":1}`))
+	var caveat *macaroon.CaveatError
+	if !errors.As(err, &caveat) || caveat.Key != "method" {
+		t.Fatalf("got %v, want the method caveat to fail", err)
+	}
+	if n := len(s.Requests()); n != 1 {
+		t.Fatalf("%d requests sent, the refused write shouldn't be", n)
+	}
+}
+
+func TestResolveSameStore(t *testing.T) {
+	s := zesttest.NewServer()
+	z, err := zest.New("tcp://store-a:5555", "tcp://store-a:5556", "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	z.SetTransport(s)
+
+	kv, err := z.KV("", item("tcp://store-a:5555/kv/lights"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	//a datasource in the same store uses z itself, which stays open
+	kv.Close()
+	if _, err := z.Get("", "/kv/lights/hall", "JSON"); err != nil {
+		t.Fatalf("z was closed with the datasource: %v", err)
+	}
+}
+
+func TestResolveRejectsBadHrefs(t *testing.T) {
+	z, err := zest.New("tcp://store-a:5555", "tcp://store-a:5556", "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, href := range []string{"http://store-b/kv/lights", "tcp:///kv/lights", "tcp://store-b:5555/ts/temp", "::"} {
+		if _, err := z.KV("", item(href)); err == nil {
+			t.Errorf("%s was resolved as a key value datasource", href)
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package zest
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"math/rand"
+	"time"
+)
+
+//Conditional requests follow CoAP (RFC 7252). A store may tag each version of
+//a value with an ETag, a write carrying If-Match only succeeds while the value
+//still has that ETag and one carrying If-None-Match only if there is no value
+//yet. A write whose condition fails is answered with 4.12 Precondition Failed.
+const (
+	optionIfMatch     = 1
+	optionETag        = 4
+	optionIfNoneMatch = 5
+)
+
+const (
+	//casRetries is how many times CompareAndSwap tries again after a 4.12
+	casRetries = 5
+	//casBackoff is the longest wait before the first retry, it doubles with each one
+	casBackoff = 20 * time.Millisecond
+)
+
+//ErrCompareFailed is returned by CompareAndSwap when the value isn't the one expected
+var ErrCompareFailed = errors.New("value does not match the expected value")
+
+//IsPreconditionFailed reports whether err is a 4.12 answer to a conditional request
+func IsPreconditionFailed(err error) bool {
+	if be, ok := err.(*BlockError); ok {
+		err = be.Err
+	}
+	respErr, ok := err.(*ResponseError)
+	return ok && respErr.Code == 140
+}
+
+//GetWithETag reads the value at path like Get and also returns its ETag, nil
+//if the store doesn't send one
+func (z *ZestClient) GetWithETag(token string, path string, contentFormat string) ([]byte, []byte, error) {
+	return z.getWithETag(context.Background(), token, path, contentFormat)
+}
+
+func (z *ZestClient) getWithETag(ctx context.Context, token string, path string, contentFormat string) ([]byte, []byte, error) {
+
+	z.log("Getting with ETag")
+
+	err := checkContentFormatFormat(contentFormat)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var buf bytes.Buffer
+	_, resp, err := z.getBlocks(ctx, token, path, &buf, contentFormat, z.getBlockSize(), 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	var etag []byte
+	if v, ok := resp.option(optionETag); ok {
+		etag = []byte(v)
+	}
+	return buf.Bytes(), etag, nil
+}
+
+//PostIfMatch writes payload to path only if the value there still has etag,
+//otherwise it fails with a 4.12 *ResponseError, see IsPreconditionFailed
+func (z *ZestClient) PostIfMatch(token string, path string, payload []byte, contentFormat string, etag []byte) ([]byte, error) {
+	if len(etag) == 0 {
+		return nil, errors.New("If-Match needs an ETag")
+	}
+	return z.post(context.Background(), token, path, payload, contentFormat, zestOptions{Number: optionIfMatch, Value: string(etag)})
+}
+
+//PostIfNoneMatch writes payload to path only if there is no value there yet,
+//otherwise it fails with a 4.12 *ResponseError, see IsPreconditionFailed
+func (z *ZestClient) PostIfNoneMatch(token string, path string, payload []byte, contentFormat string) ([]byte, error) {
+	return z.post(context.Background(), token, path, payload, contentFormat, zestOptions{Number: optionIfNoneMatch})
+}
+
+//CompareAndSwap replaces the value at path with value only if it is currently
+//expected, byte for byte, returning ErrCompareFailed if it isn't. A nil
+//expected means path must have no value, a 4.04 or an answer without an ETag
+//or payload. The value is read with its ETag and written back with If-Match,
+//so a write by someone else in between is never overwritten. When that
+//happens the store answers 4.12 and the swap is tried again from the read, a
+//few times with a growing random wait, before giving up with
+//ErrCompareFailed. The store must send ETags for this to work.
+func (z *ZestClient) CompareAndSwap(token string, path string, expected []byte, value []byte, contentFormat string) error {
+	return z.compareAndSwap(context.Background(), token, path, expected, value, contentFormat)
+}
+
+func (z *ZestClient) compareAndSwap(ctx context.Context, token string, path string, expected []byte, value []byte, contentFormat string) error {
+
+	for attempt := 0; ; attempt++ {
+		current, etag, err := z.getWithETag(ctx, token, path, contentFormat)
+		exists := true
+		if respErr, ok := err.(*ResponseError); ok && respErr.Code == 132 {
+			exists, err = false, nil
+		}
+		if err != nil {
+			return err
+		}
+		//a value, even an empty one, has an ETag, a store that sends none
+		//can only be judged on the value itself
+		if exists && len(etag) == 0 && len(current) == 0 {
+			exists = false
+		}
+
+		if expected == nil {
+			if exists {
+				return ErrCompareFailed
+			}
+			_, err = z.post(ctx, token, path, value, contentFormat, zestOptions{Number: optionIfNoneMatch})
+		} else {
+			if !bytes.Equal(current, expected) {
+				return ErrCompareFailed
+			}
+			if len(etag) == 0 {
+				return errors.New("the store sent no ETag for " + path + ", it can't be swapped safely")
+			}
+			_, err = z.post(ctx, token, path, value, contentFormat, zestOptions{Number: optionIfMatch, Value: string(etag)})
+		}
+
+		if !IsPreconditionFailed(err) {
+			return err
+		}
+		if attempt == casRetries {
+			return ErrCompareFailed
+		}
+		z.log("=> Precondition failed, retrying")
+		time.Sleep(time.Duration(rand.Int63n(int64(casBackoff << uint(attempt)))))
+	}
+}
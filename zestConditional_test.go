@@ -0,0 +1,152 @@
+package zest_test
+
+import (
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	zest "github.com/me-box/goZestClient"
+	"github.com/me-box/goZestClient/zesttest"
+)
+
+//conditionalPosts counts the POSTs carrying option
+func conditionalPosts(s *zesttest.Server, option uint16) int {
+	n := 0
+	for _, req := range s.Requests() {
+		if _, ok := req.Option(option); ok && req.Code == 2 {
+			n++
+		}
+	}
+	return n
+}
+
+func TestCompareAndSwap(t *testing.T) {
+	s := zesttest.NewServer()
+	s.Set("/kv/test/key", []byte("41"), "TEXT")
+	z := newTestClient(t, s)
+
+	err := z.CompareAndSwap("", "/kv/test/key", []byte("41"), []byte("42"), "TEXT")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := s.Value("/kv/test/key"); string(v) != "42" {
+		t.Fatalf("value is %q", v)
+	}
+	if n := conditionalPosts(s, 1); n != 1 {
+		t.Fatalf("sent %d If-Match writes", n)
+	}
+
+	err = z.CompareAndSwap("", "/kv/test/key", []byte("41"), []byte("43"), "TEXT")
+	if err != zest.ErrCompareFailed {
+		t.Fatalf("got %v, want ErrCompareFailed", err)
+	}
+}
+
+func TestCompareAndSwapRetriesAfterPreconditionFailed(t *testing.T) {
+	s := zesttest.NewServer()
+	s.Set("/kv/test/key", []byte("41"), "TEXT")
+	var raced int32
+	s.Handle = func(req zest.Frame) (zest.Frame, bool) {
+		if _, ok := req.Option(1); ok && atomic.AddInt32(&raced, 1) == 1 {
+			//another writer puts the same value back, changing the ETag
+			s.Set("/kv/test/key", []byte("41"), "TEXT")
+		}
+		return zest.Frame{}, false
+	}
+	z := newTestClient(t, s)
+
+	err := z.CompareAndSwap("", "/kv/test/key", []byte("41"), []byte("42"), "TEXT")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := s.Value("/kv/test/key"); string(v) != "42" {
+		t.Fatalf("value is %q", v)
+	}
+	if n := conditionalPosts(s, 1); n != 2 {
+		t.Fatalf("sent %d If-Match writes, want one refused and one retry", n)
+	}
+}
+
+func TestCompareAndSwapGivesUp(t *testing.T) {
+	s := zesttest.NewServer()
+	s.Set("/kv/test/key", []byte("41"), "TEXT")
+	s.Handle = func(req zest.Frame) (zest.Frame, bool) {
+		if req.Code == 2 {
+			return zest.Frame{Code: 140}, true
+		}
+		return zest.Frame{}, false
+	}
+	z := newTestClient(t, s)
+
+	err := z.CompareAndSwap("", "/kv/test/key", []byte("41"), []byte("42"), "TEXT")
+	if err != zest.ErrCompareFailed {
+		t.Fatalf("got %v, want ErrCompareFailed", err)
+	}
+	//the first attempt and five retries
+	if n := conditionalPosts(s, 1); n != 6 {
+		t.Fatalf("sent %d If-Match writes", n)
+	}
+}
+
+func TestCompareAndSwapWithoutETag(t *testing.T) {
+	s := zesttest.NewServer()
+	s.Handle = func(req zest.Frame) (zest.Frame, bool) {
+		if req.Code == 1 {
+			return zest.Frame{Code: 69, Payload: []byte("41")}, true
+		}
+		return zest.Frame{}, false
+	}
+	z := newTestClient(t, s)
+
+	err := z.CompareAndSwap("", "/kv/test/key", []byte("41"), []byte("42"), "TEXT")
+	if err == nil || !strings.Contains(err.Error(), "no ETag") {
+		t.Fatalf("got %v, want a missing ETag error", err)
+	}
+	if n := conditionalPosts(s, 1); n != 0 {
+		t.Fatal("nothing should be written without an ETag")
+	}
+}
+
+func TestCompareAndSwapExpectingNoValue(t *testing.T) {
+	s := zesttest.NewServer()
+	z := newTestClient(t, s)
+
+	err := z.CompareAndSwap("", "/kv/test/key", nil, []byte("1"), "TEXT")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := conditionalPosts(s, 5); n != 1 {
+		t.Fatalf("sent %d If-None-Match writes", n)
+	}
+
+	err = z.CompareAndSwap("", "/kv/test/key", nil, []byte("2"), "TEXT")
+	if err != zest.ErrCompareFailed {
+		t.Fatalf("got %v for a key that exists, want ErrCompareFailed", err)
+	}
+
+	//an empty value still exists, its ETag says so
+	s.Set("/kv/test/empty", []byte{}, "TEXT")
+	err = z.CompareAndSwap("", "/kv/test/empty", nil, []byte("2"), "TEXT")
+	if err != zest.ErrCompareFailed {
+		t.Fatalf("got %v for an empty value, want ErrCompareFailed", err)
+	}
+}
+
+func TestCompareAndSwapExpectingNoValueAfterNotFound(t *testing.T) {
+	s := zesttest.NewServer()
+	s.Handle = func(req zest.Frame) (zest.Frame, bool) {
+		if req.Code == 1 {
+			return zest.Frame{Code: 132}, true
+		}
+		return zest.Frame{}, false
+	}
+	z := newTestClient(t, s)
+
+	err := z.CompareAndSwap("", "/kv/test/key", nil, []byte("1"), "TEXT")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := s.Value("/kv/test/key"); string(v) != "1" {
+		t.Fatalf("value is %q", v)
+	}
+}
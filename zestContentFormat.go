@@ -0,0 +1,223 @@
+package zest
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/golang/protobuf/proto"
+)
+
+//Codec turns Go values into payloads of a content format and back again
+type Codec interface {
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
+
+//ContentFormat maps a name and media type to the numeric Content-Format
+//(option 12) sent on the wire. Codec may be nil for formats only used with raw payloads.
+type ContentFormat struct {
+	Name      string
+	MediaType string
+	ID        uint16
+	Codec     Codec
+}
+
+var formatsMu sync.RWMutex
+var formatsByName = map[string]ContentFormat{}
+var formatsByID = map[uint16]ContentFormat{}
+
+func init() {
+	RegisterContentFormat(ContentFormat{Name: "TEXT", MediaType: "text/plain;charset=utf-8", ID: 0, Codec: textCodec{}})
+	RegisterContentFormat(ContentFormat{Name: "BINARY", MediaType: "application/octet-stream", ID: 42, Codec: binaryCodec{}})
+	RegisterContentFormat(ContentFormat{Name: "JSON", MediaType: "application/json", ID: 50, Codec: jsonCodec{}})
+	RegisterContentFormat(ContentFormat{Name: "CBOR", MediaType: "application/cbor", ID: 60, Codec: cborCodec{}})
+	RegisterContentFormat(ContentFormat{Name: "SENML+JSON", MediaType: "application/senml+json", ID: 110, Codec: jsonCodec{}})
+	RegisterContentFormat(ContentFormat{Name: "SENML+CBOR", MediaType: "application/senml+cbor", ID: 112, Codec: cborCodec{senml: true}})
+	//protobuf has no registered CoAP number so it uses one from the experimental range
+	RegisterContentFormat(ContentFormat{Name: "PROTOBUF", MediaType: "application/x-protobuf", ID: 65000, Codec: protobufCodec{}})
+}
+
+//formatKey normalises a name or media type for lookups, media type parameters
+//such as charset are ignored
+func formatKey(s string) string {
+	if i := strings.Index(s, ";"); i >= 0 {
+		s = s[:i]
+	}
+	return strings.ToUpper(strings.TrimSpace(s))
+}
+
+//RegisterContentFormat adds f to the registry so it can be used by name or
+//media type in any request. Registering an existing name, media type or ID replaces it.
+func RegisterContentFormat(f ContentFormat) error {
+	if f.Name == "" {
+		return errors.New("content format needs a name")
+	}
+
+	formatsMu.Lock()
+	defer formatsMu.Unlock()
+
+	formatsByName[formatKey(f.Name)] = f
+	if f.MediaType != "" {
+		formatsByName[formatKey(f.MediaType)] = f
+	}
+	formatsByID[f.ID] = f
+
+	return nil
+}
+
+//LookupContentFormat finds a registered content format by name or media type, ignoring case
+func LookupContentFormat(name string) (ContentFormat, bool) {
+	formatsMu.RLock()
+	defer formatsMu.RUnlock()
+
+	f, ok := formatsByName[formatKey(name)]
+	return f, ok
+}
+
+//ContentFormatByID finds a registered content format by its numeric Content-Format
+func ContentFormatByID(id uint16) (ContentFormat, bool) {
+	formatsMu.RLock()
+	defer formatsMu.RUnlock()
+
+	f, ok := formatsByID[id]
+	return f, ok
+}
+
+func codecFor(format string) (Codec, error) {
+	f, ok := LookupContentFormat(format)
+	if !ok {
+		return nil, errors.New("Unsupported Content format: " + format)
+	}
+	if f.Codec == nil {
+		return nil, errors.New("No codec for content format: " + format)
+	}
+	return f.Codec, nil
+}
+
+//PostValue encodes v with the codec of contentFormat and posts it to path
+func (z *ZestClient) PostValue(token string, path string, v interface{}, contentFormat string) error {
+
+	codec, err := codecFor(contentFormat)
+	if err != nil {
+		return err
+	}
+
+	payload, err := codec.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	_, err = z.Post(token, path, payload, contentFormat)
+	return err
+}
+
+//GetValue reads path and decodes the response into v with the codec of contentFormat
+func (z *ZestClient) GetValue(token string, path string, contentFormat string, v interface{}) error {
+
+	codec, err := codecFor(contentFormat)
+	if err != nil {
+		return err
+	}
+
+	payload, err := z.Get(token, path, contentFormat)
+	if err != nil {
+		return err
+	}
+
+	return codec.Unmarshal(payload, v)
+}
+
+type textCodec struct{}
+
+func (textCodec) Marshal(v interface{}) ([]byte, error) {
+	switch t := v.(type) {
+	case string:
+		return []byte(t), nil
+	case []byte:
+		return t, nil
+	case fmt.Stringer:
+		return []byte(t.String()), nil
+	}
+	return nil, fmt.Errorf("text codec can't marshal %T", v)
+}
+
+func (textCodec) Unmarshal(data []byte, v interface{}) error {
+	switch t := v.(type) {
+	case *string:
+		*t = string(data)
+		return nil
+	case *[]byte:
+		*t = append((*t)[:0], data...)
+		return nil
+	}
+	return fmt.Errorf("text codec can't unmarshal into %T", v)
+}
+
+type binaryCodec struct{}
+
+func (binaryCodec) Marshal(v interface{}) ([]byte, error) {
+	if b, ok := v.([]byte); ok {
+		return b, nil
+	}
+	return nil, fmt.Errorf("binary codec can't marshal %T", v)
+}
+
+func (binaryCodec) Unmarshal(data []byte, v interface{}) error {
+	if b, ok := v.(*[]byte); ok {
+		*b = append((*b)[:0], data...)
+		return nil
+	}
+	return fmt.Errorf("binary codec can't unmarshal into %T", v)
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+type protobufCodec struct{}
+
+func (protobufCodec) Marshal(v interface{}) ([]byte, error) {
+	m, ok := v.(proto.Message)
+	if !ok {
+		return nil, fmt.Errorf("protobuf codec can't marshal %T", v)
+	}
+	return proto.Marshal(m)
+}
+
+func (protobufCodec) Unmarshal(data []byte, v interface{}) error {
+	m, ok := v.(proto.Message)
+	if !ok {
+		return fmt.Errorf("protobuf codec can't unmarshal into %T", v)
+	}
+	return proto.Unmarshal(data, m)
+}
+
+func checkContentFormatFormat(format string) error {
+
+	_, ok := LookupContentFormat(format)
+	if !ok {
+		return errors.New("Unsupported Content format: " + format)
+	}
+
+	return nil
+}
+
+func contentFormatToInt(format string) uint16 {
+
+	f, ok := LookupContentFormat(format)
+	if !ok {
+		return 0
+	}
+
+	return f.ID
+}
+
@@ -0,0 +1,297 @@
+package zest
+
+import (
+	"encoding/json"
+	"errors"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+//KVClient reads and writes the keys of one key value datasource, e.g. /kv/sensors
+type KVClient struct {
+	Client *ZestClient
+	Token  string
+	Path   string
+	Format string
+
+	//owned is set when the client was made for this datasource and is closed with it
+	owned bool
+	//query is sent as Uri-Query options with every request
+	query url.Values
+}
+
+//NewKVClient returns a KVClient for the datasource at path in the store z talks to
+func NewKVClient(z *ZestClient, token string, path string, contentFormat string) *KVClient {
+	return &KVClient{Client: z, Token: token, Path: strings.TrimSuffix(path, "/"), Format: contentFormat}
+}
+
+//WithQuery returns a copy of kv that adds query to every request, on top of
+//any it already has. Closing the copy leaves the client open.
+func (kv *KVClient) WithQuery(query url.Values) *KVClient {
+	c := *kv
+	c.owned = false
+	c.query = mergeQuery(kv.query, query)
+	return &c
+}
+
+//Get reads the value of key
+func (kv *KVClient) Get(key string) ([]byte, error) {
+	return kv.Client.Get(kv.Token, kv.path("/"+key), kv.Format)
+}
+
+//Put writes value to key
+func (kv *KVClient) Put(key string, value []byte) error {
+	_, err := kv.Client.Post(kv.Token, kv.path("/"+key), value, kv.Format)
+	return err
+}
+
+//GetWithETag reads the value of key and its ETag
+func (kv *KVClient) GetWithETag(key string) ([]byte, []byte, error) {
+	return kv.Client.GetWithETag(kv.Token, kv.path("/"+key), kv.Format)
+}
+
+//PutIfMatch writes value to key only if its ETag is still etag
+func (kv *KVClient) PutIfMatch(key string, value []byte, etag []byte) error {
+	_, err := kv.Client.PostIfMatch(kv.Token, kv.path("/"+key), value, kv.Format, etag)
+	return err
+}
+
+//PutIfAbsent writes value to key only if key has no value yet
+func (kv *KVClient) PutIfAbsent(key string, value []byte) error {
+	_, err := kv.Client.PostIfNoneMatch(kv.Token, kv.path("/"+key), value, kv.Format)
+	return err
+}
+
+//CompareAndSwap replaces the value of key with value only if it is expected,
+//see ZestClient.CompareAndSwap
+func (kv *KVClient) CompareAndSwap(key string, expected []byte, value []byte) error {
+	return kv.Client.CompareAndSwap(kv.Token, kv.path("/"+key), expected, value, kv.Format)
+}
+
+//Delete removes key
+func (kv *KVClient) Delete(key string) error {
+	return kv.Client.Delete(kv.Token, kv.path("/"+key), kv.Format)
+}
+
+//Keys lists the keys of the datasource in order
+func (kv *KVClient) Keys() ([]string, error) {
+	resp, err := kv.Client.Get(kv.Token, kv.path("/keys"), "JSON")
+	if err != nil {
+		return nil, err
+	}
+	var keys []string
+	err = json.Unmarshal(resp, &keys)
+	if err != nil {
+		return nil, errors.New("listing keys " + err.Error())
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+//Observe watches key, or every key when key is empty
+func (kv *KVClient) Observe(key string, observeMode ObserveMode, timeout uint32) (<-chan []byte, chan struct{}, error) {
+	if key == "" {
+		key = "*"
+	}
+	return kv.Client.Observe(kv.Token, kv.path("/"+key), kv.Format, observeMode, timeout)
+}
+
+//Close closes the underlying client if it was made for this datasource
+func (kv *KVClient) Close() error {
+	if kv.owned {
+		return kv.Client.Close()
+	}
+	return nil
+}
+
+func (kv *KVClient) path(suffix string) string {
+	return QueryPath(kv.Path+suffix, kv.query)
+}
+
+//TSClient writes and queries one time series datasource, e.g. /ts/temperature.
+//Values are read as JSON and returned oldest first as Records with a Timestamp.
+type TSClient struct {
+	Client *ZestClient
+	Token  string
+	Path   string
+	Format string
+
+	//owned is set when the client was made for this datasource and is closed with it
+	owned bool
+	//query is sent as Uri-Query options with every request
+	query url.Values
+}
+
+//Aggregation combines the values of a time series query into one number
+type Aggregation string
+
+const (
+	AggregateSum    Aggregation = "sum"
+	AggregateCount  Aggregation = "count"
+	AggregateMin    Aggregation = "min"
+	AggregateMax    Aggregation = "max"
+	AggregateMean   Aggregation = "mean"
+	AggregateMedian Aggregation = "median"
+	AggregateSD     Aggregation = "sd"
+)
+
+//NewTSClient returns a TSClient for the datasource at path in the store z talks to
+func NewTSClient(z *ZestClient, token string, path string, contentFormat string) *TSClient {
+	return &TSClient{Client: z, Token: token, Path: strings.TrimSuffix(path, "/"), Format: contentFormat}
+}
+
+//WithQuery returns a copy of ts that adds query to every request, on top of
+//any it already has. Closing the copy leaves the client open.
+func (ts *TSClient) WithQuery(query url.Values) *TSClient {
+	c := *ts
+	c.owned = false
+	c.query = mergeQuery(ts.query, query)
+	return &c
+}
+
+//Where returns a copy of ts whose queries only include values with tag set to
+//value, sent as a filter=tag=value Uri-Query. Calls can be chained to require several tags.
+func (ts *TSClient) Where(tag string, value string) *TSClient {
+	return ts.WithQuery(url.Values{"filter": {tag + "=" + value}})
+}
+
+//Write adds value to the series with the store's current time
+func (ts *TSClient) Write(value []byte) error {
+	_, err := ts.Client.Post(ts.Token, ts.path(""), value, ts.Format)
+	return err
+}
+
+//WriteAt adds value to the series at timestamp, in milliseconds since the epoch
+func (ts *TSClient) WriteAt(timestamp int64, value []byte) error {
+	_, err := ts.Client.Post(ts.Token, ts.path("/at/"+strconv.FormatInt(timestamp, 10)), value, ts.Format)
+	return err
+}
+
+//Latest reads the newest value
+func (ts *TSClient) Latest() (Record, error) {
+	return ts.one("/latest")
+}
+
+//Earliest reads the oldest value
+func (ts *TSClient) Earliest() (Record, error) {
+	return ts.one("/earliest")
+}
+
+//LastN reads the n newest values
+func (ts *TSClient) LastN(n int) ([]Record, error) {
+	return ts.read("/last/" + strconv.Itoa(n))
+}
+
+//FirstN reads the n oldest values
+func (ts *TSClient) FirstN(n int) ([]Record, error) {
+	return ts.read("/first/" + strconv.Itoa(n))
+}
+
+//Since reads every value from timestamp on
+func (ts *TSClient) Since(timestamp int64) ([]Record, error) {
+	return ts.read("/since/" + strconv.FormatInt(timestamp, 10))
+}
+
+//Range reads the values from from to to inclusive
+func (ts *TSClient) Range(from int64, to int64) ([]Record, error) {
+	return ts.read("/range/" + strconv.FormatInt(from, 10) + "/" + strconv.FormatInt(to, 10))
+}
+
+//AggregateLastN combines the n newest values with fn
+func (ts *TSClient) AggregateLastN(n int, fn Aggregation) (float64, error) {
+	return ts.aggregate("/last/"+strconv.Itoa(n), fn)
+}
+
+//AggregateSince combines every value from timestamp on with fn
+func (ts *TSClient) AggregateSince(timestamp int64, fn Aggregation) (float64, error) {
+	return ts.aggregate("/since/"+strconv.FormatInt(timestamp, 10), fn)
+}
+
+//AggregateRange combines the values from from to to inclusive with fn
+func (ts *TSClient) AggregateRange(from int64, to int64, fn Aggregation) (float64, error) {
+	return ts.aggregate("/range/"+strconv.FormatInt(from, 10)+"/"+strconv.FormatInt(to, 10), fn)
+}
+
+//Observe watches the series for new values
+func (ts *TSClient) Observe(observeMode ObserveMode, timeout uint32) (<-chan []byte, chan struct{}, error) {
+	return ts.Client.Observe(ts.Token, ts.path(""), ts.Format, observeMode, timeout)
+}
+
+//Close closes the underlying client if it was made for this datasource
+func (ts *TSClient) Close() error {
+	if ts.owned {
+		return ts.Client.Close()
+	}
+	return nil
+}
+
+func (ts *TSClient) one(query string) (Record, error) {
+	values, err := ts.read(query)
+	if err != nil {
+		return Record{}, err
+	}
+	if len(values) == 0 {
+		return Record{}, errors.New(ts.Path + " has no values")
+	}
+	return values[0], nil
+}
+
+//read runs a query on the series and decodes the values it returns
+func (ts *TSClient) read(query string) ([]Record, error) {
+	resp, err := ts.Client.Get(ts.Token, ts.path(query), "JSON")
+	if err != nil {
+		return nil, err
+	}
+	var values []Record
+	if trimmed := strings.TrimSpace(string(resp)); strings.HasPrefix(trimmed, "{") {
+		//a single value
+		values = make([]Record, 1)
+		err = json.Unmarshal(resp, &values[0])
+	} else if trimmed != "" {
+		err = json.Unmarshal(resp, &values)
+	}
+	if err != nil {
+		return nil, errors.New("reading " + ts.Path + query + " " + err.Error())
+	}
+	//the store returns newest first
+	sort.SliceStable(values, func(i, j int) bool { return values[i].Timestamp < values[j].Timestamp })
+	return values, nil
+}
+
+//aggregate runs a query with an aggregate Uri-Query and reads the result, which
+//the store sends as {"result": n} or a bare number
+func (ts *TSClient) aggregate(query string, fn Aggregation) (float64, error) {
+	resp, err := ts.Client.Get(ts.Token, QueryPath(ts.path(query), url.Values{"aggregate": {string(fn)}}), "JSON")
+	if err != nil {
+		return 0, err
+	}
+	var result struct {
+		Result float64 `json:"result"`
+	}
+	err = json.Unmarshal(resp, &result)
+	if err != nil {
+		err = json.Unmarshal(resp, &result.Result)
+	}
+	if err != nil {
+		return 0, errors.New("reading " + string(fn) + " of " + ts.Path + query + " " + err.Error())
+	}
+	return result.Result, nil
+}
+
+func (ts *TSClient) path(suffix string) string {
+	return QueryPath(ts.Path+suffix, ts.query)
+}
+
+//mergeQuery returns the arguments of a followed by those of b
+func mergeQuery(a url.Values, b url.Values) url.Values {
+	out := url.Values{}
+	for k, vs := range a {
+		out[k] = append(out[k], vs...)
+	}
+	for k, vs := range b {
+		out[k] = append(out[k], vs...)
+	}
+	return out
+}
@@ -0,0 +1,328 @@
+package zest
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+//codeNames names the request methods and response codes used by Zest
+var codeNames = map[uint8]string{
+	1:   "GET",
+	2:   "POST",
+	3:   "PUT",
+	4:   "DELETE",
+	65:  "2.01 Created",
+	66:  "2.02 Deleted",
+	67:  "2.03 Valid",
+	68:  "2.04 Changed",
+	69:  "2.05 Content",
+	95:  "2.31 Continue",
+	128: "4.00 Bad Request",
+	129: "4.01 Unauthorized",
+	130: "4.02 Bad Option",
+	131: "4.03 Forbidden",
+	132: "4.04 Not Found",
+	133: "4.05 Method Not Allowed",
+	134: "4.06 Not Acceptable",
+	136: "4.08 Request Entity Incomplete",
+	140: "4.12 Precondition Failed",
+	141: "4.13 Request Entity Too Large",
+	143: "4.15 Unsupported Content-Format",
+	160: "5.00 Internal Server Error",
+	161: "5.01 Not Implemented",
+	162: "5.02 Bad Gateway",
+	163: "5.03 Service Unavailable",
+	164: "5.04 Gateway Timeout",
+}
+
+//optionNames names the options Zest frames carry
+var optionNames = map[uint16]string{
+	1:    "If-Match",
+	3:    "Uri-Host",
+	4:    "ETag",
+	5:    "If-None-Match",
+	6:    "Observe",
+	11:   "Uri-Path",
+	12:   "Content-Format",
+	14:   "Max-Age",
+	15:   "Uri-Query",
+	23:   "Block2",
+	27:   "Block1",
+	28:   "Size2",
+	60:   "Size1",
+	2048: "Server-Key",
+}
+
+//CodeName returns the method or response code name, e.g. POST or 2.05 Content
+func CodeName(code uint8) string {
+	if name, ok := codeNames[code]; ok {
+		return name
+	}
+	return fmt.Sprintf("%d.%02d", code>>5, code&0x1f)
+}
+
+//OptionName returns the registered name of an option number
+func OptionName(number uint16) string {
+	if name, ok := optionNames[number]; ok {
+		return name
+	}
+	return "Option-" + strconv.Itoa(int(number))
+}
+
+//FrameOption is one decoded option, Text is its value in readable form
+type FrameOption struct {
+	Number uint16
+	Name   string
+	Value  []byte
+	Text   string
+}
+
+//Frame is a decoded Zest request or response
+type Frame struct {
+	Code          uint8
+	CodeName      string
+	Token         string
+	Options       []FrameOption
+	ContentFormat string
+	Payload       []byte
+}
+
+//DecodeFrame decodes a raw Zest frame, such as one printed by Hexlog or saved
+//by RecordingTransport
+func DecodeFrame(msg []byte) (Frame, error) {
+	var h zestHeader
+	err := h.Parse(msg)
+	if err != nil {
+		return Frame{}, err
+	}
+
+	f := Frame{Code: h.Code, CodeName: CodeName(h.Code), Token: h.Token, Payload: h.Payload}
+	for _, o := range h.Options {
+		fo := FrameOption{Number: o.Number, Name: OptionName(o.Number), Value: []byte(o.Value)}
+		fo.Text = optionText(o.Number, o.Value)
+		if o.Number == 12 {
+			f.ContentFormat = fo.Text
+		}
+		f.Options = append(f.Options, fo)
+	}
+	return f, nil
+}
+
+//EncodeFrame turns a frame back into bytes, the reverse of DecodeFrame. Only
+//Code, Token, the Number and Value of each option and Payload are used.
+func EncodeFrame(f Frame) ([]byte, error) {
+	h := zestHeader{Code: f.Code, Token: f.Token, Payload: f.Payload}
+	for _, o := range f.Options {
+		h.Options = append(h.Options, zestOptions{Number: o.Number, Value: string(o.Value)})
+	}
+	return h.Marshal()
+}
+
+//Option returns the value of the first option with the given number
+func (f Frame) Option(number uint16) ([]byte, bool) {
+	for _, o := range f.Options {
+		if o.Number == number {
+			return o.Value, true
+		}
+	}
+	return nil, false
+}
+
+func optionText(number uint16, value string) string {
+	switch number {
+	case 12:
+		id := uint16(unpackUint(value))
+		if cf, ok := ContentFormatByID(id); ok {
+			return cf.MediaType + " (" + strconv.Itoa(int(id)) + ")"
+		}
+		return strconv.Itoa(int(id))
+	case 14:
+		return strconv.Itoa(int(unpackUint(value))) + "s"
+	case 28, 60:
+		return strconv.Itoa(int(unpackUint(value))) + " bytes"
+	case 23, 27:
+		b, err := parseBlock(value)
+		if err != nil {
+			return hex.EncodeToString([]byte(value))
+		}
+		more := ""
+		if b.more {
+			more = ", more"
+		}
+		return fmt.Sprintf("block %d of %d bytes%s", b.num, b.size(), more)
+	case 1, 4, 5:
+		return hex.EncodeToString([]byte(value))
+	}
+	return printable(value)
+}
+
+//printable returns readable values as they are and anything else as hex
+func printable(value string) string {
+	if !utf8.ValidString(value) {
+		return "0x" + hex.EncodeToString([]byte(value))
+	}
+	for _, r := range value {
+		if r < 0x20 || r == 0x7f {
+			return "0x" + hex.EncodeToString([]byte(value))
+		}
+	}
+	return value
+}
+
+//PayloadText shows the payload according to the frame's content format:
+//JSON and CBOR indented as JSON, text as it is and anything else as a hex dump.
+//Responses often have no Content-Format, their payload is shown as JSON or
+//text if it is valid as either.
+func (f Frame) PayloadText() string {
+	if len(f.Payload) == 0 {
+		return ""
+	}
+
+	id, known := uint16(0), false
+	for _, o := range f.Options {
+		if o.Number == 12 {
+			id, known = uint16(unpackUint(string(o.Value))), true
+		}
+	}
+	if !known {
+		var out bytes.Buffer
+		if json.Indent(&out, f.Payload, "", "  ") == nil {
+			return out.String()
+		}
+		if utf8.Valid(f.Payload) && printable(string(f.Payload)) == string(f.Payload) {
+			return string(f.Payload)
+		}
+		return strings.TrimSuffix(hex.Dump(f.Payload), "\n")
+	}
+
+	switch id {
+	case 0:
+		if utf8.Valid(f.Payload) {
+			return string(f.Payload)
+		}
+	case 50, 110:
+		var out bytes.Buffer
+		if json.Indent(&out, f.Payload, "", "  ") == nil {
+			return out.String()
+		}
+	case 60, 112:
+		var v interface{}
+		if (cborCodec{senml: id == 112}).Unmarshal(f.Payload, &v) == nil {
+			js, err := json.MarshalIndent(v, "", "  ")
+			if err == nil {
+				return string(js)
+			}
+		}
+	}
+	return strings.TrimSuffix(hex.Dump(f.Payload), "\n")
+}
+
+//String lays the frame out one field per line
+func (f Frame) String() string {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "code     %s (%d)\n", f.CodeName, f.Code)
+	if f.Token != "" {
+		fmt.Fprintf(&b, "token    %s\n", printable(f.Token))
+	}
+	for _, o := range f.Options {
+		fmt.Fprintf(&b, "option   %s (%d): %s\n", o.Name, o.Number, o.Text)
+	}
+	if len(f.Payload) > 0 {
+		fmt.Fprintf(&b, "payload  %d bytes\n", len(f.Payload))
+		for _, line := range strings.Split(f.PayloadText(), "\n") {
+			b.WriteString("  " + line + "\n")
+		}
+	}
+	return b.String()
+}
+
+//DumpFrame decodes msg for display, falling back to a hex dump if it doesn't parse
+func DumpFrame(msg []byte) string {
+	f, err := DecodeFrame(msg)
+	if err != nil {
+		return "undecodable frame: " + err.Error() + "\n" + hex.Dump(msg)
+	}
+	return f.String()
+}
+
+//maskToken returns a copy of msg with its token overwritten by *s, so logs
+//can be shared. The length is kept, so the masked frame still decodes.
+func maskToken(msg []byte) []byte {
+	tkl, err := unPack_16(msg[min(len(msg), 2):])
+	if err != nil || tkl == 0 {
+		return msg
+	}
+	end := min(4+int(tkl), len(msg))
+	masked := append([]byte(nil), msg...)
+	for i := 4; i < end; i++ {
+		masked[i] = '*'
+	}
+	return masked
+}
+
+//ParseHex reads a frame written as hex digits, with or without spaces, or in
+//the hex.Dump layout that Hexlog prints
+func ParseHex(s string) ([]byte, error) {
+	var digits bytes.Buffer
+	for _, line := range strings.Split(s, "\n") {
+		line = strings.TrimSpace(line)
+		//hex.Dump lines are an offset, the bytes, then the characters between |s
+		dumped := false
+		if i := strings.Index(line, "|"); i >= 0 {
+			line, dumped = line[:i], true
+		}
+		fields := strings.Fields(line)
+		if dumped && len(fields) > 0 {
+			fields = fields[1:]
+		}
+		for _, f := range fields {
+			digits.WriteString(strings.TrimPrefix(f, "0x"))
+		}
+	}
+	b, err := hex.DecodeString(digits.String())
+	if err != nil {
+		return nil, errors.New("reading hex " + err.Error())
+	}
+	return b, nil
+}
+
+//DumpRecording writes every frame of a recording made by RecordingTransport to
+//w, each headed by its time, kind and ID
+func DumpRecording(r io.Reader, w io.Writer) error {
+	dec := json.NewDecoder(r)
+	for {
+		var f recordedFrame
+		err := dec.Decode(&f)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return errors.New("reading recording " + err.Error())
+		}
+
+		head := fmt.Sprintf("%10.3fms %s #%d", float64(f.Time)/1000, f.Kind, f.ID)
+		if f.Identity != "" {
+			head += " identity " + printable(f.Identity)
+		}
+		if f.Error != "" {
+			head += " error: " + f.Error
+		}
+		_, err = fmt.Fprintln(w, head)
+		if err != nil {
+			return err
+		}
+		if len(f.Frame) > 0 {
+			_, err = fmt.Fprintln(w, DumpFrame(f.Frame))
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
@@ -0,0 +1,273 @@
+package zest
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestMaskToken(t *testing.T) {
+	h := zestHeader{Code: 2, Token: "secret-macaroon", Payload: []byte("secret-macaroon in the payload")}
+	h.Options = append(h.Options, zestOptions{Number: 11, Value: "/kv/test/key"})
+	msg, err := h.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	original := append([]byte(nil), msg...)
+
+	masked := maskToken(msg)
+	if !bytes.Equal(msg, original) {
+		t.Fatal("the frame being logged was changed")
+	}
+	f, err := DecodeFrame(masked)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Token != strings.Repeat("*", len(h.Token)) {
+		t.Fatalf("token %q, want it masked at its length", f.Token)
+	}
+	if path, _ := f.Option(11); string(path) != "/kv/test/key" || string(f.Payload) != string(h.Payload) {
+		t.Fatalf("masking changed more than the token: %s", f)
+	}
+	if dump := DumpFrame(masked); !strings.Contains(dump, "token    "+f.Token+"\n") {
+		t.Fatalf("dump %s", dump)
+	}
+
+	//frames without a token, and ones too short to have one, are left as they are
+	for _, msg := range [][]byte{nil, {1}, {1, 0, 0}, {1, 0, 0, 0}, {1, 0, 0, 9, 'a'}} {
+		if got := maskToken(msg); len(got) != len(msg) || (len(msg) == 5 && got[4] != '*') {
+			t.Errorf("maskToken(%v) = %v", msg, got)
+		}
+	}
+}
+
+func TestDecodeFrame(t *testing.T) {
+	cases := []struct {
+		name    string
+		msg     []byte
+		code    string
+		token   string
+		options []string
+		format  string
+		payload string
+	}{
+		{
+			name:    "request",
+			msg:     []byte{1, 2, 0, 2, 't', 'k', 0, 11, 0, 5, '/', 'k', 'v', '/', 'a', 0, 12, 0, 2, 0, 50},
+			code:    "GET",
+			token:   "tk",
+			options: []string{"Uri-Path /kv/a", "Content-Format application/json (50)"},
+			format:  "application/json (50)",
+		},
+		{
+			name:    "response",
+			msg:     []byte{69, 3, 0, 0, 0, 14, 0, 4, 0, 0, 0, 10, 0, 4, 0, 2, 0xab, 0xcd, 0, 23, 0, 1, 0x2a, 'h', 'i'},
+			code:    "2.05 Content",
+			options: []string{"Max-Age 10s", "ETag abcd", "Block2 block 2 of 64 bytes, more"},
+			payload: "hi",
+		},
+		{
+			name:    "unknown code and option",
+			msg:     []byte{200, 1, 0, 0, 0, 99, 0, 2, 0x00, 0xff},
+			code:    "6.08",
+			options: []string{"Option-99 0x00ff"},
+		},
+		{
+			name:    "empty option at the end",
+			msg:     []byte{65, 1, 0, 0, 0, 4, 0, 0},
+			code:    "2.01 Created",
+			options: []string{"ETag "},
+		},
+	}
+	for _, tc := range cases {
+		f, err := DecodeFrame(tc.msg)
+		if err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+			continue
+		}
+		var options []string
+		for _, o := range f.Options {
+			options = append(options, o.Name+" "+o.Text)
+		}
+		if f.CodeName != tc.code || f.Token != tc.token || f.ContentFormat != tc.format || string(f.Payload) != tc.payload ||
+			strings.Join(options, "|") != strings.Join(tc.options, "|") {
+			t.Errorf("%s: decoded %s", tc.name, f)
+		}
+
+		//encoding the decoded frame gives back the same bytes
+		if msg, err := EncodeFrame(f); err != nil || !bytes.Equal(msg, tc.msg) {
+			t.Errorf("%s: encoded as %v %v", tc.name, msg, err)
+		}
+	}
+}
+
+func TestDecodeFrameErrors(t *testing.T) {
+	cases := []struct {
+		name string
+		msg  []byte
+	}{
+		{"empty", nil},
+		{"short header", []byte{1, 0, 0}},
+		{"token past the end", []byte{1, 0, 0, 5, 'a'}},
+		{"missing option", []byte{1, 1, 0, 0}},
+		{"truncated option header", []byte{1, 1, 0, 0, 0, 11}},
+		{"option length past the end", []byte{1, 1, 0, 0, 0, 11, 0, 9, '/', 'k'}},
+		{"second option missing", []byte{1, 2, 0, 0, 0, 11, 0, 1, '/'}},
+		{"longest option length", []byte{1, 1, 0, 0, 0, 11, 0xff, 0xff, '/'}},
+	}
+	for _, tc := range cases {
+		if f, err := DecodeFrame(tc.msg); err == nil {
+			t.Errorf("%s: decoded %s", tc.name, f)
+		}
+		if dump := DumpFrame(tc.msg); !strings.HasPrefix(dump, "undecodable frame: ") {
+			t.Errorf("%s: dumped %s", tc.name, dump)
+		}
+	}
+}
+
+func TestParseHex(t *testing.T) {
+	frame := []byte{69, 1, 0, 0, 0, 11, 0, 13, '/', 'k', 'v', '/', 'a', 'b', 'c', 'd', 'e', 'f', '|', '0', '1', 'a', 'b'}
+	cases := []struct {
+		name string
+		in   string
+		want []byte
+	}{
+		{"digits", "0102ff", []byte{1, 2, 0xff}},
+		{"spaced", "01 02 FF", []byte{1, 2, 0xff}},
+		{"0x prefixed", "0x01 0x02\n0xff", []byte{1, 2, 0xff}},
+		{"lines", "  0102\n\n  ff  \n", []byte{1, 2, 0xff}},
+		//offsets are skipped and the character column, which here holds hex
+		//digits and a |, is ignored
+		{"hex.Dump", hex.Dump(frame), frame},
+		{"empty", "", []byte{}},
+	}
+	for _, tc := range cases {
+		got, err := ParseHex(tc.in)
+		if err != nil || !bytes.Equal(got, tc.want) {
+			t.Errorf("%s: got %v %v, want %v", tc.name, got, err, tc.want)
+		}
+	}
+
+	for _, in := range []string{"0g", "012", "01 0", "00000000  0g  |.|"} {
+		if got, err := ParseHex(in); err == nil {
+			t.Errorf("%q read as %v", in, got)
+		}
+	}
+}
+
+func TestPayloadText(t *testing.T) {
+	format := func(id byte) []FrameOption {
+		return []FrameOption{{Number: 12, Value: []byte{0, id}}}
+	}
+	binary := []byte{0, 1, 2, 0xff}
+	cases := []struct {
+		name    string
+		options []FrameOption
+		payload []byte
+		want    string
+	}{
+		{"empty", nil, nil, ""},
+		//without a Content-Format the payload is shown as JSON or text if it is either
+		{"guessed JSON", nil, []byte(`{"a":[1,2]}`), "{\n  \"a\": [\n    1,\n    2\n  ]\n}"},
+		{"guessed text", nil, []byte("hello"), "hello"},
+		{"guessed binary", nil, binary, strings.TrimSuffix(hex.Dump(binary), "\n")},
+		{"control characters aren't text", nil, []byte("a\x01b"), strings.TrimSuffix(hex.Dump([]byte("a\x01b")), "\n")},
+		{"invalid UTF-8 isn't text", nil, []byte("a\xffb"), strings.TrimSuffix(hex.Dump([]byte("a\xffb")), "\n")},
+		{"text", format(0), []byte(`{"a":1}`), `{"a":1}`},
+		{"JSON", format(50), []byte(`{"a":1}`), "{\n  \"a\": 1\n}"},
+		{"bad JSON", format(50), []byte(`{"a"`), strings.TrimSuffix(hex.Dump([]byte(`{"a"`)), "\n")},
+		{"CBOR", format(60), []byte{0xa1, 0x61, 'a', 0x01}, "{\n  \"a\": 1\n}"},
+		{"bad CBOR", format(60), []byte{0xa1}, strings.TrimSuffix(hex.Dump([]byte{0xa1}), "\n")},
+		{"other format", format(42), []byte("hi"), strings.TrimSuffix(hex.Dump([]byte("hi")), "\n")},
+	}
+	for _, tc := range cases {
+		f := Frame{Options: tc.options, Payload: tc.payload}
+		if got := f.PayloadText(); got != tc.want {
+			t.Errorf("%s: got\n%s\nwant\n%s", tc.name, got, tc.want)
+		}
+	}
+}
+
+//cannedTransport answers every request with resp, or fails with err
+type cannedTransport struct {
+	resp []byte
+	err  error
+}
+
+func (c cannedTransport) RoundTrip(ctx context.Context, req []byte) ([]byte, error) {
+	return c.resp, c.err
+}
+
+func (c cannedTransport) Subscribe(identity string, serverKey string) (Subscription, error) {
+	return nil, errors.New("not supported")
+}
+
+func (c cannedTransport) Close() error {
+	return nil
+}
+
+func TestDumpRecording(t *testing.T) {
+	req := zestHeader{Code: 2, Token: "tk", Payload: []byte("hello")}
+	req.Options = append(req.Options, zestOptions{Number: 11, Value: "/kv/test/key"})
+	msg, err := req.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var recording bytes.Buffer
+	ok := NewRecordingTransport(cannedTransport{resp: []byte{65, 0, 0, 0}}, &recording)
+	ok.RoundTrip(context.Background(), msg)
+	failing := NewRecordingTransport(cannedTransport{err: errors.New("connection refused")}, &recording)
+	failing.RoundTrip(context.Background(), msg)
+
+	var out bytes.Buffer
+	if err := DumpRecording(&recording, &out); err != nil {
+		t.Fatal(err)
+	}
+	dump := out.String()
+	for _, want := range []string{
+		"request #1\ncode     POST (2)\ntoken    **\noption   Uri-Path (11): /kv/test/key\npayload  5 bytes\n  hello\n",
+		"response #1\ncode     2.01 Created (65)\n",
+		"response #1 error: connection refused\n",
+	} {
+		if !strings.Contains(dump, want) {
+			t.Errorf("missing %q in\n%s", want, dump)
+		}
+	}
+
+	if err := DumpRecording(strings.NewReader("{not json"), &out); err == nil {
+		t.Error("a bad recording was dumped")
+	}
+}
+
+func TestDumpRecordedSession(t *testing.T) {
+	f, err := os.Open("testdata/session.ndjson")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var out bytes.Buffer
+	if err := DumpRecording(f, &out); err != nil {
+		t.Fatal(err)
+	}
+	dump := out.String()
+	for _, want := range []string{
+		"request #1\ncode     POST (2)\n",
+		"option   Uri-Path (11): /kv/test/greeting\n",
+		"response #1\ncode     2.01 Created (65)\n",
+		"  [\n    {\n      \"timestamp\": 1500000000000,",
+		"subscribe #",
+		"event #",
+	} {
+		if !strings.Contains(dump, want) {
+			t.Errorf("missing %q in\n%s", want, dump)
+		}
+	}
+	if strings.Contains(dump, "undecodable") {
+		t.Errorf("a recorded frame didn't decode:\n%s", dump)
+	}
+}
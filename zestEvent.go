@@ -0,0 +1,68 @@
+package zest
+
+import (
+	"bytes"
+	"errors"
+	"strconv"
+)
+
+//DataEvent is a data or notification mode observation. The server sends
+//"<timestamp> <path> <content format> <payload>" for every write to the
+//observed path.
+type DataEvent struct {
+	Timestamp     int64
+	Path          string
+	ContentFormat string
+	Payload       []byte
+}
+
+//AuditEvent is an audit mode observation. The server sends
+//"<timestamp> <host> <method> <path> <response code>" for every request made
+//on the observed path.
+type AuditEvent struct {
+	Timestamp int64
+	Host      string
+	Method    string
+	Path      string
+	Code      string
+}
+
+//ParseDataEvent splits a data or notification mode observation into its fields
+func ParseDataEvent(msg []byte) (DataEvent, error) {
+	parts := bytes.SplitN(msg, []byte(" "), 4)
+	if len(parts) < 3 {
+		return DataEvent{}, errors.New("data event has too few fields")
+	}
+
+	ts, err := strconv.ParseInt(string(parts[0]), 10, 64)
+	if err != nil {
+		return DataEvent{}, errors.New("data event timestamp " + err.Error())
+	}
+
+	e := DataEvent{Timestamp: ts, Path: string(parts[1]), ContentFormat: string(parts[2])}
+	if len(parts) == 4 {
+		e.Payload = parts[3]
+	}
+	return e, nil
+}
+
+//ParseAuditEvent splits an audit mode observation into its fields
+func ParseAuditEvent(msg []byte) (AuditEvent, error) {
+	parts := bytes.Fields(msg)
+	if len(parts) != 5 {
+		return AuditEvent{}, errors.New("audit event should have 5 fields, got " + strconv.Itoa(len(parts)))
+	}
+
+	ts, err := strconv.ParseInt(string(parts[0]), 10, 64)
+	if err != nil {
+		return AuditEvent{}, errors.New("audit event timestamp " + err.Error())
+	}
+
+	return AuditEvent{
+		Timestamp: ts,
+		Host:      string(parts[1]),
+		Method:    string(parts[2]),
+		Path:      string(parts[3]),
+		Code:      string(parts[4]),
+	}, nil
+}
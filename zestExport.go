@@ -0,0 +1,278 @@
+package zest
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+//Record is one value from a key value or time series datasource as written by
+//an export. Key is set for key value records and Timestamp (milliseconds since
+//the epoch) for time series ones. Data holds JSON values as they are, text as a
+//JSON string and anything else as a base64 JSON string, Format says which.
+type Record struct {
+	Key       string          `json:"key,omitempty"`
+	Timestamp int64           `json:"timestamp,omitempty"`
+	Format    string          `json:"format,omitempty"`
+	Data      json.RawMessage `json:"data"`
+}
+
+//NewRecordData wraps a payload in the format it was read with for a Record
+func NewRecordData(payload []byte, contentFormat string) (json.RawMessage, error) {
+	f, ok := LookupContentFormat(contentFormat)
+	if !ok {
+		return nil, errors.New("Unsupported content format")
+	}
+	switch f.ID {
+	case 50, 110:
+		if len(payload) == 0 {
+			return json.RawMessage("null"), nil
+		}
+		return json.RawMessage(payload), nil
+	case 0:
+		return json.Marshal(string(payload))
+	}
+	return json.Marshal(base64.StdEncoding.EncodeToString(payload))
+}
+
+//Payload turns a record's data back into the bytes to post in its format
+func (r Record) Payload() ([]byte, error) {
+	f, ok := LookupContentFormat(r.format())
+	if !ok {
+		return nil, errors.New("Unsupported content format")
+	}
+	switch f.ID {
+	case 50, 110:
+		return r.Data, nil
+	}
+	var s string
+	err := json.Unmarshal(r.Data, &s)
+	if err != nil {
+		return nil, errors.New("record data should be a string for " + r.format() + " " + err.Error())
+	}
+	if f.ID == 0 {
+		return []byte(s), nil
+	}
+	return base64.StdEncoding.DecodeString(s)
+}
+
+func (r Record) format() string {
+	if r.Format == "" {
+		return "JSON"
+	}
+	return r.Format
+}
+
+//ExportKV calls fn with every key of the key value datasource at path (e.g.
+///kv/sensors) in key order, read in contentFormat. Keys up to and including
+//after are skipped so an export that failed can carry on from its last record.
+func (z *ZestClient) ExportKV(ctx context.Context, token string, path string, contentFormat string, after string, fn func(Record) error) error {
+	path = strings.TrimSuffix(path, "/")
+
+	resp, err := z.get(ctx, token, path+"/keys", "JSON")
+	if err != nil {
+		return err
+	}
+	var keys []string
+	err = json.Unmarshal(resp, &keys)
+	if err != nil {
+		return errors.New("listing keys " + err.Error())
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		if after != "" && key <= after {
+			continue
+		}
+		payload, err := z.get(ctx, token, path+"/"+key, contentFormat)
+		if err != nil {
+			return err
+		}
+		data, err := NewRecordData(payload, contentFormat)
+		if err != nil {
+			return err
+		}
+		err = fn(Record{Key: key, Format: contentFormat, Data: data})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//ExportTS calls fn with every value of the time series datasource at path
+//(e.g. /ts/temperature) with a timestamp from from to to inclusive, in time
+//order. The range is read window milliseconds at a time so large series never
+//need to fit in one response, 0 reads it in one go.
+func (z *ZestClient) ExportTS(ctx context.Context, token string, path string, from int64, to int64, window int64, fn func(Record) error) error {
+	path = strings.TrimSuffix(path, "/")
+	if window <= 0 || window > to-from {
+		window = to - from + 1
+	}
+
+	for start := from; start <= to; start += window {
+		end := start + window - 1
+		if end > to {
+			end = to
+		}
+		resp, err := z.get(ctx, token, path+"/range/"+strconv.FormatInt(start, 10)+"/"+strconv.FormatInt(end, 10), "JSON")
+		if err != nil {
+			return err
+		}
+		var values []struct {
+			Timestamp int64           `json:"timestamp"`
+			Data      json.RawMessage `json:"data"`
+		}
+		err = json.Unmarshal(resp, &values)
+		if err != nil {
+			return errors.New("reading range from " + strconv.FormatInt(start, 10) + " " + err.Error())
+		}
+		//the store returns newest first, reverse before sorting so values
+		//sharing a timestamp stay in the order they were written
+		for i, j := 0, len(values)-1; i < j; i, j = i+1, j-1 {
+			values[i], values[j] = values[j], values[i]
+		}
+		sort.SliceStable(values, func(i, j int) bool { return values[i].Timestamp < values[j].Timestamp })
+
+		for _, v := range values {
+			err = fn(Record{Timestamp: v.Timestamp, Data: v.Data})
+			if err != nil {
+				return err
+			}
+		}
+		if end == to {
+			break
+		}
+	}
+	return nil
+}
+
+//Position is how far through a time series a reader has got: every value up
+//to Timestamp, of which Count had exactly that timestamp. Values sharing a
+//millisecond are always read in the same order, so Count is enough to carry on
+//between them.
+type Position struct {
+	Timestamp int64 `json:"timestamp"`
+	Count     int   `json:"count"`
+}
+
+//Next is the position once rec, the value after p, has been read
+func (p Position) Next(rec Record) Position {
+	if rec.Timestamp == p.Timestamp {
+		return Position{Timestamp: p.Timestamp, Count: p.Count + 1}
+	}
+	return Position{Timestamp: rec.Timestamp, Count: 1}
+}
+
+//ExportTSAfter is ExportTS carrying on from pos rather than a timestamp, the
+//first pos.Count values at pos.Timestamp are skipped as already read
+func (z *ZestClient) ExportTSAfter(ctx context.Context, token string, path string, pos Position, to int64, window int64, fn func(Record) error) error {
+	skip := pos.Count
+	return z.ExportTS(ctx, token, path, pos.Timestamp, to, window, func(rec Record) error {
+		if rec.Timestamp == pos.Timestamp && skip > 0 {
+			skip--
+			return nil
+		}
+		return fn(rec)
+	})
+}
+
+//ImportRecord writes an exported record to the datasource at path. Key value
+//records are written to their key and time series records at their original
+//timestamp, with any tags kept in the data.
+func (z *ZestClient) ImportRecord(ctx context.Context, token string, path string, r Record) error {
+	path = strings.TrimSuffix(path, "/")
+
+	payload, err := r.Payload()
+	if err != nil {
+		return err
+	}
+
+	target := path + "/at/" + strconv.FormatInt(r.Timestamp, 10)
+	if r.Key != "" {
+		target = path + "/" + r.Key
+	}
+	_, err = z.post(ctx, token, target, payload, r.format())
+	return err
+}
+
+//ImportRecords writes records to the datasource at path as ImportRecord does,
+//with up to concurrency requests in flight over the client's pooled sockets.
+//Time series values sharing a timestamp are written in turn so the store keeps
+//their order. After a failure no more writes are started, and ImportRecords
+//returns how many records from the start were written along with the error.
+//Later records may have been written too and would be written again by
+//resuming from there, so import a time series that must not get duplicates
+//with a concurrency of 1.
+func (z *ZestClient) ImportRecords(ctx context.Context, token string, path string, records []Record, concurrency int) (int, error) {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	var (
+		mu       sync.Mutex
+		wg       sync.WaitGroup
+		written  = make([]bool, len(records))
+		failed   error
+		failedAt = len(records)
+		slots    = make(chan struct{}, concurrency)
+	)
+	for start := 0; start < len(records); {
+		end := start + 1
+		for end < len(records) && records[end].Key == "" && records[end].Timestamp == records[start].Timestamp {
+			end++
+		}
+
+		mu.Lock()
+		stop := failed != nil
+		mu.Unlock()
+		if stop {
+			break
+		}
+		select {
+		case slots <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+
+		wg.Add(1)
+		go func(start int, end int) {
+			defer wg.Done()
+			defer func() { <-slots }()
+			for i := start; i < end; i++ {
+				err := z.ImportRecord(ctx, token, path, records[i])
+				mu.Lock()
+				if err != nil {
+					if i < failedAt {
+						failed, failedAt = err, i
+					}
+					mu.Unlock()
+					return
+				}
+				written[i] = true
+				mu.Unlock()
+			}
+		}(start, end)
+		start = end
+	}
+	wg.Wait()
+
+	n := 0
+	for n < len(written) && written[n] {
+		n++
+	}
+	if n == len(records) {
+		return n, nil
+	}
+	if failed == nil {
+		failed = ctx.Err()
+	}
+	return n, failed
+}
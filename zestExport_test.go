@@ -0,0 +1,115 @@
+package zest_test
+
+import (
+	"context"
+	"strconv"
+	"testing"
+
+	zest "github.com/me-box/goZestClient"
+	"github.com/me-box/goZestClient/zesttest"
+)
+
+//exportAll collects every record ExportTSAfter reads from pos
+func exportAll(t *testing.T, z *zest.ZestClient, path string, pos zest.Position, window int64) []zest.Record {
+	var got []zest.Record
+	err := z.ExportTSAfter(context.Background(), "", path, pos, 10000, window, func(r zest.Record) error {
+		got = append(got, r)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return got
+}
+
+func TestExportTSKeepsWriteOrder(t *testing.T) {
+	s := zesttest.NewServer()
+	for i := 0; i < 6; i++ {
+		s.AddPoint("/ts/temp", int64(1000+i/3), []byte(strconv.Itoa(i)))
+	}
+	z := newTestClient(t, s)
+
+	got := exportAll(t, z, "/ts/temp", zest.Position{}, 0)
+	if len(got) != 6 {
+		t.Fatalf("exported %d records", len(got))
+	}
+	for i, r := range got {
+		if string(r.Data) != strconv.Itoa(i) {
+			t.Fatalf("record %d is %s", i, r.Data)
+		}
+	}
+}
+
+func TestExportTSAfterSkipsValuesRead(t *testing.T) {
+	s := zesttest.NewServer()
+	for i := 0; i < 6; i++ {
+		s.AddPoint("/ts/temp", int64(1000+i/3), []byte(strconv.Itoa(i)))
+	}
+	z := newTestClient(t, s)
+
+	//read the first four values, stopping between two that share a millisecond
+	var pos zest.Position
+	for _, r := range exportAll(t, z, "/ts/temp", pos, 0)[:4] {
+		pos = pos.Next(r)
+	}
+	if pos != (zest.Position{Timestamp: 1001, Count: 1}) {
+		t.Fatalf("position %+v", pos)
+	}
+
+	got := exportAll(t, z, "/ts/temp", pos, 1)
+	if len(got) != 2 || string(got[0].Data) != "4" || string(got[1].Data) != "5" {
+		t.Fatalf("carried on with %v", got)
+	}
+}
+
+func TestImportRecords(t *testing.T) {
+	s := zesttest.NewServer()
+	z := newTestClient(t, s)
+
+	var records []zest.Record
+	for i := 0; i < 40; i++ {
+		records = append(records, zest.Record{Timestamp: int64(1000 + i/4), Data: []byte(strconv.Itoa(i))})
+	}
+	n, err := z.ImportRecords(context.Background(), "", "/ts/copy", records, 8)
+	if err != nil || n != len(records) {
+		t.Fatalf("imported %d of %d: %v", n, len(records), err)
+	}
+
+	points := s.Points("/ts/copy")
+	if len(points) != len(records) {
+		t.Fatalf("stored %d values", len(points))
+	}
+	for i, p := range points {
+		if string(p.Data) != strconv.Itoa(i) {
+			t.Fatalf("value %d is %s, values sharing a timestamp should keep their order", i, p.Data)
+		}
+	}
+}
+
+func TestImportRecordsStopsAtFailure(t *testing.T) {
+	s := zesttest.NewServer()
+	s.Handle = func(req zest.Frame) (zest.Frame, bool) {
+		if path, _ := req.Option(11); string(path) == "/kv/copy/k10" {
+			return zest.Frame{Code: 160}, true
+		}
+		return zest.Frame{}, false
+	}
+	z := newTestClient(t, s)
+
+	var records []zest.Record
+	for i := 0; i < 20; i++ {
+		records = append(records, zest.Record{Key: "k" + strconv.Itoa(i), Data: []byte(strconv.Itoa(i))})
+	}
+	n, err := z.ImportRecords(context.Background(), "", "/kv/copy", records, 4)
+	if _, ok := err.(*zest.ResponseError); !ok {
+		t.Fatalf("got %v, want the store's error", err)
+	}
+	if n != 10 {
+		t.Fatalf("reported %d records written, want 10", n)
+	}
+	for i := 0; i < n; i++ {
+		if _, ok := s.Value("/kv/copy/k" + strconv.Itoa(i)); !ok {
+			t.Fatalf("k%d was not written", i)
+		}
+	}
+}
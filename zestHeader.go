@@ -17,9 +17,13 @@ func (z *zestHeader) Marshal() ([]byte, error) {
 	if z == nil {
 		return nil, errors.New("This should not be nil")
 	}
-	//TODO check token length
-	//TODO number of options < 8
-	//TODO check token length
+	//the counts are packed into one and two bytes
+	if len(z.Options) > 255 {
+		return nil, errors.New("Too many options, at most 255 fit in a header")
+	}
+	if len(z.Token) > 65535 {
+		return nil, errors.New("Token too long")
+	}
 
 	z.oc = uint8(len(z.Options))
 
@@ -39,7 +43,9 @@ func (z *zestHeader) Marshal() ([]byte, error) {
 	//append the options
 	for i := 0; i < int(z.oc); i++ {
 		optBytes, marshalErr := z.Options[i].Marshal()
-		assertNotError(marshalErr)
+		if marshalErr != nil {
+			return nil, marshalErr
+		}
 		b = append(b[:], optBytes[:]...)
 	}
 
@@ -58,26 +64,37 @@ func (z *zestHeader) Parse(msg []byte) error {
 	z.oc = uint8(msg[1])
 	z.tkl, _ = unPack_16(msg[2:4])
 
-	if len(msg) >= 5 {
-		var remainingBytes = msg[4:]
-		if z.oc > 0 {
-			var err error
-			for i := 0; i < int(z.oc); i++ {
-				zo := zestOptions{}
-				remainingBytes, err = zo.Parse(remainingBytes)
-				if err != nil {
-					return errors.New("Error decoding options")
-				} else {
-					z.Options = append(z.Options, zo)
-				}
-
-			}
-		}
+	tokenEnd := 4 + int(z.tkl)
+	if len(msg) < tokenEnd {
+		return errors.New("Can't parse header not enough bytes for token")
+	}
+	z.Token = string(msg[4:tokenEnd])
 
-		if len(remainingBytes) > 0 {
-			z.Payload = remainingBytes
+	//options counted in the header have to be there even without a payload
+	var remainingBytes = msg[tokenEnd:]
+	for i := 0; i < int(z.oc); i++ {
+		zo := zestOptions{}
+		var err error
+		remainingBytes, err = zo.Parse(remainingBytes)
+		if err != nil {
+			return errors.New("Error decoding options")
 		}
+		z.Options = append(z.Options, zo)
+	}
+
+	if len(remainingBytes) > 0 {
+		z.Payload = remainingBytes
 	}
 
 	return nil
 }
+
+//option returns the value of the first option with the given number
+func (z *zestHeader) option(number uint16) (string, bool) {
+	for _, o := range z.Options {
+		if o.Number == number {
+			return o.Value, true
+		}
+	}
+	return "", false
+}
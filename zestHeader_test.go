@@ -0,0 +1,141 @@
+package zest
+
+import (
+	"bytes"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+//roundTrip marshals h and parses the result
+func roundTrip(t *testing.T, h zestHeader) zestHeader {
+	b, err := h.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out zestHeader
+	err = out.Parse(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+func TestHeaderRoundTripQueryOptions(t *testing.T) {
+	for _, n := range []int{0, 1, 10, 255} {
+		h := zestHeader{Code: 1, Token: "token", Payload: []byte("payload")}
+		for i := 0; i < n; i++ {
+			h.Options = append(h.Options, zestOptions{Number: 15, Value: "arg=" + strconv.Itoa(i)})
+		}
+
+		out := roundTrip(t, h)
+		if out.Code != h.Code || out.Token != h.Token || !bytes.Equal(out.Payload, h.Payload) {
+			t.Fatalf("%d options: got code %d token %q payload %q", n, out.Code, out.Token, out.Payload)
+		}
+		if len(out.Options) != n {
+			t.Fatalf("%d options: parsed %d", n, len(out.Options))
+		}
+		for i, o := range out.Options {
+			if o.Number != 15 || o.Value != h.Options[i].Value {
+				t.Fatalf("%d options: option %d is %d %q", n, i, o.Number, o.Value)
+			}
+		}
+	}
+}
+
+func TestHeaderRoundTripWithoutPayload(t *testing.T) {
+	h := zestHeader{Code: 69, Options: []zestOptions{{Number: 12, Value: string(pack_16(50))}, {Number: 4, Value: ""}}}
+	out := roundTrip(t, h)
+	if len(out.Options) != 2 || out.Options[1].Number != 4 || out.Options[1].Value != "" || len(out.Payload) != 0 {
+		t.Fatalf("got %+v", out)
+	}
+}
+
+func TestHeaderRefusesTooManyOptions(t *testing.T) {
+	h := zestHeader{Code: 1}
+	for i := 0; i < 256; i++ {
+		h.Options = append(h.Options, zestOptions{Number: 15, Value: "a"})
+	}
+	if _, err := h.Marshal(); err == nil {
+		t.Fatal("256 options should be refused")
+	}
+}
+
+func TestHeaderRefusesLongOption(t *testing.T) {
+	h := zestHeader{Code: 1, Options: []zestOptions{{Number: 15, Value: strings.Repeat("a", 65536)}}}
+	if _, err := h.Marshal(); err == nil {
+		t.Fatal("an option longer than 65535 bytes should be refused")
+	}
+
+	h.Options[0].Value = strings.Repeat("a", 65535)
+	out := roundTrip(t, h)
+	if len(out.Options[0].Value) != 65535 {
+		t.Fatalf("parsed %d bytes", len(out.Options[0].Value))
+	}
+}
+
+func TestHeaderParseTruncated(t *testing.T) {
+	h := zestHeader{Code: 1, Token: "token", Options: []zestOptions{{Number: 11, Value: "/kv/key"}}}
+	b, err := h.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	//9 bytes is the header and token without the option it counts
+	for _, n := range []int{0, 3, 6, 9, len(b) - 1} {
+		var out zestHeader
+		if err := out.Parse(b[:n]); err == nil {
+			t.Errorf("parsing %d of %d bytes should fail", n, len(b))
+		}
+	}
+}
+
+func TestQueryRoundTrip(t *testing.T) {
+	z := &ZestClient{}
+	query := url.Values{}
+	query.Add("filter", "room=kitchen&hall")
+	query.Add("filter", "sensor=a b")
+	query.Add("aggregate", "mean")
+	path := QueryPath("/ts/temp/last/10", query) + "&filter=third"
+
+	req := z.newRequest(1, "", path, "JSON")
+	out := roundTrip(t, req)
+
+	if p, _ := out.option(11); p != "/ts/temp/last/10" {
+		t.Fatalf("Uri-Path %q", p)
+	}
+	got, err := url.ParseQuery(strings.SplitN(out.requestPath(), "?", 2)[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := url.Values{
+		"aggregate": {"mean"},
+		"filter":    {"room=kitchen&hall", "sensor=a b", "third"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+
+	var args []string
+	for _, o := range out.Options {
+		if o.Number == 15 {
+			args = append(args, o.Value)
+		}
+	}
+	wantArgs := []string{"aggregate=mean", "filter=room%3Dkitchen%26hall", "filter=sensor%3Da+b", "filter=third"}
+	if !reflect.DeepEqual(args, wantArgs) {
+		t.Fatalf("Uri-Query options %q, want %q", args, wantArgs)
+	}
+}
+
+func TestSplitQueryKeepsOrder(t *testing.T) {
+	path, args := splitQuery("/kv/a?b=2&a=1&b=1&flag&&c=x%3Dy")
+	if path != "/kv/a" {
+		t.Fatalf("path %q", path)
+	}
+	want := []string{"b=2", "a=1", "b=1", "flag", "c=x%3Dy"}
+	if !reflect.DeepEqual(args, want) {
+		t.Fatalf("got %q, want %q", args, want)
+	}
+}
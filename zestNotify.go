@@ -0,0 +1,132 @@
+package zest
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+)
+
+//maxIdentityLength is the longest routing identity zmq accepts on a socket
+const maxIdentityLength = 255
+
+//ErrNotifyClosed is returned by Wait once a NotifyFuture has been closed
+var ErrNotifyClosed = errors.New("notify closed")
+
+//TimeoutError is returned when the Max-Age of a Notify expires before
+//anything is routed to its path
+type TimeoutError struct {
+	Path   string
+	MaxAge uint32
+}
+
+func (e *TimeoutError) Error() string {
+	return "timeout after " + strconv.Itoa(int(e.MaxAge)) + "s waiting for " + e.Path
+}
+
+//NotifyFuture resolves once, with the first message routed to a Notify path
+//or with an error. The subscription behind it is closed as soon as it resolves.
+type NotifyFuture struct {
+	done chan struct{}
+	stop chan struct{}
+	once sync.Once
+	data []byte
+	err  error
+}
+
+//Wait blocks until the future resolves or ctx is done. If ctx finishes first
+//the future is closed and ctx.Err() is returned.
+func (f *NotifyFuture) Wait(ctx context.Context) ([]byte, error) {
+	select {
+	case <-f.done:
+		return f.data, f.err
+	case <-ctx.Done():
+		f.Close()
+		return nil, ctx.Err()
+	}
+}
+
+//Close abandons the future and releases its socket. It is safe to call more than once.
+func (f *NotifyFuture) Close() {
+	f.once.Do(func() {
+		close(f.stop)
+	})
+}
+
+//NotifyIdentity is the dealer identity a Notify on path is routed to, the path
+//without its query. zmq rejects identities longer than maxIdentityLength, so a
+//longer path is routed to # followed by the hex SHA-256 of the path instead.
+//Paths start with /, so the short form can't be mistaken for a path.
+func NotifyIdentity(path string) string {
+	path, _ = splitQuery(path)
+	if len(path) <= maxIdentityLength {
+		return path
+	}
+	sum := sha256.Sum256([]byte(path))
+	return "#" + hex.EncodeToString(sum[:])
+}
+
+func (z *ZestClient) newNotifyFuture(sub Subscription, path string, timeout uint32) (*NotifyFuture, error) {
+
+	if !z.track() {
+		sub.Close()
+		return nil, ErrClientClosed
+	}
+
+	f := &NotifyFuture{
+		done: make(chan struct{}),
+		stop: make(chan struct{}),
+	}
+
+	//the subscription is only ever touched from this goroutine
+	go func() {
+		defer z.subs.Done()
+		defer close(f.done)
+		defer sub.Close()
+
+		var expired <-chan time.Time
+		if timeout > 0 {
+			timer := time.NewTimer(time.Duration(timeout) * time.Second)
+			defer timer.Stop()
+			expired = timer.C
+		}
+
+		for {
+			select {
+			case <-f.stop:
+				f.err = ErrNotifyClosed
+				return
+			case <-z.closing:
+				f.err = ErrClientClosed
+				return
+			case <-expired:
+				f.err = &TimeoutError{Path: path, MaxAge: timeout}
+				return
+			default:
+			}
+
+			msg, err := sub.Recv()
+			if err != nil {
+				if err == ErrRecvTimeout {
+					//receive timeout, check for close or expiry
+					continue
+				}
+				f.err = err
+				return
+			}
+
+			resp, err := z.handleResponse(msg)
+			if err != nil {
+				f.err = err
+				return
+			}
+			f.data = resp.Payload
+			return
+		}
+	}()
+
+	return f, nil
+}
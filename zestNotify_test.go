@@ -0,0 +1,158 @@
+package zest_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	zest "github.com/me-box/goZestClient"
+	"github.com/me-box/goZestClient/zesttest"
+)
+
+func TestNotifyWaitResolves(t *testing.T) {
+	s := zesttest.NewServer()
+	z := newTestClient(t, s)
+
+	future, err := z.Notify("", "/kv/test/reply", "TEXT", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := z.Post("", "/kv/test/reply", []byte("done"), "TEXT"); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	msg, err := future.Wait(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	event, err := zest.ParseDataEvent(msg)
+	if err != nil || string(event.Payload) != "done" {
+		t.Fatalf("got %q %v", msg, err)
+	}
+
+	//a resolved future keeps its result
+	if again, err := future.Wait(ctx); err != nil || string(again) != string(msg) {
+		t.Fatalf("second Wait got %q %v", again, err)
+	}
+	future.Close()
+	future.Close()
+}
+
+func TestNotifyClose(t *testing.T) {
+	s := zesttest.NewServer()
+	z := newTestClient(t, s)
+
+	future, err := z.Notify("", "/kv/test/reply", "TEXT", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	future.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := future.Wait(ctx); err != zest.ErrNotifyClosed {
+		t.Fatalf("got %v, want ErrNotifyClosed", err)
+	}
+}
+
+func TestNotifyWaitContext(t *testing.T) {
+	s := zesttest.NewServer()
+	z := newTestClient(t, s)
+
+	future, err := z.Notify("", "/kv/test/reply", "TEXT", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := future.Wait(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("got %v, want context.DeadlineExceeded", err)
+	}
+	//giving up closes the future
+	if _, err := future.Wait(context.Background()); err != zest.ErrNotifyClosed {
+		t.Fatalf("got %v after the context ended, want ErrNotifyClosed", err)
+	}
+}
+
+func TestNotifyTimeout(t *testing.T) {
+	s := zesttest.NewServer()
+	z := newTestClient(t, s)
+
+	future, err := z.Notify("", "/kv/test/reply", "TEXT", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, err = future.Wait(ctx)
+	var timeout *zest.TimeoutError
+	if !errors.As(err, &timeout) {
+		t.Fatalf("got %v, want a *TimeoutError", err)
+	}
+	if timeout.Path != "/kv/test/reply" || timeout.MaxAge != 1 {
+		t.Fatalf("got %+v", timeout)
+	}
+	if err.Error() != "timeout after 1s waiting for /kv/test/reply" {
+		t.Fatalf("got %q", err.Error())
+	}
+}
+
+func TestNotifyResponseError(t *testing.T) {
+	s := zesttest.NewServer()
+	s.Token = "secret"
+	z := newTestClient(t, s)
+
+	_, err := z.Notify("wrong", "/kv/test/reply", "TEXT", 0)
+	var respErr *zest.ResponseError
+	if !errors.As(err, &respErr) || respErr.Code != 129 {
+		t.Fatalf("got %v, want a 4.01 *ResponseError", err)
+	}
+}
+
+func TestNotifyLongPath(t *testing.T) {
+	s := zesttest.NewServer()
+	z := newTestClient(t, s)
+
+	path := "/kv/" + strings.Repeat("a", 300)
+	future, err := z.Notify("", path, "TEXT", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := z.Post("", path, []byte("done"), "TEXT"); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	msg, err := future.Wait(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	event, err := zest.ParseDataEvent(msg)
+	if err != nil || string(event.Payload) != "done" {
+		t.Fatalf("got %q %v", msg, err)
+	}
+}
+
+func TestNotifyIdentity(t *testing.T) {
+	long := "/kv/" + strings.Repeat("a", 252)
+	cases := []struct {
+		path string
+		want string
+	}{
+		{"/kv/test/reply", "/kv/test/reply"},
+		{"/kv/test/reply?x=1", "/kv/test/reply"},
+		{long[:255], long[:255]},
+		{long[:255] + "?x=1", long[:255]},
+		{long, "#15abeeb881198877eb3e3b02a303a1c1e399d4bd6be52925932c6312f1224469"},
+	}
+	for _, tc := range cases {
+		if got := zest.NotifyIdentity(tc.path); got != tc.want {
+			t.Errorf("%.20s... (%d bytes): got %q, want %q", tc.path, len(tc.path), got, tc.want)
+		}
+	}
+	if zest.NotifyIdentity(long) == zest.NotifyIdentity(long+"b") {
+		t.Error("two long paths share an identity")
+	}
+}
@@ -13,6 +13,9 @@ func (zo *zestOptions) Marshal() ([]byte, error) {
 		return nil, errors.New("This should not be nil")
 	}
 
+	if len(zo.Value) > 65535 {
+		return nil, errors.New("Option value too long")
+	}
 	zo.len = uint16(len(zo.Value))
 
 	//pack the header
@@ -36,10 +39,15 @@ func (zo *zestOptions) Parse(b []byte) ([]byte, error) {
 
 	zo.Number, _ = unPack_16(b[0:2])
 	zo.len, _ = unPack_16(b[2:4])
-	zo.Value = string(b[4 : 4+zo.len])
+	//work in int, 4+len overflows a uint16 for the longest values
+	end := 4 + int(zo.len)
+	if len(b) < end {
+		return nil, errors.New("Not enough bytes for option value")
+	}
+	zo.Value = string(b[4:end])
 
-	if len(b) > (4 + int(zo.len)) {
-		return b[4+zo.len:], nil
+	if len(b) > end {
+		return b[end:], nil
 	}
 
 	return nil, nil
@@ -0,0 +1,69 @@
+package zest
+
+import (
+	"net/url"
+	"strings"
+)
+
+//QueryPath adds query to path. Any request path may carry a query after a ?,
+//each of its arguments is sent as its own Uri-Query option (15) rather than as
+//part of the Uri-Path, e.g. Get(token, QueryPath("/ts/temp/last/10", q), "JSON").
+func QueryPath(path string, query url.Values) string {
+	if len(query) == 0 {
+		return path
+	}
+	sep := "?"
+	if strings.Contains(path, "?") {
+		sep = "&"
+	}
+	return path + sep + query.Encode()
+}
+
+//splitQuery separates a request path from its query, returning the arguments
+//in the order given, repeats included. Each is unescaped and escaped again so
+//that = and & inside a key or value can't be mistaken for separators.
+func splitQuery(path string) (string, []string) {
+	i := strings.IndexByte(path, '?')
+	if i < 0 {
+		return path, nil
+	}
+
+	var args []string
+	for _, part := range strings.Split(path[i+1:], "&") {
+		if part == "" {
+			continue
+		}
+		key, value := part, ""
+		hasValue := false
+		if j := strings.IndexByte(part, '='); j >= 0 {
+			key, value, hasValue = part[:j], part[j+1:], true
+		}
+		arg := escapeQuery(key)
+		if hasValue {
+			arg += "=" + escapeQuery(value)
+		}
+		args = append(args, arg)
+	}
+	return path[:i], args
+}
+
+//escapeQuery escapes s for a Uri-Query option, leaving escapes already in it alone
+func escapeQuery(s string) string {
+	if unescaped, err := url.QueryUnescape(s); err == nil {
+		s = unescaped
+	}
+	return url.QueryEscape(s)
+}
+
+//requestPath is the Uri-Path of a request followed by its Uri-Query options as a query
+func (z *zestHeader) requestPath() string {
+	path, _ := z.option(11)
+	sep := "?"
+	for _, o := range z.Options {
+		if o.Number == 15 {
+			path += sep + o.Value
+			sep = "&"
+		}
+	}
+	return path
+}
@@ -0,0 +1,291 @@
+package zest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"sync"
+	"time"
+)
+
+//recordedFrame is one line of a session recording. Requests and their
+//responses share an ID, as do a subscription and the events it received.
+//Time is microseconds since the recording started.
+type recordedFrame struct {
+	Time     int64  `json:"t_us"`
+	Kind     string `json:"kind"`
+	ID       int    `json:"id"`
+	Identity string `json:"identity,omitempty"`
+	Frame    []byte `json:"frame,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+const (
+	frameRequest     = "request"
+	frameResponse    = "response"
+	frameSubscribe   = "subscribe"
+	frameEvent       = "event"
+	frameUnsubscribe = "unsubscribe"
+)
+
+//RecordingTransport passes everything on to another transport and writes each
+//request, response, subscription and event to w as a line of JSON with the
+//time it happened. The recording can be played back with ReplayTransport.
+type RecordingTransport struct {
+	next  Transport
+	start time.Time
+
+	mu     sync.Mutex
+	enc    *json.Encoder
+	w      io.Writer
+	nextID int
+}
+
+//NewRecordingTransport records the traffic of next to w. Use it with
+//z.SetTransport(NewRecordingTransport(z.Transport(), w)).
+func NewRecordingTransport(next Transport, w io.Writer) *RecordingTransport {
+	return &RecordingTransport{
+		next:  next,
+		start: time.Now(),
+		enc:   json.NewEncoder(w),
+		w:     w,
+	}
+}
+
+func (t *RecordingTransport) id() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.nextID++
+	return t.nextID
+}
+
+func (t *RecordingTransport) write(f recordedFrame) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	f.Time = int64(time.Since(t.start) / time.Microsecond)
+	//a failing recording must not break the session being recorded
+	t.enc.Encode(f)
+}
+
+func (t *RecordingTransport) RoundTrip(ctx context.Context, req []byte) ([]byte, error) {
+	id := t.id()
+	//tokens are kept out of recordings, which get shared as fixtures
+	t.write(recordedFrame{Kind: frameRequest, ID: id, Frame: maskToken(req)})
+
+	resp, err := t.next.RoundTrip(ctx, req)
+	f := recordedFrame{Kind: frameResponse, ID: id, Frame: resp}
+	if err != nil {
+		f.Error = err.Error()
+	}
+	t.write(f)
+	return resp, err
+}
+
+func (t *RecordingTransport) Subscribe(identity string, serverKey string) (Subscription, error) {
+	id := t.id()
+	sub, err := t.next.Subscribe(identity, serverKey)
+	f := recordedFrame{Kind: frameSubscribe, ID: id, Identity: identity}
+	if err != nil {
+		f.Error = err.Error()
+	}
+	t.write(f)
+	if err != nil {
+		return nil, err
+	}
+	return &recordingSubscription{t: t, id: id, next: sub}, nil
+}
+
+//Close closes the wrapped transport, and w if it is an io.Closer
+func (t *RecordingTransport) Close() error {
+	err := t.next.Close()
+	if c, ok := t.w.(io.Closer); ok {
+		cerr := c.Close()
+		if err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+type recordingSubscription struct {
+	t    *RecordingTransport
+	id   int
+	next Subscription
+}
+
+func (s *recordingSubscription) Recv() ([]byte, error) {
+	msg, err := s.next.Recv()
+	if err == ErrRecvTimeout {
+		return msg, err
+	}
+	f := recordedFrame{Kind: frameEvent, ID: s.id, Frame: msg}
+	if err != nil {
+		f.Error = err.Error()
+	}
+	s.t.write(f)
+	return msg, err
+}
+
+func (s *recordingSubscription) Close() error {
+	s.t.write(recordedFrame{Kind: frameUnsubscribe, ID: s.id})
+	return s.next.Close()
+}
+
+//ReplayTransport answers requests from a recording made by RecordingTransport,
+//so a captured session can be used as a test fixture without a store. Each
+//request is matched to the first unused recorded request with the same code,
+//options and payload; the token and Uri-Host are ignored as they differ
+//between machines. Subscriptions get their recorded events straight away and
+//the recorded timings are not reproduced, so replays are deterministic.
+type ReplayTransport struct {
+	mu     sync.Mutex
+	frames []recordedFrame
+	used   []bool
+}
+
+//NewReplayTransport reads a recording. Use it with z.SetTransport.
+func NewReplayTransport(r io.Reader) (*ReplayTransport, error) {
+	t := &ReplayTransport{}
+	dec := json.NewDecoder(r)
+	for {
+		var f recordedFrame
+		err := dec.Decode(&f)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, errors.New("reading recording " + err.Error())
+		}
+		t.frames = append(t.frames, f)
+	}
+	t.used = make([]bool, len(t.frames))
+	return t, nil
+}
+
+//reply finds the frame of kind with id that follows index i
+func (t *ReplayTransport) reply(i int, kind string, id int) (recordedFrame, bool) {
+	for _, f := range t.frames[i+1:] {
+		if f.Kind == kind && f.ID == id {
+			return f, true
+		}
+	}
+	return recordedFrame{}, false
+}
+
+func (t *ReplayTransport) RoundTrip(ctx context.Context, req []byte) ([]byte, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for i, f := range t.frames {
+		if t.used[i] || f.Kind != frameRequest || !sameRequest(f.Frame, req) {
+			continue
+		}
+		t.used[i] = true
+		resp, ok := t.reply(i, frameResponse, f.ID)
+		if !ok {
+			return nil, errors.New("replay: request was recorded without a response")
+		}
+		if resp.Error != "" {
+			return nil, errors.New(resp.Error)
+		}
+		return resp.Frame, nil
+	}
+	return nil, errors.New("replay: no recorded request matches")
+}
+
+func (t *ReplayTransport) Subscribe(identity string, serverKey string) (Subscription, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for i, f := range t.frames {
+		if t.used[i] || f.Kind != frameSubscribe || f.Identity != identity {
+			continue
+		}
+		t.used[i] = true
+		if f.Error != "" {
+			return nil, errors.New(f.Error)
+		}
+		sub := &replaySubscription{}
+		for _, e := range t.frames[i+1:] {
+			if e.Kind == frameEvent && e.ID == f.ID {
+				sub.events = append(sub.events, e)
+			}
+		}
+		return sub, nil
+	}
+	return nil, errors.New("replay: no recorded subscription for " + identity)
+}
+
+//Remaining reports how many recorded requests and subscriptions have not been
+//replayed, a test can check it is 0 once the code under test is done
+func (t *ReplayTransport) Remaining() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	n := 0
+	for i, f := range t.frames {
+		if !t.used[i] && (f.Kind == frameRequest || f.Kind == frameSubscribe) {
+			n++
+		}
+	}
+	return n
+}
+
+func (t *ReplayTransport) Close() error {
+	return nil
+}
+
+type replaySubscription struct {
+	events []recordedFrame
+}
+
+func (s *replaySubscription) Recv() ([]byte, error) {
+	if len(s.events) == 0 {
+		//nothing more was recorded, behave like a quiet socket
+		time.Sleep(pollInterval)
+		return nil, ErrRecvTimeout
+	}
+	e := s.events[0]
+	s.events = s.events[1:]
+	if e.Error != "" {
+		return nil, errors.New(e.Error)
+	}
+	return e.Frame, nil
+}
+
+func (s *replaySubscription) Close() error {
+	return nil
+}
+
+//sameRequest compares two request frames ignoring the token and Uri-Host
+func sameRequest(a []byte, b []byte) bool {
+	var ha, hb zestHeader
+	if ha.Parse(a) != nil || hb.Parse(b) != nil {
+		return bytes.Equal(a, b)
+	}
+	if ha.Code != hb.Code || !bytes.Equal(ha.Payload, hb.Payload) {
+		return false
+	}
+	oa, ob := requestOptions(ha), requestOptions(hb)
+	if len(oa) != len(ob) {
+		return false
+	}
+	for i := range oa {
+		if oa[i].Number != ob[i].Number || oa[i].Value != ob[i].Value {
+			return false
+		}
+	}
+	return true
+}
+
+func requestOptions(h zestHeader) []zestOptions {
+	var opts []zestOptions
+	for _, o := range h.Options {
+		if o.Number != 3 {
+			opts = append(opts, o)
+		}
+	}
+	return opts
+}
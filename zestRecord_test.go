@@ -0,0 +1,145 @@
+package zest_test
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"flag"
+	"os"
+	"testing"
+	"time"
+
+	zest "github.com/me-box/goZestClient"
+	"github.com/me-box/goZestClient/zesttest"
+)
+
+var update = flag.Bool("update", false, "record testdata/session.ndjson again against zesttest.Server")
+
+const sessionFile = "testdata/session.ndjson"
+
+//session is the client side of the recorded fixture
+func session(t *testing.T, z *zest.ZestClient) {
+	if _, err := z.Post("", "/kv/test/greeting", []byte("hello"), "TEXT"); err != nil {
+		t.Fatal(err)
+	}
+	value, err := z.Get("", "/kv/test/greeting", "TEXT")
+	if err != nil || string(value) != "hello" {
+		t.Fatalf("got %q %v", value, err)
+	}
+	if _, err := z.Post("", "/ts/test/temp/at/1500000000000", []byte(`{"value":21}`), "JSON"); err != nil {
+		t.Fatal(err)
+	}
+	latest, err := z.Get("", "/ts/test/temp/latest", "JSON")
+	if err != nil || string(latest) != `[{"timestamp":1500000000000,"data":{"value":21}}]` {
+		t.Fatalf("got %s %v", latest, err)
+	}
+
+	events, done, err := z.Observe("", "/kv/test/greeting", "TEXT", zest.ObserveModeData, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer close(done)
+	if _, err := z.Post("", "/kv/test/greeting", []byte("bye"), "TEXT"); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case msg := <-events:
+		event, err := zest.ParseDataEvent(msg)
+		if err != nil || event.Path != "/kv/test/greeting" || string(event.Payload) != "bye" {
+			t.Fatalf("observed %q %v", msg, err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("nothing was observed")
+	}
+}
+
+func TestReplaySession(t *testing.T) {
+	if *update {
+		f, err := os.Create(sessionFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		z := newTestClient(t, zesttest.NewServer())
+		z.SetTransport(zest.NewRecordingTransport(z.Transport(), f))
+		session(t, z)
+		z.Close()
+	}
+
+	data, err := os.ReadFile(sessionFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	replay, err := zest.NewReplayTransport(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	z, err := zest.New("", "", zesttest.ServerKey, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	z.SetTransport(replay)
+	session(t, z)
+	if n := replay.Remaining(); n != 0 {
+		t.Fatalf("%d recorded requests were not replayed", n)
+	}
+}
+
+func TestReplayRefusesUnrecordedRequest(t *testing.T) {
+	data, err := os.ReadFile(sessionFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	replay, err := zest.NewReplayTransport(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	z, _ := zest.New("", "", "", false)
+	z.SetTransport(replay)
+	if _, err := z.Get("", "/kv/test/other", "TEXT"); err == nil {
+		t.Fatal("a request that wasn't recorded should fail")
+	}
+}
+
+func TestRecordingMasksTokens(t *testing.T) {
+	const token = "recorded-token"
+	s := zesttest.NewServer()
+	s.Token = token
+	z := newTestClient(t, s)
+	var recording bytes.Buffer
+	z.SetTransport(zest.NewRecordingTransport(z.Transport(), &recording))
+
+	if _, err := z.Post(token, "/kv/test/greeting", []byte("hello"), "TEXT"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := z.Get(token, "/kv/test/greeting", "TEXT"); err != nil {
+		t.Fatal(err)
+	}
+
+	lines := 0
+	scanner := bufio.NewScanner(bytes.NewReader(recording.Bytes()))
+	for scanner.Scan() {
+		var line struct {
+			Frame []byte `json:"frame"`
+		}
+		if err := json.Unmarshal(scanner.Bytes(), &line); err != nil {
+			t.Fatal(err)
+		}
+		if bytes.Contains(line.Frame, []byte(token)) {
+			t.Fatalf("the token was recorded in %q", line.Frame)
+		}
+		lines++
+	}
+	if lines != 4 {
+		t.Fatalf("%d lines recorded, want 4", lines)
+	}
+
+	//the masked recording still replays
+	replay, err := zest.NewReplayTransport(bytes.NewReader(recording.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	z.SetTransport(replay)
+	if value, err := z.Get(token, "/kv/test/greeting", "TEXT"); err != nil || string(value) != "hello" {
+		t.Fatalf("replayed %q %v", value, err)
+	}
+}
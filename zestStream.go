@@ -0,0 +1,48 @@
+package zest
+
+import (
+	"bufio"
+	"context"
+	"io"
+)
+
+//PostReader posts everything read from r to path. Payloads larger than one
+//block are sent block-wise so only a block at a time is held in memory.
+func (z *ZestClient) PostReader(ctx context.Context, token string, path string, r io.Reader, contentFormat string) ([]byte, error) {
+
+	err := checkContentFormatFormat(contentFormat)
+	if err != nil {
+		return nil, err
+	}
+	defer z.invalidate(path)
+
+	blockSize := z.getBlockSize()
+	if blockSize == 0 {
+		blockSize = defaultBlockSize
+	}
+
+	//look one byte past the first block to see if it all fits in one request
+	br := bufio.NewReaderSize(r, blockSize+1)
+	first, err := br.Peek(blockSize + 1)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	if len(first) <= blockSize {
+		return z.post(ctx, token, path, first, contentFormat)
+	}
+
+	return z.postBlocks(ctx, token, path, br, contentFormat, blockSize, 0)
+}
+
+//GetTo writes the value at path to w and returns the number of bytes written.
+//Block-wise responses are written out a block at a time as they arrive.
+func (z *ZestClient) GetTo(ctx context.Context, token string, path string, w io.Writer, contentFormat string) (int64, error) {
+
+	err := checkContentFormatFormat(contentFormat)
+	if err != nil {
+		return 0, err
+	}
+
+	n, _, err := z.getBlocks(ctx, token, path, w, contentFormat, z.getBlockSize(), 0)
+	return n, err
+}
@@ -0,0 +1,231 @@
+package zest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/me-box/goZestClient/macaroon"
+)
+
+//tokenRefresh is how long before its time caveat runs out a cached token is replaced by default
+const tokenRefresh = time.Minute
+
+//TokenSource provides the token for a request with method on path of the
+//store at host, e.g. GET /ts/temperature on driver-sensors-core-store
+type TokenSource interface {
+	Token(ctx context.Context, host string, path string, method string) (string, error)
+}
+
+//StaticToken is a TokenSource that always returns the same token
+type StaticToken string
+
+func (t StaticToken) Token(ctx context.Context, host string, path string, method string) (string, error) {
+	return string(t), nil
+}
+
+//Host is the store's host name, the target tokens for it are minted for
+func (z *ZestClient) Host() string {
+	u, err := url.Parse(z.Endpoint)
+	if err != nil {
+		return ""
+	}
+	return u.Hostname()
+}
+
+type tokenKey struct {
+	host   string
+	path   string
+	method string
+}
+
+//cachedToken is a token being fetched until ready is closed, then the token
+//or the error fetching it
+type cachedToken struct {
+	ready   chan struct{}
+	token   string
+	err     error
+	fetched time.Time
+	expires time.Time
+}
+
+//ArbiterTokenSource requests tokens from a databox arbiter's /token endpoint
+//and caches them per host, path and method. A token with a time caveat is
+//replaced RefreshBefore it runs out, or halfway through its life if that is
+//sooner. Concurrent requests for the same token share one request to the
+//arbiter, made apart from their contexts so that one caller giving up doesn't
+//fail the others. Any Zest server answering POST /token will do as the
+//arbiter, so a stand-in can be used in tests, as can a client with a
+//ReplayTransport.
+type ArbiterTokenSource struct {
+	Arbiter *ZestClient
+	//ArbiterToken is the app's own token for the arbiter
+	ArbiterToken string
+	//RefreshBefore defaults to a minute
+	RefreshBefore time.Duration
+	//MaxAge limits how long any token is cached, 0 keeps tokens without a time caveat until Invalidate
+	MaxAge time.Duration
+	//FetchTimeout limits each request to the arbiter, 0 for ten seconds
+	FetchTimeout time.Duration
+
+	mu    sync.Mutex
+	cache map[tokenKey]*cachedToken
+}
+
+//NewArbiterTokenSource returns a TokenSource asking the arbiter arbiter talks
+//to for tokens, authenticating with arbiterToken
+func NewArbiterTokenSource(arbiter *ZestClient, arbiterToken string) *ArbiterTokenSource {
+	return &ArbiterTokenSource{Arbiter: arbiter, ArbiterToken: arbiterToken}
+}
+
+//Token returns a cached token for method on path at host, requesting a new one
+//from the arbiter if there isn't one or it is about to expire
+func (a *ArbiterTokenSource) Token(ctx context.Context, host string, path string, method string) (string, error) {
+	key := tokenKey{host: host, path: path, method: strings.ToUpper(method)}
+
+	a.mu.Lock()
+	c, ok := a.cache[key]
+	if ok {
+		select {
+		case <-c.ready:
+			if c.err == nil && a.fresh(c) {
+				a.mu.Unlock()
+				return c.token, nil
+			}
+			ok = false
+		default:
+			//another request is fetching it
+		}
+	}
+	if !ok {
+		c = &cachedToken{ready: make(chan struct{})}
+		if a.cache == nil {
+			a.cache = map[tokenKey]*cachedToken{}
+		}
+		a.cache[key] = c
+		go a.fetch(key, c)
+	}
+	a.mu.Unlock()
+
+	select {
+	case <-c.ready:
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+	return c.token, c.err
+}
+
+//fetch requests the token for key and hands it to everyone waiting on c
+func (a *ArbiterTokenSource) fetch(key tokenKey, c *cachedToken) {
+	timeout := a.FetchTimeout
+	if timeout <= 0 {
+		timeout = requestTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	c.token, c.err = a.request(ctx, key)
+	c.fetched = time.Now()
+	if c.err == nil {
+		if m, err := macaroon.Decode(c.token); err == nil {
+			c.expires, _ = m.Expiry()
+		}
+	}
+	a.mu.Lock()
+	if c.err != nil && a.cache[key] == c {
+		delete(a.cache, key)
+	}
+	a.mu.Unlock()
+	close(c.ready)
+}
+
+//Invalidate drops the cached token for method on path at host, e.g. after the
+//store rejected it, so the next Token call asks the arbiter again
+func (a *ArbiterTokenSource) Invalidate(host string, path string, method string) {
+	key := tokenKey{host: host, path: path, method: strings.ToUpper(method)}
+	a.mu.Lock()
+	delete(a.cache, key)
+	a.mu.Unlock()
+}
+
+//fresh reports whether a fetched token can still be handed out
+func (a *ArbiterTokenSource) fresh(c *cachedToken) bool {
+	now := time.Now()
+	if a.MaxAge > 0 && now.Sub(c.fetched) >= a.MaxAge {
+		return false
+	}
+	if c.expires.IsZero() {
+		return true
+	}
+	refresh := a.RefreshBefore
+	if refresh <= 0 {
+		refresh = tokenRefresh
+	}
+	if life := c.expires.Sub(c.fetched); refresh > life/2 {
+		refresh = life / 2
+	}
+	return now.Before(c.expires.Add(-refresh))
+}
+
+//request asks the arbiter for a token
+func (a *ArbiterTokenSource) request(ctx context.Context, key tokenKey) (string, error) {
+	body, err := json.Marshal(map[string]string{
+		"target": key.host,
+		"path":   key.path,
+		"method": key.method,
+	})
+	if err != nil {
+		return "", err
+	}
+	resp, err := a.Arbiter.post(ctx, a.ArbiterToken, "/token", body, "JSON")
+	if err != nil {
+		return "", errors.New("requesting token for " + key.method + " " + key.host + key.path + ": " + err.Error())
+	}
+
+	//some arbiters return the token as a JSON string
+	var token string
+	if json.Unmarshal(resp, &token) != nil {
+		token = string(bytes.TrimSpace(resp))
+	}
+	if token == "" {
+		return "", errors.New("the arbiter returned an empty token for " + key.method + " " + key.host + key.path)
+	}
+	return token, nil
+}
+
+//SetCheckTokens makes the client decode the macaroon of each request and check
+//its target, path, method and time caveats before sending it, so a token that
+//would be refused with 4.01 fails with a *macaroon.CaveatError saying why.
+//Tokens that aren't macaroons are sent unchecked. The target is the host of
+//Endpoint, so leave this off when talking to stores through a proxy.
+func (z *ZestClient) SetCheckTokens(enabled bool) {
+	z.mu.Lock()
+	z.checkTokens = enabled
+	z.mu.Unlock()
+}
+
+//checkToken checks the token of a request frame if SetCheckTokens is on
+func (z *ZestClient) checkToken(msg []byte) error {
+	z.mu.Lock()
+	enabled := z.checkTokens
+	z.mu.Unlock()
+	if !enabled {
+		return nil
+	}
+
+	zr := zestHeader{}
+	if zr.Parse(msg) != nil || zr.Token == "" {
+		return nil
+	}
+	m, err := macaroon.Decode(zr.Token)
+	if err != nil {
+		return nil
+	}
+	path, _ := zr.option(11)
+	return m.Check(macaroon.Request{Target: z.Host(), Path: path, Method: CodeName(zr.Code)})
+}
@@ -0,0 +1,185 @@
+package zest_test
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	zest "github.com/me-box/goZestClient"
+	"github.com/me-box/goZestClient/zesttest"
+)
+
+//arbiter is a stand-in arbiter handing out numbered tokens
+type arbiter struct {
+	s        *zesttest.Server
+	requests int32
+	//release, when set, holds each request until it is closed
+	release chan struct{}
+	//fail makes the next request fail
+	fail int32
+	//token, when set, is returned instead of a numbered one
+	token string
+}
+
+func newArbiter() *arbiter {
+	a := &arbiter{s: zesttest.NewServer()}
+	a.s.Handle = func(req zest.Frame) (zest.Frame, bool) {
+		n := atomic.AddInt32(&a.requests, 1)
+		if a.release != nil {
+			<-a.release
+		}
+		if atomic.CompareAndSwapInt32(&a.fail, 1, 0) {
+			return zest.Frame{Code: 129}, true
+		}
+		var body map[string]string
+		json.Unmarshal(req.Payload, &body)
+		token := a.token
+		if token == "" {
+			token = body["method"] + " " + body["target"] + body["path"] + " " + strconv.Itoa(int(n)) + " " + req.Token
+		}
+		return zest.Frame{Code: 65, Payload: []byte(token)}, true
+	}
+	return a
+}
+
+func (a *arbiter) source(t *testing.T) *zest.ArbiterTokenSource {
+	return zest.NewArbiterTokenSource(newTestClient(t, a.s), "app-token")
+}
+
+func (a *arbiter) count() int {
+	return int(atomic.LoadInt32(&a.requests))
+}
+
+func TestArbiterTokenIsCached(t *testing.T) {
+	a := newArbiter()
+	src := a.source(t)
+	ctx := context.Background()
+
+	token, err := src.Token(ctx, "store", "/kv/a", "get")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token != "GET store/kv/a 1 app-token" {
+		t.Fatalf("got %q", token)
+	}
+	again, _ := src.Token(ctx, "store", "/kv/a", "GET")
+	if again != token || a.count() != 1 {
+		t.Fatalf("got %q after %d requests, want the cached token", again, a.count())
+	}
+
+	other, _ := src.Token(ctx, "store", "/kv/b", "GET")
+	if other == token || a.count() != 2 {
+		t.Fatal("another path needs its own token")
+	}
+
+	src.Invalidate("store", "/kv/a", "GET")
+	if fresh, _ := src.Token(ctx, "store", "/kv/a", "GET"); fresh == token {
+		t.Fatal("an invalidated token was handed out again")
+	}
+}
+
+func TestArbiterFetchIsShared(t *testing.T) {
+	a := newArbiter()
+	a.release = make(chan struct{})
+	src := a.source(t)
+
+	var wg sync.WaitGroup
+	tokens := make([]string, 5)
+	for i := range tokens {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			tokens[i], _ = src.Token(context.Background(), "store", "/kv/a", "GET")
+		}(i)
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(a.release)
+	wg.Wait()
+
+	if a.count() != 1 {
+		t.Fatalf("made %d requests, want 1", a.count())
+	}
+	for _, token := range tokens {
+		if token != tokens[0] || token == "" {
+			t.Fatalf("got tokens %q", tokens)
+		}
+	}
+}
+
+func TestArbiterWaitersUseTheirOwnContext(t *testing.T) {
+	a := newArbiter()
+	a.release = make(chan struct{})
+	src := a.source(t)
+
+	//the caller that starts the fetch gives up
+	first, cancel := context.WithCancel(context.Background())
+	failed := make(chan error, 1)
+	go func() {
+		_, err := src.Token(first, "store", "/kv/a", "GET")
+		failed <- err
+	}()
+	for a.count() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	got := make(chan string, 1)
+	go func() {
+		token, _ := src.Token(context.Background(), "store", "/kv/a", "GET")
+		got <- token
+	}()
+
+	cancel()
+	if err := <-failed; err != context.Canceled {
+		t.Fatalf("got %v, want context.Canceled", err)
+	}
+	close(a.release)
+	if token := <-got; token != "GET store/kv/a 1 app-token" {
+		t.Fatalf("the other caller got %q", token)
+	}
+	if a.count() != 1 {
+		t.Fatalf("made %d requests, want 1", a.count())
+	}
+}
+
+func TestArbiterErrorsAreNotCached(t *testing.T) {
+	a := newArbiter()
+	a.fail = 1
+	src := a.source(t)
+
+	if _, err := src.Token(context.Background(), "store", "/kv/a", "GET"); err == nil {
+		t.Fatal("the arbiter's refusal should be returned")
+	}
+	token, err := src.Token(context.Background(), "store", "/kv/a", "GET")
+	if err != nil || a.count() != 2 {
+		t.Fatalf("got %q %v after %d requests", token, err, a.count())
+	}
+}
+
+func TestArbiterTokenRefresh(t *testing.T) {
+	a := newArbiter()
+	src := a.source(t)
+	src.MaxAge = 20 * time.Millisecond
+
+	first, _ := src.Token(context.Background(), "store", "/kv/a", "GET")
+	time.Sleep(30 * time.Millisecond)
+	second, _ := src.Token(context.Background(), "store", "/kv/a", "GET")
+	if first == second {
+		t.Fatal("a token past MaxAge should be replaced")
+	}
+
+	//a macaroon is replaced halfway through its life when that is sooner than RefreshBefore
+	a.token = `{"v":2,"i":"id","c":[{"i":"time < ` + time.Now().Add(40*time.Millisecond).UTC().Format(time.RFC3339Nano) + `"}],"s64":""}`
+	src = a.source(t)
+	src.Token(context.Background(), "store", "/kv/a", "GET")
+	src.Token(context.Background(), "store", "/kv/a", "GET")
+	before := a.count()
+	time.Sleep(30 * time.Millisecond)
+	src.Token(context.Background(), "store", "/kv/a", "GET")
+	if a.count() != before+1 {
+		t.Fatal("a macaroon near its time caveat should be replaced")
+	}
+}
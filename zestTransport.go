@@ -0,0 +1,185 @@
+package zest
+
+import (
+	"context"
+	"errors"
+	"syscall"
+	"time"
+
+	zmq "github.com/pebbe/zmq4"
+)
+
+//ErrRecvTimeout is returned by Subscription.Recv when nothing arrived in time,
+//the caller should check whether it still wants to listen and call Recv again
+var ErrRecvTimeout = errors.New("receive timeout")
+
+//ErrRequestTimeout is returned when the store doesn't answer a request within requestTimeout
+var ErrRequestTimeout = errors.New("timeout reading from router")
+
+//Transport moves Zest frames between the client and a store. The default one
+//sends requests on pooled REQ sockets and receives Observe and Notify messages
+//on DEALER sockets. RecordingTransport and ReplayTransport wrap or stand in for it.
+type Transport interface {
+	//RoundTrip sends a request frame and returns the response frame
+	RoundTrip(ctx context.Context, req []byte) ([]byte, error)
+	//Subscribe starts receiving the frames the router sends to identity,
+	//serverKey is the key the store returned with the Observe or Notify response
+	Subscribe(identity string, serverKey string) (Subscription, error)
+	//Close is called when the client is closed
+	Close() error
+}
+
+//Subscription receives the frames routed to one identity. It is only used
+//from a single goroutine.
+type Subscription interface {
+	//Recv waits a short while for the next frame, returning ErrRecvTimeout if there isn't one
+	Recv() ([]byte, error)
+	Close() error
+}
+
+//SetTransport replaces the transport used for every later request and
+//subscription. It should be called before the client is in use.
+func (z *ZestClient) SetTransport(t Transport) {
+	z.mu.Lock()
+	z.transport = t
+	z.mu.Unlock()
+}
+
+//Transport returns the transport in use, the zmq one unless SetTransport was called
+func (z *ZestClient) Transport() Transport {
+	z.mu.Lock()
+	defer z.mu.Unlock()
+	if z.transport == nil {
+		z.transport = zmqTransport{z}
+	}
+	return z.transport
+}
+
+//zmqTransport talks to a store over CURVE authenticated zmq sockets, using the
+//client's socket pool and keys
+type zmqTransport struct {
+	z *ZestClient
+}
+
+//RoundTrip sends req on a pooled request socket and waits for the reply, giving
+//up when ctx is done or after requestTimeout
+func (t zmqTransport) RoundTrip(ctx context.Context, req []byte) ([]byte, error) {
+	z := t.z
+
+	ZMQsoc, err := z.getSocket()
+	if err == ErrClientClosed {
+		return nil, err
+	}
+	if err != nil {
+		return nil, errors.New("Can't connect so server")
+	}
+
+	_, err = ZMQsoc.SendBytes(req, 0)
+	if err != nil {
+		ZMQsoc.Close()
+		return nil, err
+	}
+
+	//poll in short steps so a cancelled ctx is noticed promptly
+	poller := zmq.NewPoller()
+	poller.Add(ZMQsoc, zmq.POLLIN)
+	deadline := time.Now().Add(requestTimeout)
+	for {
+		select {
+		case <-ctx.Done():
+			ZMQsoc.Close()
+			return nil, ctx.Err()
+		default:
+		}
+
+		wait := time.Until(deadline)
+		if wait <= 0 {
+			ZMQsoc.Close()
+			z.log("timeout reading from router")
+			return nil, ErrRequestTimeout
+		}
+		if wait > pollInterval {
+			wait = pollInterval
+		}
+
+		polled, err := poller.Poll(wait)
+		if err != nil {
+			ZMQsoc.Close()
+			return nil, err
+		}
+		if len(polled) > 0 {
+			break
+		}
+	}
+
+	resp, err := ZMQsoc.RecvBytes(0)
+	if err != nil {
+		ZMQsoc.Close()
+		return nil, err
+	}
+	z.putSocket(ZMQsoc)
+
+	return resp, nil
+}
+
+//Subscribe opens a DEALER socket on the router endpoint with the given identity
+func (t zmqTransport) Subscribe(identity string, serverKey string) (Subscription, error) {
+	z := t.z
+
+	dealer, err := zmq.NewSocket(zmq.DEALER)
+	if err != nil {
+		return nil, err
+	}
+	dealer.SetRcvtimeo(time.Second * 1)
+	dealer.SetConnectTimeout(time.Second * 10)
+
+	err = dealer.SetIdentity(identity)
+	if err != nil {
+		dealer.Close()
+		return nil, errors.New("dealer.SetIdentity " + err.Error())
+	}
+
+	z.log("Using serverKey " + serverKey)
+	clientPublic, clientSecret, err := z.clientKeys()
+	if err != nil {
+		dealer.Close()
+		return nil, err
+	}
+
+	err = dealer.ClientAuthCurve(serverKey, clientPublic, clientSecret)
+	if err != nil {
+		dealer.Close()
+		return nil, errors.New("ClientAuthCurve " + err.Error())
+	}
+
+	err = dealer.Connect(z.DealerEndpoint)
+	if err != nil {
+		dealer.Close()
+		return nil, errors.New("dealer.Connect " + err.Error())
+	}
+
+	return zmqSubscription{dealer}, nil
+}
+
+//Close has nothing to do, the client closes the socket pool itself
+func (t zmqTransport) Close() error {
+	return nil
+}
+
+type zmqSubscription struct {
+	dealer *zmq.Socket
+}
+
+func (s zmqSubscription) Recv() ([]byte, error) {
+	msg, err := s.dealer.RecvBytes(0)
+	//a receive interrupted by a signal is retried like one that timed out
+	if err != nil && (zmq.AsErrno(err) == zmq.Errno(syscall.EAGAIN) || zmq.AsErrno(err) == zmq.Errno(syscall.EINTR)) {
+		return nil, ErrRecvTimeout
+	}
+	return msg, err
+}
+
+func (s zmqSubscription) Close() error {
+	s.dealer.SetLinger(0)
+	return s.dealer.Close()
+}
@@ -0,0 +1,117 @@
+package zest_test
+
+import (
+	"errors"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	zest "github.com/me-box/goZestClient"
+	"github.com/me-box/goZestClient/zesttest"
+)
+
+//TestSharedClient uses one client from many goroutines at once, run it with
+//go test -race
+func TestSharedClient(t *testing.T) {
+	s := zesttest.NewServer()
+	z := newTestClient(t, s)
+
+	events, done, err := z.Observe("", "/kv/shared/*", "TEXT", zest.ObserveModeData, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	observed := make(chan int)
+	go func() {
+		n := 0
+		for range events {
+			n++
+		}
+		observed <- n
+	}()
+
+	const writers = 8
+	const writes = 20
+	var wg sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			path := "/kv/shared/" + strconv.Itoa(w)
+			for i := 0; i < writes; i++ {
+				value := []byte(strconv.Itoa(i))
+				if _, err := z.Post("", path, value, "TEXT"); err != nil {
+					t.Error(err)
+					return
+				}
+				got, err := z.Get("", path, "TEXT")
+				if err != nil || string(got) != string(value) {
+					t.Errorf("%s: got %q %v, want %q", path, got, err, value)
+					return
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	close(done)
+	select {
+	case <-observed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the Observe didn't end when done was closed")
+	}
+	if err := z.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+//brokenTransport hands out subscriptions that fail every Recv
+type brokenTransport struct {
+	*zesttest.Server
+	mu    sync.Mutex
+	recvs int
+}
+
+func (b *brokenTransport) Subscribe(identity string, serverKey string) (zest.Subscription, error) {
+	return brokenSubscription{b}, nil
+}
+
+type brokenSubscription struct{ b *brokenTransport }
+
+func (s brokenSubscription) Recv() ([]byte, error) {
+	s.b.mu.Lock()
+	s.b.recvs++
+	s.b.mu.Unlock()
+	return nil, errors.New("socket closed")
+}
+
+func (s brokenSubscription) Close() error {
+	return nil
+}
+
+func TestObserveEndsOnReceiveError(t *testing.T) {
+	b := &brokenTransport{Server: zesttest.NewServer()}
+	z, err := zest.New("", "", "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	z.SetTransport(b)
+
+	events, _, err := z.Observe("", "/kv/test/greeting", "TEXT", zest.ObserveModeData, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case _, ok := <-events:
+		if ok {
+			t.Fatal("got an event from a broken subscription")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the Observe kept going after its socket broke")
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.recvs != 1 {
+		t.Fatalf("received %d times after an error, want 1", b.recvs)
+	}
+}
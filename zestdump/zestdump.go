@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+
+	zest "github.com/me-box/goZestClient"
+)
+
+func main() {
+	in := flag.String("in", "auto", "what the input holds: hex (plain or a hex dump), raw (one binary frame), recording (a --record file) or auto to guess")
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: zestdump [flags] [file | hex...]")
+		fmt.Fprintln(os.Stderr, "\nDecode Zest frames into their code, token, options and payload.")
+		fmt.Fprintln(os.Stderr, "The input is the file, the hex given as arguments or stdin.\n\nFlags:")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	data, err := readInput(flag.Args())
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "zestdump: "+err.Error())
+		os.Exit(1)
+	}
+
+	err = dump(os.Stdout, data, *in)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "zestdump: "+err.Error())
+		os.Exit(1)
+	}
+}
+
+//readInput reads a single file argument, joins hex arguments or reads stdin
+func readInput(args []string) ([]byte, error) {
+	if len(args) == 0 || (len(args) == 1 && args[0] == "-") {
+		return ioutil.ReadAll(os.Stdin)
+	}
+	if len(args) == 1 {
+		if _, err := os.Stat(args[0]); err == nil {
+			return ioutil.ReadFile(args[0])
+		}
+	}
+	var b bytes.Buffer
+	for _, a := range args {
+		b.WriteString(a + " ")
+	}
+	return b.Bytes(), nil
+}
+
+func dump(w io.Writer, data []byte, in string) error {
+	if in == "auto" {
+		in = guess(data)
+	}
+
+	switch in {
+	case "recording":
+		return zest.DumpRecording(bytes.NewReader(data), w)
+	case "hex":
+		frame, err := zest.ParseHex(string(data))
+		if err != nil {
+			return err
+		}
+		data = frame
+	case "raw":
+	default:
+		return fmt.Errorf("unknown input %s, use hex, raw, recording or auto", in)
+	}
+
+	_, err := fmt.Fprint(w, zest.DumpFrame(data))
+	return err
+}
+
+//guess tells recordings, hex and raw frames apart by their first bytes
+func guess(data []byte) string {
+	trimmed := bytes.TrimSpace(data)
+	if len(trimmed) > 0 && trimmed[0] == '{' {
+		return "recording"
+	}
+	if _, err := zest.ParseHex(string(trimmed)); err == nil && len(trimmed) > 0 {
+		return "hex"
+	}
+	return "raw"
+}
@@ -0,0 +1,645 @@
+//Package zesttest runs an in-memory Zest store for tests. A Server is a
+//zest.Transport, so a client is pointed at it with SetTransport and no
+//sockets, keys or running store are needed:
+//
+//	s := zesttest.NewServer()
+//	z, _ := zest.New("", "", "", false)
+//	z.SetTransport(s)
+//
+//Paths under /ts/ behave as time series and every other path as a key value
+//store. Observe, Notify, block-wise transfers, ETags and conditional writes
+//work as they do with the real store.
+package zesttest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	zest "github.com/me-box/goZestClient"
+)
+
+//Request methods and the response codes the server answers with
+const (
+	codeGet    = 1
+	codePost   = 2
+	codeDelete = 4
+
+	codeCreated            = 65
+	codeDeleted            = 66
+	codeValid              = 67
+	codeContent            = 69
+	codeContinue           = 95
+	codeBadRequest         = 128
+	codeUnauthorized       = 129
+	codeIncomplete         = 136
+	codePreconditionFailed = 140
+	codeTooLarge           = 141
+)
+
+//Option numbers
+const (
+	optionIfMatch       = 1
+	optionETag          = 4
+	optionIfNoneMatch   = 5
+	optionObserve       = 6
+	optionPath          = 11
+	optionContentFormat = 12
+	optionMaxAge        = 14
+	optionQuery         = 15
+	optionBlock2        = 23
+	optionBlock1        = 27
+	optionSize1         = 60
+	optionServerKey     = 2048
+)
+
+//ServerKey is sent with Observe and Notify responses
+const ServerKey = "zesttest-server-key"
+
+//Server is an in-memory store. The zero value is not usable, use NewServer.
+type Server struct {
+	//Token, if set, is the only token accepted, other requests get 4.01
+	Token string
+	//BlockSize, if set, splits content longer than it block-wise. It is
+	//rounded down to a block size Zest allows, a power of two from 16 to 1024.
+	BlockSize int
+	//MaxPayload, if set, answers larger requests sent in one go with 4.13 and Size1
+	MaxPayload int
+	//MaxAge, if set, is sent with every 2.05 and 2.03 response to a Get
+	MaxAge uint32
+	//Handle, if set, sees every request first and answers it instead of the
+	//store when it returns true
+	Handle func(req zest.Frame) (zest.Frame, bool)
+	//Now is the clock for time series writes, time.Now if nil
+	Now func() time.Time
+
+	mu       sync.Mutex
+	kv       map[string]*value
+	ts       map[string][]Point
+	version  int
+	uploads  map[string][]byte
+	queues   map[string]*queue
+	watches  []watch
+	nextID   int
+	requests []zest.Frame
+}
+
+type value struct {
+	data   []byte
+	format uint16
+	etag   string
+}
+
+//Point is one value of a time series, Timestamp is in milliseconds since the epoch
+type Point struct {
+	Timestamp int64
+	Data      []byte
+}
+
+//watch is an Observe, or a Notify when once is set
+type watch struct {
+	path     string
+	mode     zest.ObserveMode
+	identity string
+	once     bool
+}
+
+//queue holds the frames routed to an identity until they are received
+type queue struct {
+	frames chan []byte
+}
+
+//NewServer returns an empty store
+func NewServer() *Server {
+	return &Server{
+		kv:      map[string]*value{},
+		ts:      map[string][]Point{},
+		uploads: map[string][]byte{},
+		queues:  map[string]*queue{},
+	}
+}
+
+//Set stores a key value, as a Post to path would
+func (s *Server) Set(path string, data []byte, contentFormat string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.setValue(path, data, formatID(contentFormat))
+}
+
+//Value returns the value stored at path
+func (s *Server) Value(path string) ([]byte, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	v, ok := s.kv[path]
+	if !ok {
+		return nil, false
+	}
+	return append([]byte(nil), v.data...), true
+}
+
+//AddPoint adds a value to the time series at path
+func (s *Server) AddPoint(path string, timestamp int64, data []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.addPoint(path, timestamp, data)
+}
+
+//Points returns the time series at path in time order, values with the same
+//timestamp in the order they were written
+func (s *Server) Points(path string) []Point {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Point(nil), s.ts[path]...)
+}
+
+//Requests returns every request received so far, decoded
+func (s *Server) Requests() []zest.Frame {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]zest.Frame(nil), s.requests...)
+}
+
+//Observers is the number of Observe and Notify subscriptions still open
+func (s *Server) Observers() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.watches)
+}
+
+//Push routes a frame to every Observe and Notify on path, as a write would
+func (s *Server) Push(path string, payload []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.publish(path, payload)
+}
+
+//RoundTrip answers one request
+func (s *Server) RoundTrip(ctx context.Context, req []byte) ([]byte, error) {
+	f, err := zest.DecodeFrame(req)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	s.requests = append(s.requests, f)
+	handle := s.Handle
+	s.mu.Unlock()
+
+	if handle != nil {
+		if resp, ok := handle(f); ok {
+			return zest.EncodeFrame(resp)
+		}
+	}
+
+	s.mu.Lock()
+	resp := s.serve(f)
+	s.audit(f, resp.Code)
+	s.mu.Unlock()
+	return zest.EncodeFrame(resp)
+}
+
+//Subscribe receives the frames routed to identity
+func (s *Server) Subscribe(identity string, serverKey string) (zest.Subscription, error) {
+	if serverKey != ServerKey {
+		return nil, errors.New("zesttest: wrong server key " + strconv.Quote(serverKey))
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return &subscription{s: s, identity: identity, q: s.queue(identity)}, nil
+}
+
+//Close does nothing, a server can be shared by several clients
+func (s *Server) Close() error {
+	return nil
+}
+
+func (s *Server) queue(identity string) *queue {
+	q, ok := s.queues[identity]
+	if !ok {
+		q = &queue{frames: make(chan []byte, 1024)}
+		s.queues[identity] = q
+	}
+	return q
+}
+
+type subscription struct {
+	s        *Server
+	identity string
+	q        *queue
+}
+
+func (sub *subscription) Recv() ([]byte, error) {
+	select {
+	case frame := <-sub.q.frames:
+		return frame, nil
+	case <-time.After(20 * time.Millisecond):
+		return nil, zest.ErrRecvTimeout
+	}
+}
+
+//Close ends the Observe or Notify behind the subscription
+func (sub *subscription) Close() error {
+	s := sub.s
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	watches := s.watches[:0]
+	for _, w := range s.watches {
+		if w.identity != sub.identity {
+			watches = append(watches, w)
+		}
+	}
+	s.watches = watches
+	delete(s.queues, sub.identity)
+	return nil
+}
+
+//serve answers a request, s.mu is held
+func (s *Server) serve(f zest.Frame) zest.Frame {
+	if s.Token != "" && f.Token != s.Token {
+		return zest.Frame{Code: codeUnauthorized}
+	}
+	path, _ := f.Option(optionPath)
+	if len(path) == 0 {
+		return zest.Frame{Code: codeBadRequest}
+	}
+
+	switch f.Code {
+	case codeGet:
+		if mode, ok := f.Option(optionObserve); ok {
+			return s.watch(string(path), zest.ObserveMode(mode), false)
+		}
+		if _, ok := f.Option(optionMaxAge); ok {
+			return s.watch(string(path), "", true)
+		}
+		return s.get(f, string(path))
+	case codePost:
+		return s.post(f, string(path))
+	case codeDelete:
+		return s.remove(string(path))
+	}
+	return zest.Frame{Code: codeBadRequest}
+}
+
+//watch registers an Observe or Notify. Observe identities are made up by the
+//server and returned in the payload, a Notify is routed to zest.NotifyIdentity
+//of its path.
+func (s *Server) watch(path string, mode zest.ObserveMode, once bool) zest.Frame {
+	identity := zest.NotifyIdentity(path)
+	if !once {
+		s.nextID++
+		identity = "observe-" + strconv.Itoa(s.nextID)
+	}
+	s.queue(identity)
+	s.watches = append(s.watches, watch{path: path, mode: mode, identity: identity, once: once})
+
+	resp := zest.Frame{Code: codeContent, Options: []zest.FrameOption{{Number: optionServerKey, Value: []byte(ServerKey)}}}
+	if !once {
+		resp.Payload = []byte(identity)
+	}
+	return resp
+}
+
+//publish routes a data event for a write to path to every watch on it
+func (s *Server) publish(path string, payload []byte) {
+	watches := s.watches[:0]
+	for _, w := range s.watches {
+		if w.mode == zest.ObserveModeAudit || !matches(w.path, path) {
+			watches = append(watches, w)
+			continue
+		}
+		s.route(w.identity, payload)
+		if !w.once {
+			watches = append(watches, w)
+		}
+	}
+	s.watches = watches
+}
+
+//audit routes an audit event for a request to every audit watch on its path
+func (s *Server) audit(f zest.Frame, code uint8) {
+	path, _ := f.Option(optionPath)
+	for _, w := range s.watches {
+		if w.mode == zest.ObserveModeAudit && matches(w.path, string(path)) {
+			host, _ := f.Option(3)
+			event := strconv.FormatInt(s.now(), 10) + " " + string(host) + " " + f.CodeName + " " + string(path) + " " + strconv.Itoa(int(code))
+			s.route(w.identity, []byte(event))
+		}
+	}
+}
+
+func (s *Server) route(identity string, payload []byte) {
+	frame, err := zest.EncodeFrame(zest.Frame{Code: codeContent, Payload: payload})
+	if err != nil {
+		return
+	}
+	select {
+	case s.queue(identity).frames <- frame:
+	default:
+		//nobody is receiving, drop it as a router would
+	}
+}
+
+//matches reports whether a write to path is seen by a watch on pattern,
+//which may end in /* for every key below it
+func matches(pattern string, path string) bool {
+	if strings.HasSuffix(pattern, "/*") {
+		return strings.HasPrefix(path, strings.TrimSuffix(pattern, "*"))
+	}
+	return pattern == path
+}
+
+func (s *Server) get(f zest.Frame, path string) zest.Frame {
+	if strings.HasPrefix(path, "/ts/") {
+		data, ok := s.query(path)
+		if !ok {
+			return zest.Frame{Code: codeBadRequest}
+		}
+		return s.content(f, data, nil)
+	}
+
+	if strings.HasSuffix(path, "/keys") {
+		prefix := strings.TrimSuffix(path, "keys")
+		keys := []string{}
+		for p := range s.kv {
+			if strings.HasPrefix(p, prefix) && !strings.Contains(p[len(prefix):], "/") {
+				keys = append(keys, p[len(prefix):])
+			}
+		}
+		sort.Strings(keys)
+		data, _ := json.Marshal(keys)
+		return s.content(f, data, nil)
+	}
+
+	v, ok := s.kv[path]
+	if !ok {
+		//a missing key reads as empty, without an ETag
+		return s.content(f, nil, nil)
+	}
+	options := []zest.FrameOption{
+		{Number: optionETag, Value: []byte(v.etag)},
+		{Number: optionContentFormat, Value: packUint(uint32(v.format))},
+	}
+	if etag, ok := f.Option(optionETag); ok && string(etag) == v.etag {
+		return s.withMaxAge(zest.Frame{Code: codeValid, Options: options[:1]})
+	}
+	return s.content(f, v.data, options)
+}
+
+//content answers a Get with data, a block of it if the request asked for one
+//or it is longer than BlockSize
+func (s *Server) content(f zest.Frame, data []byte, options []zest.FrameOption) zest.Frame {
+	resp := zest.Frame{Code: codeContent, Options: options, Payload: data}
+
+	size, num := s.blockSize(), uint32(0)
+	if v, ok := f.Option(optionBlock2); ok {
+		var szx uint8
+		num, _, szx = parseBlock(v)
+		if size == 0 || 16<<szx < size {
+			size = 16 << szx
+		}
+	} else if size == 0 || len(data) <= size {
+		return s.withMaxAge(resp)
+	}
+
+	start := int(num) * size
+	if start > len(data) {
+		return zest.Frame{Code: codeBadRequest}
+	}
+	end := start + size
+	if end > len(data) {
+		end = len(data)
+	}
+	resp.Payload = data[start:end]
+	resp.Options = append(resp.Options, zest.FrameOption{Number: optionBlock2, Value: blockValue(num, end < len(data), size)})
+	return s.withMaxAge(resp)
+}
+
+//blockSize is BlockSize rounded down to a power of two from 16 to 1024, or 0
+//if it isn't set
+func (s *Server) blockSize() int {
+	if s.BlockSize <= 0 {
+		return 0
+	}
+	size := 16
+	for size < 1024 && size*2 <= s.BlockSize {
+		size *= 2
+	}
+	return size
+}
+
+func (s *Server) withMaxAge(resp zest.Frame) zest.Frame {
+	if s.MaxAge > 0 {
+		resp.Options = append(resp.Options, zest.FrameOption{Number: optionMaxAge, Value: packUint(s.MaxAge)})
+	}
+	return resp
+}
+
+func (s *Server) post(f zest.Frame, path string) zest.Frame {
+	payload := f.Payload
+	var ack []zest.FrameOption
+
+	if v, ok := f.Option(optionBlock1); ok {
+		num, more, szx := parseBlock(v)
+		size := 16 << szx
+		if num == 0 {
+			s.uploads[path] = nil
+		}
+		if len(s.uploads[path]) != int(num)*size {
+			delete(s.uploads, path)
+			return zest.Frame{Code: codeIncomplete}
+		}
+		s.uploads[path] = append(s.uploads[path], payload...)
+		ack = []zest.FrameOption{{Number: optionBlock1, Value: v}}
+		if more {
+			return zest.Frame{Code: codeContinue, Options: ack}
+		}
+		payload = s.uploads[path]
+		delete(s.uploads, path)
+	} else if s.MaxPayload > 0 && len(payload) > s.MaxPayload {
+		return zest.Frame{Code: codeTooLarge, Options: []zest.FrameOption{{Number: optionSize1, Value: packUint(uint32(s.MaxPayload))}}}
+	}
+
+	format := uint16(0)
+	if v, ok := f.Option(optionContentFormat); ok {
+		format = uint16(unpackUint(v))
+	}
+
+	if strings.HasPrefix(path, "/ts/") {
+		series, ts := path, s.now()
+		if m := atPattern.FindStringSubmatch(path); m != nil {
+			series = m[1]
+			ts, _ = strconv.ParseInt(m[2], 10, 64)
+		}
+		s.addPoint(series, ts, payload)
+		s.publish(series, dataEvent(ts, series, format, payload))
+		return zest.Frame{Code: codeCreated, Options: ack}
+	}
+
+	current, exists := s.kv[path]
+	if etag, ok := f.Option(optionIfMatch); ok && (!exists || string(etag) != current.etag) {
+		return zest.Frame{Code: codePreconditionFailed}
+	}
+	if _, ok := f.Option(optionIfNoneMatch); ok && exists {
+		return zest.Frame{Code: codePreconditionFailed}
+	}
+	v := s.setValue(path, payload, format)
+	s.publish(path, dataEvent(s.now(), path, format, payload))
+	return zest.Frame{Code: codeCreated, Options: append(ack, zest.FrameOption{Number: optionETag, Value: []byte(v.etag)})}
+}
+
+//remove deletes path and every key below it
+func (s *Server) remove(path string) zest.Frame {
+	delete(s.ts, path)
+	for p := range s.kv {
+		if p == path || strings.HasPrefix(p, path+"/") {
+			delete(s.kv, p)
+		}
+	}
+	return zest.Frame{Code: codeDeleted}
+}
+
+func (s *Server) setValue(path string, data []byte, format uint16) *value {
+	s.version++
+	v := &value{data: append([]byte(nil), data...), format: format, etag: "v" + strconv.Itoa(s.version)}
+	s.kv[path] = v
+	return v
+}
+
+func (s *Server) addPoint(path string, timestamp int64, data []byte) {
+	points := s.ts[path]
+	i := sort.Search(len(points), func(i int) bool { return points[i].Timestamp > timestamp })
+	points = append(points, Point{})
+	copy(points[i+1:], points[i:])
+	points[i] = Point{Timestamp: timestamp, Data: append([]byte(nil), data...)}
+	s.ts[path] = points
+}
+
+func (s *Server) now() int64 {
+	now := time.Now
+	if s.Now != nil {
+		now = s.Now
+	}
+	return now().UnixNano() / int64(time.Millisecond)
+}
+
+var (
+	atPattern    = regexp.MustCompile(`^(/ts/.+)/at/(-?\d+)$`)
+	queryPattern = regexp.MustCompile(`^(/ts/.+?)/(latest|earliest|last/(\d+)|first/(\d+)|since/(-?\d+)|range/(-?\d+)/(-?\d+))$`)
+)
+
+//query answers a time series query with the matching values newest first
+func (s *Server) query(path string) ([]byte, bool) {
+	m := queryPattern.FindStringSubmatch(path)
+	if m == nil {
+		return nil, false
+	}
+	points := s.ts[m[1]]
+	op := strings.SplitN(m[2], "/", 2)[0]
+
+	var selected []Point
+	switch op {
+	case "latest", "last":
+		n := 1
+		if op == "last" {
+			n, _ = strconv.Atoi(m[3])
+		}
+		if n > len(points) {
+			n = len(points)
+		}
+		selected = points[len(points)-n:]
+	case "earliest", "first":
+		n := 1
+		if op == "first" {
+			n, _ = strconv.Atoi(m[4])
+		}
+		if n > len(points) {
+			n = len(points)
+		}
+		selected = points[:n]
+	case "since":
+		from, _ := strconv.ParseInt(m[5], 10, 64)
+		for _, p := range points {
+			if p.Timestamp >= from {
+				selected = append(selected, p)
+			}
+		}
+	case "range":
+		from, _ := strconv.ParseInt(m[6], 10, 64)
+		to, _ := strconv.ParseInt(m[7], 10, 64)
+		for _, p := range points {
+			if p.Timestamp >= from && p.Timestamp <= to {
+				selected = append(selected, p)
+			}
+		}
+	}
+
+	type result struct {
+		Timestamp int64           `json:"timestamp"`
+		Data      json.RawMessage `json:"data"`
+	}
+	results := []result{}
+	for i := len(selected) - 1; i >= 0; i-- {
+		data := json.RawMessage(selected[i].Data)
+		if !json.Valid(data) {
+			data, _ = json.Marshal(string(selected[i].Data))
+		}
+		results = append(results, result{Timestamp: selected[i].Timestamp, Data: data})
+	}
+	out, err := json.Marshal(results)
+	return out, err == nil
+}
+
+//dataEvent is what the store sends to data mode observers of path
+func dataEvent(ts int64, path string, format uint16, payload []byte) []byte {
+	name := "text"
+	if cf, ok := zest.ContentFormatByID(format); ok {
+		name = strings.ToLower(cf.Name)
+	}
+	return append([]byte(strconv.FormatInt(ts, 10)+" "+path+" "+name+" "), payload...)
+}
+
+func formatID(contentFormat string) uint16 {
+	cf, _ := zest.LookupContentFormat(contentFormat)
+	return cf.ID
+}
+
+//block options are NUM<<4 | M<<3 | SZX as a minimal big endian uint
+func parseBlock(v []byte) (uint32, bool, uint8) {
+	n := unpackUint(v)
+	return n >> 4, n&0x8 != 0, uint8(n & 0x7)
+}
+
+func blockValue(num uint32, more bool, size int) []byte {
+	var szx uint32
+	for 16<<szx < size {
+		szx++
+	}
+	n := num<<4 | szx
+	if more {
+		n |= 0x8
+	}
+	return packUint(n)
+}
+
+func packUint(v uint32) []byte {
+	var b []byte
+	for v > 0 {
+		b = append([]byte{byte(v)}, b...)
+		v >>= 8
+	}
+	return b
+}
+
+func unpackUint(b []byte) uint32 {
+	var v uint32
+	for _, c := range b {
+		v = v<<8 | uint32(c)
+	}
+	return v
+}